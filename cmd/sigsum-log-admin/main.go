@@ -0,0 +1,105 @@
+// Package main provides a sigsum-log-admin binary, for controlling a
+// running primary through its internal admin endpoints.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/log-go/internal/admin"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+type settings struct {
+	url     string
+	keyFile string
+	timeout time.Duration
+	command string
+	args    []string
+}
+
+func parseFlags() settings {
+	s := settings{timeout: 10 * time.Second}
+	help := false
+	getopt.SetParameters("read-only [on|off] | retire [start]")
+	getopt.FlagLong(&s.url, "url", 0, "Primary's internal endpoint, including any url prefix (required).", "url")
+	getopt.FlagLong(&s.keyFile, "key-file", 0, "Admin key file (openssh format), either an unencrypted private key, or a public key (accessed via ssh-agent) (required).", "file")
+	getopt.FlagLong(&s.timeout, "timeout", 0, "Timeout for the request.")
+	getopt.FlagLong(&help, "help", '?', "Display help.")
+	getopt.Parse()
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if getopt.NArgs() < 1 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	s.command, s.args = getopt.Arg(0), getopt.Args()[1:]
+	if len(s.url) == 0 || len(s.keyFile) == 0 {
+		log.Fatal("the --url and --key-file options are required")
+	}
+	return s
+}
+
+func main() {
+	s := parseFlags()
+	signer, err := key.ReadPrivateKeyFile(s.keyFile)
+	if err != nil {
+		log.Fatal("failed to read admin key: %v", err)
+	}
+	client := admin.NewClient(s.url, signer)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	switch s.command {
+	case "read-only":
+		if len(s.args) > 1 {
+			log.Fatal("too many arguments for read-only")
+		}
+		if len(s.args) == 1 {
+			var readOnly bool
+			switch s.args[0] {
+			case "on":
+				readOnly = true
+			case "off":
+				readOnly = false
+			default:
+				log.Fatal("invalid argument %q, must be \"on\" or \"off\"", s.args[0])
+			}
+			if err := client.SetReadOnly(ctx, readOnly); err != nil {
+				log.Fatal("setting read-only mode failed: %v", err)
+			}
+		}
+		readOnly, err := client.GetReadOnly(ctx)
+		if err != nil {
+			log.Fatal("getting read-only mode failed: %v", err)
+		}
+		if readOnly {
+			fmt.Println("read-only: on")
+		} else {
+			fmt.Println("read-only: off")
+		}
+	case "retire":
+		var state string
+		switch {
+		case len(s.args) == 0:
+			state, err = client.GetRetirementState(ctx)
+		case len(s.args) == 1 && s.args[0] == "start":
+			state, err = client.Retire(ctx)
+		default:
+			log.Fatal("invalid arguments for retire, only \"start\" is supported")
+		}
+		if err != nil {
+			log.Fatal("retire request failed: %v", err)
+		}
+		fmt.Printf("retirement: %s\n", state)
+	default:
+		log.Fatal("unknown command %q", s.command)
+	}
+}
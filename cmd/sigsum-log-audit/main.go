@@ -0,0 +1,117 @@
+// Package main provides a sigsum-log-audit binary, for offline
+// verification of a log's backend against its signed tree head.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/log-go/internal/audit"
+	"sigsum.org/log-go/internal/config"
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+type settings struct {
+	logKeyFile        string
+	submitterKeysFile string
+	allowUnverified   bool
+	batchSize         uint64
+}
+
+func parseFlags(c *config.Config) settings {
+	s := settings{batchSize: 1000}
+	help := false
+	getopt.SetParameters("")
+	getopt.FlagLong(&c.Backend, "backend", 0, "Backend to audit, \"trillian\", \"sqlite\" or \"postgres\".")
+	getopt.FlagLong(&c.TrillianRpcServer, "trillian-rpc-server", 0, "Trillian server.", "host:port")
+	getopt.FlagLong(&c.TrillianTreeIDFile, "trillian-tree-id-file", 0, "Trillian tree identifier.", "file")
+	getopt.FlagLong(&c.SqlDatabase, "sql-database", 0, "Database file or connection string.", "string")
+	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is stored.", "file")
+	getopt.FlagLong(&s.logKeyFile, "log-key", 0, "Public key of the log, for verifying the sth file (required).", "file")
+	getopt.FlagLong(&s.submitterKeysFile, "submitter-keys", 0, "File listing hex-encoded submitter public keys, for verifying leaf signatures.", "file")
+	getopt.FlagLong(&s.allowUnverified, "allow-unverified", 0, "Succeed even if some leaves are by submitters not in --submitter-keys.")
+	getopt.FlagLong(&s.batchSize, "batch-size", 0, "Number of leaves to read per request.")
+	getopt.FlagLong(&c.Timeout, "timeout", 0, "Timeout for connecting to the backend.")
+	getopt.FlagLong(&c.LogLevel, "log-level", 0, "Log level (Available options: debug, info, warning, error).", "level")
+	getopt.FlagLong(&help, "help", '?', "Display help.")
+	getopt.Parse()
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if len(s.logKeyFile) == 0 {
+		log.Fatal("the --log-key option is required")
+	}
+	return s
+}
+
+func main() {
+	var conf *config.Config
+
+	confFile, err := config.OpenConfigFile()
+	if err != nil {
+		log.Info("didn't find configuration file, using defaults: %v", err)
+		conf = config.NewConfig()
+	} else {
+		conf, err = config.LoadConfig(confFile)
+		if err != nil {
+			log.Fatal("failed to parse config file: %v", err)
+		}
+	}
+	s := parseFlags(conf)
+	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
+		log.Fatal("setup logging: %v", err)
+	}
+
+	logKey, err := key.ReadPublicKeyFile(s.logKeyFile)
+	if err != nil {
+		log.Fatal("failed to read log key: %v", err)
+	}
+	sth, err := state.LoadSthFile(conf.SthFile, &logKey)
+	if err != nil {
+		log.Fatal("failed to load sth file: %v", err)
+	}
+	var submitterKeys map[crypto.Hash]crypto.PublicKey
+	if len(s.submitterKeysFile) > 0 {
+		f, err := os.Open(s.submitterKeysFile)
+		if err != nil {
+			log.Fatal("failed to open submitter keys file: %v", err)
+		}
+		submitterKeys, err = audit.ReadKeys(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("failed to read submitter keys: %v", err)
+		}
+	}
+
+	tree, err := db.Open(conf, db.AnyTree)
+	if err != nil {
+		log.Fatal("failed to open backend: %v", err)
+	}
+
+	result, err := audit.Audit(context.Background(), tree, &sth.TreeHead, submitterKeys, s.batchSize)
+	if err != nil {
+		var leafErr *audit.LeafError
+		if errors.As(err, &leafErr) {
+			fmt.Printf("FAILED: first mismatching index %d: %s\n", leafErr.Index, leafErr.Reason)
+		} else {
+			fmt.Printf("FAILED: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	if result.UnverifiedLeaves > 0 && !s.allowUnverified {
+		fmt.Printf("INCOMPLETE: size %d, root hash %x, %d leaf signatures verified, but %d leaves by unknown keys NOT verified (use --allow-unverified to accept)\n",
+			result.Size, sth.RootHash, result.VerifiedLeaves, result.UnverifiedLeaves)
+		os.Exit(2)
+	}
+	fmt.Printf("OK: size %d, root hash %x, %d leaf signatures verified, %d leaves by unknown keys\n",
+		result.Size, sth.RootHash, result.VerifiedLeaves, result.UnverifiedLeaves)
+}
@@ -0,0 +1,199 @@
+// Package main provides a sigsum-log-bundle binary, for exporting a
+// log's tree to a bundle directory, and for importing a bundle into
+// the empty backend of a new secondary node.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/log-go/internal/audit"
+	"sigsum.org/log-go/internal/bundle"
+	"sigsum.org/log-go/internal/config"
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type settings struct {
+	mode              string
+	dir               string
+	logKeyFile        string
+	treeHeadFile      string
+	logURL            string
+	noCosignatures    bool
+	policyFile        string
+	submitterKeysFile string
+	batchSize         uint64
+	rangeSize         uint64
+}
+
+func parseFlags(c *config.Config) settings {
+	s := settings{batchSize: 1000, rangeSize: 65536}
+	help := false
+	getopt.SetParameters("export|import DIRECTORY")
+	getopt.FlagLong(&c.Backend, "backend", 0, "Backend to export from or import to, \"trillian\", \"sqlite\" or \"postgres\".")
+	getopt.FlagLong(&c.TrillianRpcServer, "trillian-rpc-server", 0, "Trillian server.", "host:port")
+	getopt.FlagLong(&c.TrillianTreeIDFile, "trillian-tree-id-file", 0, "Trillian tree identifier.", "file")
+	getopt.FlagLong(&c.SqlDatabase, "sql-database", 0, "Database file or connection string.", "string")
+	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is stored; a retired log's final tree head is read from the corresponding .final file.", "file")
+	getopt.FlagLong(&s.treeHeadFile, "tree-head", 0, "File with a cosigned tree head to export, in get-tree-head format.", "file")
+	getopt.FlagLong(&s.logURL, "log-url", 0, "Public url of the running log, to export its published cosigned tree head.", "url")
+	getopt.FlagLong(&s.noCosignatures, "no-cosignatures", 0, "Export the signed tree head in the sth file, without cosignatures.")
+	getopt.FlagLong(&s.policyFile, "policy", 0, "Policy file, for verifying the tree head's cosignatures on import.", "file")
+	getopt.FlagLong(&s.logKeyFile, "log-key", 0, "Public key of the log, for verifying the tree head (required).", "file")
+	getopt.FlagLong(&s.submitterKeysFile, "submitter-keys", 0, "File listing hex-encoded submitter public keys, for verifying leaf signatures on import.", "file")
+	getopt.FlagLong(&s.batchSize, "batch-size", 0, "Number of leaves to read or write per request.")
+	getopt.FlagLong(&s.rangeSize, "range-size", 0, "Number of leaves per exported leaf file.")
+	getopt.FlagLong(&c.Timeout, "timeout", 0, "Timeout for connecting to the backend.")
+	getopt.FlagLong(&c.LogLevel, "log-level", 0, "Log level (Available options: debug, info, warning, error).", "level")
+	getopt.FlagLong(&help, "help", '?', "Display help.")
+	getopt.Parse()
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if getopt.NArgs() != 2 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	s.mode, s.dir = getopt.Arg(0), getopt.Arg(1)
+	if s.mode != "export" && s.mode != "import" {
+		log.Fatal("invalid mode %q, must be \"export\" or \"import\"", s.mode)
+	}
+	if len(s.logKeyFile) == 0 {
+		log.Fatal("the --log-key option is required")
+	}
+	return s
+}
+
+func main() {
+	var conf *config.Config
+
+	confFile, err := config.OpenConfigFile()
+	if err != nil {
+		log.Info("didn't find configuration file, using defaults: %v", err)
+		conf = config.NewConfig()
+	} else {
+		conf, err = config.LoadConfig(confFile)
+		if err != nil {
+			log.Fatal("failed to parse config file: %v", err)
+		}
+	}
+	s := parseFlags(conf)
+	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
+		log.Fatal("setup logging: %v", err)
+	}
+
+	logKey, err := key.ReadPublicKeyFile(s.logKeyFile)
+	if err != nil {
+		log.Fatal("failed to read log key: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if s.mode == "export" {
+		cth, err := readTreeHead(ctx, &s, conf.SthFile, &logKey)
+		if err != nil {
+			log.Fatal("failed to read tree head: %v", err)
+		}
+		tree, err := db.Open(conf, db.AnyTree)
+		if err != nil {
+			log.Fatal("failed to open backend: %v", err)
+		}
+		if err := bundle.Export(ctx, tree, &cth, s.dir, s.rangeSize, s.batchSize); err != nil {
+			log.Fatal("export failed: %v", err)
+		}
+		fmt.Printf("OK: exported size %d, root hash %x, %d cosignatures\n",
+			cth.Size, cth.RootHash, len(cth.Cosignatures))
+		return
+	}
+
+	var submitterKeys map[crypto.Hash]crypto.PublicKey
+	if len(s.submitterKeysFile) > 0 {
+		f, err := os.Open(s.submitterKeysFile)
+		if err != nil {
+			log.Fatal("failed to open submitter keys file: %v", err)
+		}
+		submitterKeys, err = audit.ReadKeys(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("failed to read submitter keys: %v", err)
+		}
+	}
+	// Only set if configured, since a nil *policy.Policy isn't a nil
+	// bundle.Policy.
+	var importPolicy bundle.Policy
+	if len(s.policyFile) > 0 {
+		p, err := policy.ReadPolicyFile(s.policyFile)
+		if err != nil {
+			log.Fatal("failed to read policy: %v", err)
+		}
+		importPolicy = p
+	}
+	// Imported leaves must be added as is, to a PREORDERED_LOG tree.
+	tree, err := db.Open(conf, db.SecondaryTree)
+	if err != nil {
+		log.Fatal("failed to open backend: %v", err)
+	}
+	cth, err := bundle.Import(ctx, tree, s.dir, &logKey, importPolicy, submitterKeys, s.batchSize)
+	if err != nil {
+		log.Fatal("import failed: %v", err)
+	}
+	fmt.Printf("OK: imported size %d, root hash %x\n", cth.Size, cth.RootHash)
+}
+
+// Reads the tree head to export: the cosigned tree head in the
+// --tree-head file, or else the one published by the running log at
+// --log-url, or else the final tree head of a retired log. Only with
+// --no-cosignatures, the signed tree head in the sth file is used.
+func readTreeHead(ctx context.Context, s *settings, sthFile string, logKey *crypto.PublicKey) (types.CosignedTreeHead, error) {
+	var cth types.CosignedTreeHead
+	switch {
+	case len(s.treeHeadFile) > 0:
+		f, err := os.Open(s.treeHeadFile)
+		if err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		defer f.Close()
+		if err := cth.FromASCII(f); err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+	case len(s.logURL) > 0:
+		var err error
+		cth, err = client.New(client.Config{URL: s.logURL}).GetTreeHead(ctx)
+		if err != nil {
+			return types.CosignedTreeHead{}, fmt.Errorf("getting log's tree head failed: %v", err)
+		}
+	case s.noCosignatures:
+		sth, err := state.LoadSthFile(sthFile, logKey)
+		if err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		return types.CosignedTreeHead{SignedTreeHead: sth}, nil
+	default:
+		final, err := state.LoadFinalFile(sthFile, logKey)
+		if err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		if final == nil {
+			return types.CosignedTreeHead{}, fmt.Errorf("log is not retired, use --log-url or --tree-head, or --no-cosignatures to export the sth file")
+		}
+		return *final, nil
+	}
+	if !cth.Verify(logKey) {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid log signature on tree head")
+	}
+	return cth, nil
+}
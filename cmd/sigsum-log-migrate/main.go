@@ -0,0 +1,165 @@
+// Package main provides a sigsum-log-migrate binary, for copying a
+// log's leaves from one backend to another.
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/log-go/internal/config"
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+type destination struct {
+	Backend            string
+	TrillianRpcServer  string
+	TrillianTreeIDFile string
+	SqlDatabase        string
+}
+
+// Returns a backend configuration for the destination.
+func (d *destination) config(timeout time.Duration) *config.Config {
+	return &config.Config{
+		Backend:            d.Backend,
+		TrillianRpcServer:  d.TrillianRpcServer,
+		TrillianTreeIDFile: d.TrillianTreeIDFile,
+		SqlDatabase:        d.SqlDatabase,
+		Timeout:            timeout,
+	}
+}
+
+type settings struct {
+	logKeyFile string
+	batchSize  uint64
+	wait       time.Duration
+	dest       destination
+}
+
+func parseFlags(c *config.Config) settings {
+	s := settings{
+		batchSize: 1000,
+		wait:      10 * time.Minute,
+		dest: destination{
+			TrillianRpcServer: c.TrillianRpcServer,
+		},
+	}
+	help := false
+	getopt.SetParameters("")
+	getopt.FlagLong(&c.Backend, "backend", 0, "Source backend, \"trillian\", \"sqlite\" or \"postgres\".")
+	getopt.FlagLong(&c.TrillianRpcServer, "trillian-rpc-server", 0, "Source Trillian server.", "host:port")
+	getopt.FlagLong(&c.TrillianTreeIDFile, "trillian-tree-id-file", 0, "Source Trillian tree identifier.", "file")
+	getopt.FlagLong(&c.SqlDatabase, "sql-database", 0, "Source database file or connection string.", "string")
+	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is stored.", "file")
+	getopt.FlagLong(&s.logKeyFile, "log-key", 0, "Public key of the log, for verifying the sth file (required).", "file")
+	getopt.FlagLong(&s.dest.Backend, "dest-backend", 0, "Destination backend, \"trillian\", \"sqlite\" or \"postgres\" (required).")
+	getopt.FlagLong(&s.dest.TrillianRpcServer, "dest-trillian-rpc-server", 0, "Destination Trillian server.", "host:port")
+	getopt.FlagLong(&s.dest.TrillianTreeIDFile, "dest-trillian-tree-id-file", 0, "Destination Trillian tree identifier, tree must be of type PREORDERED_LOG.", "file")
+	getopt.FlagLong(&s.dest.SqlDatabase, "dest-sql-database", 0, "Destination database file or connection string.", "string")
+	getopt.FlagLong(&s.batchSize, "batch-size", 0, "Number of leaves to copy per request.")
+	getopt.FlagLong(&s.wait, "wait", 0, "Maximum time to wait for the destination to integrate copied leaves.")
+	getopt.FlagLong(&c.Timeout, "timeout", 0, "Timeout for connecting to backends.")
+	getopt.FlagLong(&c.LogLevel, "log-level", 0, "Log level (Available options: debug, info, warning, error).", "level")
+	getopt.FlagLong(&help, "help", '?', "Display help.")
+	getopt.Parse()
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if len(s.logKeyFile) == 0 {
+		log.Fatal("the --log-key option is required")
+	}
+	if len(s.dest.Backend) == 0 {
+		log.Fatal("the --dest-backend option is required")
+	}
+	if s.batchSize == 0 {
+		log.Fatal("invalid batch size 0")
+	}
+	return s
+}
+
+func main() {
+	var conf *config.Config
+
+	// Source backend is specified by the config file, if any.
+	confFile, err := config.OpenConfigFile()
+	if err != nil {
+		log.Info("didn't find configuration file, using defaults: %v", err)
+		conf = config.NewConfig()
+	} else {
+		conf, err = config.LoadConfig(confFile)
+		if err != nil {
+			log.Fatal("failed to parse config file: %v", err)
+		}
+	}
+	s := parseFlags(conf)
+	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
+		log.Fatal("setup logging: %v", err)
+	}
+
+	logKey, err := key.ReadPublicKeyFile(s.logKeyFile)
+	if err != nil {
+		log.Fatal("failed to read log key: %v", err)
+	}
+	sth, err := state.LoadSthFile(conf.SthFile, &logKey)
+	if err != nil {
+		log.Fatal("failed to load sth file: %v", err)
+	}
+
+	src, err := db.Open(conf, db.AnyTree)
+	if err != nil {
+		log.Fatal("failed to open source backend: %v", err)
+	}
+	dst, err := db.Open(s.dest.config(conf.Timeout), db.SecondaryTree)
+	if err != nil {
+		log.Fatal("failed to open destination backend: %v", err)
+	}
+
+	ctx := context.Background()
+	srcTH, err := src.GetTreeHead(ctx)
+	if err != nil {
+		log.Fatal("failed to get source tree head: %v", err)
+	}
+	if srcTH.Size < sth.Size {
+		log.Fatal("source tree is behind the sth file: %d < %d", srcTH.Size, sth.Size)
+	}
+	if srcTH.Size > sth.Size {
+		log.Info("not copying %d leaves beyond the signed tree head", srcTH.Size-sth.Size)
+	}
+	dstTH, err := dst.GetTreeHead(ctx)
+	if err != nil {
+		log.Fatal("failed to get destination tree head: %v", err)
+	}
+	if dstTH.Size > sth.Size {
+		log.Fatal("destination tree is larger than the sth file: %d > %d", dstTH.Size, sth.Size)
+	}
+	if dstTH.Size > 0 {
+		log.Info("resuming copy at index %d", dstTH.Size)
+	}
+	log.Info("copying leaves [%d:%d]", dstTH.Size, sth.Size)
+	if err := db.CopyLeaves(ctx, dst, src, dstTH.Size, sth.Size, s.batchSize); err != nil {
+		log.Fatal("copying leaves failed: %v", err)
+	}
+
+	// Some backends, e.g., Trillian, integrate added leaves asynchronously.
+	for deadline := time.Now().Add(s.wait); ; time.Sleep(time.Second) {
+		dstTH, err = dst.GetTreeHead(ctx)
+		if err != nil {
+			log.Fatal("failed to get destination tree head: %v", err)
+		}
+		if dstTH.Size >= sth.Size || time.Now().After(deadline) {
+			break
+		}
+		log.Debug("waiting for destination, size %d, expected %d", dstTH.Size, sth.Size)
+	}
+	if dstTH != sth.TreeHead {
+		log.Fatal("destination tree head (size %d, root hash %x) doesn't match sth file (size %d, root hash %x)",
+			dstTH.Size, dstTH.RootHash, sth.Size, sth.RootHash)
+	}
+	log.Info("migration successful, destination tree size %d, root hash %x", dstTH.Size, dstTH.RootHash)
+}
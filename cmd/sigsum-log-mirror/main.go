@@ -0,0 +1,178 @@
+// Package main provides a sigsum-log-mirror binary
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"sigsum.org/log-go/internal/config"
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/metrics"
+	"sigsum.org/log-go/internal/node/secondary"
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/server"
+)
+
+var (
+	gitCommit = "unknown"
+)
+
+func ParseFlags(c *config.Config) {
+	help := false
+	getopt.SetParameters("")
+	getopt.FlagLong(&c.Mirror.LogURL, "log-url", 0, "Public endpoint of the log to mirror.", "url")
+	getopt.FlagLong(&c.Mirror.LogPubkeyFile, "log-pubkey-file", 0, "Public key of the log to mirror.", "file")
+	getopt.FlagLong(&c.Mirror.PolicyFile, "policy-file", 0, "Policy used to verify the log's cosigned tree heads, must list the log.", "file")
+	getopt.FlagLong(&c.Mirror.BatchSize, "batch-size", 0, "Number of leaves to request from the log per request.")
+	getopt.FlagLong(&help, "help", '?', "Display help.")
+	getopt.Parse()
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+}
+
+func main() {
+	var conf *config.Config
+
+	// Read default values from the Config struct
+	confFile, err := config.OpenConfigFile()
+	if err != nil {
+		log.Info("didn't find configuration file, using defaults: %v", err)
+		conf = config.NewConfig()
+	} else {
+		conf, err = config.LoadConfig(confFile)
+		if err != nil {
+			log.Fatal("failed to parse config file: %v", err)
+		}
+	}
+
+	// Allow flags to override them
+	conf.ServerFlags(getopt.CommandLine)
+	ParseFlags(conf)
+
+	if len(conf.LogFile) > 0 {
+		if err := log.SetLogFile(conf.LogFile); err != nil {
+			log.Fatal("open log file failed: %v", err)
+		}
+	}
+	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
+		log.Fatal("setup logging: %v", err)
+	}
+	log.Info("log-go git-commit %s", gitCommit)
+
+	log.Debug("configuring log-go-mirror")
+	mirror, err := setupMirrorFromFlags(conf)
+	if err != nil {
+		log.Fatal("setup mirror: %v", err)
+	}
+
+	// wait for clean-up before exit
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	log.Debug("starting periodic routine")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mirror.Run(ctx)
+		log.Debug("periodic routine shutdown")
+		cancel() // must have periodic running
+	}()
+
+	// Metrics are labeled by the mirrored log's key.
+	serverMetrics := metrics.NewServerMetrics(hex.EncodeToString(mirror.LogPub[:]))
+	log.Debug("adding external handler under prefix: %s", conf.Prefix)
+	extserver := &http.Server{
+		Addr: conf.ExternalEndpoint,
+		Handler: server.NewLog(&server.Config{
+			Prefix:  conf.Prefix,
+			Timeout: conf.Timeout,
+			Metrics: serverMetrics,
+		}, mirror.Replica),
+	}
+	internalMux := http.NewServeMux()
+	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
+	internalMux.Handle("/metrics", promhttp.Handler())
+	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: internalMux}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Info("serving metrics on %v", conf.InternalEndpoint)
+		if err = intserver.ListenAndServe(); err != http.ErrServerClosed {
+			log.Error("serve(intserver): %v", err)
+		}
+		log.Debug("internal endpoints server shut down")
+		cancel()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Info("serving clients on %v/%v", conf.ExternalEndpoint, conf.Prefix)
+		if err = extserver.ListenAndServe(); err != http.ErrServerClosed {
+			log.Error("serve(server): %v", err)
+		}
+		log.Debug("public endpoints server shut down")
+		cancel()
+	}()
+
+	<-ctx.Done()
+
+	log.Debug("received shutdown signal")
+	shutdownCtx, _ := context.WithTimeout(context.Background(), time.Second*60)
+
+	log.Info("stopping http server, please wait...")
+	extserver.Shutdown(shutdownCtx)
+	log.Info("... done")
+	log.Info("stopping internal api server, please wait...")
+	intserver.Shutdown(shutdownCtx)
+	log.Info("... done")
+}
+
+// setupMirrorFromFlags() sets up a new sigsum mirror from flags.
+func setupMirrorFromFlags(conf *config.Config) (*secondary.Mirror, error) {
+	var m secondary.Mirror
+	var err error
+
+	if conf.Mirror.LogURL == "" || conf.Mirror.LogPubkeyFile == "" || conf.Mirror.PolicyFile == "" {
+		return nil, fmt.Errorf("log-url, log-pubkey-file and policy-file must all be configured")
+	}
+	m.LogPub, err = key.ReadPublicKeyFile(conf.Mirror.LogPubkeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log pubkey: %v", err)
+	}
+	m.Policy, err = policy.ReadPolicyFile(conf.Mirror.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %v", err)
+	}
+	m.Log = client.New(client.Config{URL: conf.Mirror.LogURL})
+	m.Interval = conf.Interval
+	m.BatchSize = conf.Mirror.BatchSize
+
+	// Leaves are added as is, to a PREORDERED_LOG tree.
+	m.DbClient, err = db.Open(conf, db.SecondaryTree)
+	if err != nil {
+		return nil, err
+	}
+	m.Replica = secondary.NewReplica(m.DbClient, conf.MaxRange)
+
+	return &m, nil
+}
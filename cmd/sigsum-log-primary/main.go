@@ -8,21 +8,34 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/pborman/getopt/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"sigsum.org/log-go/internal/admin"
+	"sigsum.org/log-go/internal/admission"
+	"sigsum.org/log-go/internal/batch"
+	"sigsum.org/log-go/internal/cache"
 	"sigsum.org/log-go/internal/config"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/freshness"
+	"sigsum.org/log-go/internal/headers"
+	"sigsum.org/log-go/internal/longpoll"
+	"sigsum.org/log-go/internal/lookup"
 	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/log-go/internal/node/primary"
+	"sigsum.org/log-go/internal/nodeauth"
+	"sigsum.org/log-go/internal/notify"
 	rateLimit "sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/receipt"
 	"sigsum.org/log-go/internal/state"
-	"sigsum.org/sigsum-go/pkg/api"
-	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/log-go/internal/tiles"
+	"sigsum.org/log-go/internal/webhook"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/key"
 	"sigsum.org/sigsum-go/pkg/log"
@@ -43,7 +56,24 @@ func ParseFlags(c *config.Config) {
 	getopt.FlagLong(&c.Primary.AllowTestDomain, "allow-test-domain", 0, "Allow submit tokens from test.sigsum.org.")
 	getopt.FlagLong(&c.Primary.SecondaryURL, "secondary-url", 0, "Secondary node endpoint for fetching latest replicated tree head.", "url")
 	getopt.FlagLong(&c.Primary.SecondaryPubkeyFile, "secondary-pubkey-file", 0, "Public key for secondary node.", "file")
+	getopt.FlagLong(&c.Primary.SecondaryRequireFresh, "secondary-require-fresh", 0, "Require the secondary to support replay protected tree heads, with no fallback to get-secondary-tree-head.")
+	getopt.FlagLong(&c.Primary.SecondaryNotifyInterval, "secondary-notify-interval", 0, "Interval for checking for new leaves to notify the secondary node about, 0 to disable notifications.")
 	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is being stored.", "file")
+	getopt.FlagLong(&c.Primary.MaxBatchSize, "max-batch-size", 0, "Maximum number of leaves per add-leaves request, 0 to disable the add-leaves endpoint.")
+	getopt.FlagLong(&c.Primary.LongPollTimeout, "long-poll-timeout", 0, "Maximum wait for long-polling get-tree-head and add-leaf requests, 0 to disable long polling.")
+	getopt.FlagLong(&c.Primary.LongPollMaxWaiters, "long-poll-max-waiters", 0, "Maximum number of concurrently waiting long-polling requests.")
+	getopt.FlagLong(&c.Primary.WebhookQueueFile, "webhook-queue-file", 0, "Enable callbacks on published leaves, with pending callbacks stored in this file.", "file")
+	getopt.FlagLong(&c.Primary.WebhookHooksFile, "webhook-hooks-file", 0, "File defining named local hooks for callbacks.", "file")
+	getopt.FlagLong(&c.Primary.WebhookAllowURLs, "webhook-allow-urls", 0, "Allow callbacks to http(s) urls chosen by submitters.")
+	getopt.FlagLong(&c.Primary.ReceiptDeadline, "receipt-deadline", 0, "Return signed receipts for accepted leaves, promising inclusion within this time, 0 to disable receipts.")
+	getopt.FlagLong(&c.Primary.ReceiptFile, "receipt-file", 0, "File for persisting pending and overdue receipts, required with receipt-deadline.")
+	getopt.FlagLong(&c.Primary.KeyIndexFile, "key-index-file", 0, "Enable the internal get-leaves-by-key endpoint, with the index of submitter keys stored in this file.", "file")
+	getopt.FlagLong(&c.Primary.ReadOnly, "read-only", 0, "Start in read-only mode, rejecting new leaves.")
+	getopt.FlagLong(&c.Primary.AdminPubkeyFile, "admin-pubkey-file", 0, "Public key for signed requests to the internal admin endpoints, which are disabled if unset.", "file")
+	getopt.FlagLong(&c.Primary.MaxBacklog, "max-backlog", 0, "Reject new leaves while more leaves than this are waiting to be sequenced, 0 for no limit.")
+	getopt.FlagLong(&c.Primary.MaxBackendLatency, "max-backend-latency", 0, "Reject new leaves while the smoothed backend latency exceeds this, 0 for no limit.")
+	getopt.FlagLong(&c.Primary.ServeTiles, "serve-tiles", 0, "Serve checkpoint, tiles and leaf bundles in the tlog-tiles layout.")
+	getopt.FlagLong(&c.Primary.ExportDirectory, "export-directory", 0, "Export each published tree head, with tiles and leaf bundles, to this directory.", "directory")
 	getopt.FlagLong(&help, "help", '?', "Display help.")
 	getopt.Parse()
 	if help {
@@ -81,7 +111,7 @@ func main() {
 	}
 	log.Info("log-go git-commit %s", gitCommit)
 
-	witnesses, err := configuredWitnesses(conf.PolicyFile)
+	witnesses, err := configuredWitnesses(conf.Primary.PolicyFile)
 	if err != nil {
 		log.Fatal("Failed witness configuration: %v", err)
 	}
@@ -100,6 +130,46 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	if node.Notifier != nil {
+		log.Debug("starting secondary notifier routine")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Notifier.Run(ctx)
+			log.Debug("secondary notifier shutdown")
+		}()
+	}
+
+	if node.Webhooks != nil {
+		log.Debug("starting webhook delivery routine")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Webhooks.Run(ctx)
+			log.Debug("webhook delivery shutdown")
+		}()
+	}
+
+	if node.Admission != nil {
+		log.Debug("starting admission control routine")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Admission.Run(ctx, node.DbClient)
+			log.Debug("admission control shutdown")
+		}()
+	}
+
+	if node.KeyIndex != nil {
+		log.Debug("starting key index routine")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.KeyIndex.Run(ctx)
+			log.Debug("key index shutdown")
+		}()
+	}
+
 	log.Debug("starting primary state manager routine")
 	wg.Add(1)
 	go func() {
@@ -111,21 +181,105 @@ func main() {
 
 	// Register HTTP endpoints.
 	log.Debug("adding external handler under prefix: %s", conf.Prefix)
-	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: server.NewLog(&server.Config{
+	extMux := http.NewServeMux()
+	var logHandler http.Handler = server.NewLog(&server.Config{
 		Prefix:  conf.Prefix,
 		Timeout: conf.Timeout,
 		Metrics: metrics.NewServerMetrics(hex.EncodeToString(publicKey[:])),
-	}, node)}
+	}, node)
+	logHandler = cache.NewHandler(conf.Prefix, conf.MaxRange, node.GetTreeHead, logHandler)
+	// Passes headers for receipts, callbacks and long polling
+	// to and from node.AddLeaf.
+	logHandler = headers.NewHandler(logHandler)
+	if len(conf.Primary.RateLimitFile) > 0 {
+		logHandler = rateLimit.NewHandler(conf.Prefix, logHandler)
+	}
+	if node.LongPoll != nil {
+		log.Debug("enabling long polling, with timeout %v", conf.Primary.LongPollTimeout)
+		logHandler = longpoll.NewHandler(conf.Prefix, node, node.LongPoll, logHandler)
+	}
+	if node.Admission != nil {
+		log.Debug("enabling admission control, with max backlog %d and max backend latency %v",
+			conf.Primary.MaxBacklog, conf.Primary.MaxBackendLatency)
+		logHandler = node.Admission.NewHandler(conf.Prefix, logHandler)
+	}
+	logHandler = markRetired(node.Finalizer.IsFinal, logHandler)
+	extMux.Handle("/", logHandler)
+	extMux.Handle("/"+path.Join(conf.Prefix, lookup.LeafEndpoint)+"/",
+		lookup.NewLeafHandler(conf.Timeout, node.GetLeafByHash))
+	if conf.Primary.MaxBatchSize > 0 {
+		log.Debug("adding add-leaves handler under prefix: %s", conf.Prefix)
+		var batchHandler http.Handler = batch.NewHandler(conf.Timeout, conf.Primary.MaxBatchSize, node.AddLeaves)
+		if len(conf.Primary.RateLimitFile) > 0 {
+			batchHandler = rateLimit.NewHandler(conf.Prefix, batchHandler)
+		}
+		if node.Admission != nil {
+			batchHandler = node.Admission.NewHandler(conf.Prefix, batchHandler)
+		}
+		extMux.Handle("/"+path.Join(conf.Prefix, batch.Endpoint), batchHandler)
+	}
+	if conf.Primary.ServeTiles {
+		log.Debug("adding tiles handler under prefix: %s", conf.Prefix)
+		tiles.NewHandler(conf.Prefix, conf.Timeout, tiles.New(node.DbClient), &publicKey,
+			node.Stateman.CosignedTreeHead).Register(extMux)
+	}
+	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: extMux}
 	internalMux := http.NewServeMux()
 	log.Debug("adding internal handler under prefix: %s", conf.Prefix)
-	internalMux.Handle("/", server.NewGetLeavesServer(&server.Config{
+	var intHandler http.Handler = server.NewGetLeavesServer(&server.Config{
 		Prefix:  conf.Prefix,
 		Timeout: conf.Timeout,
 		// No metrics. If we used the same logging id, we'd
 		// get a mix of get-leaves metrics for internal and
 		// external endpoint.
 	},
-		node.GetLeavesInternal))
+		node.GetLeavesInternal)
+	// Wraps handlers for the internal endpoint, requiring signed
+	// requests if so configured.
+	authInternal := func(h http.Handler) http.Handler { return h }
+	if conf.AuthInternal {
+		// Already read successfully by setupPrimaryFromFlags.
+		secondaryPub, err := key.ReadPublicKeyFile(conf.Primary.SecondaryPubkeyFile)
+		if err != nil {
+			log.Fatal("failed to read secondary node pubkey: %v", err)
+		}
+		log.Debug("requiring signed requests from secondary on internal endpoint")
+		authInternal = func(h http.Handler) http.Handler {
+			return nodeauth.NewHandler(&secondaryPub, h)
+		}
+	}
+	internalMux.Handle("/", authInternal(intHandler))
+	if len(conf.Primary.AdminPubkeyFile) > 0 {
+		adminPub, err := key.ReadPublicKeyFile(conf.Primary.AdminPubkeyFile)
+		if err != nil {
+			log.Fatal("failed to read admin pubkey: %v", err)
+		}
+		log.Debug("adding admin handlers to internal mux")
+		internalMux.Handle("/"+path.Join(conf.Prefix, admin.ReadOnlyEndpoint),
+			nodeauth.NewReplayProtectedHandler(&adminPub, admin.NewReadOnlyHandler(node.IsReadOnly, node.SetReadOnly)))
+		retire := func() error {
+			started, err := node.BeginRetirement()
+			if err != nil || !started {
+				return err
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := node.FinishRetirement(ctx, conf.Interval); err != nil {
+					log.Error("retirement failed: %v", err)
+				}
+			}()
+			return nil
+		}
+		internalMux.Handle("/"+path.Join(conf.Prefix, admin.RetireEndpoint),
+			nodeauth.NewReplayProtectedHandler(&adminPub, admin.NewRetireHandler(node.RetirementState, retire)))
+	}
+	if node.KeyIndex != nil {
+		internalMux.Handle("/"+path.Join(conf.Prefix, lookup.KeyEndpoint)+"/", authInternal(node.KeyIndex.NewKeyHandler()))
+	}
+	if node.Receipts != nil {
+		internalMux.Handle("/"+path.Join(conf.Prefix, receipt.Endpoint), authInternal(node.Receipts.NewOverdueHandler()))
+	}
 
 	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
 	internalMux.Handle("/metrics", promhttp.Handler())
@@ -176,21 +330,18 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 	}
 	publicKey := signer.Public()
 	p.MaxRange = conf.MaxRange
+	p.ReadOnly = &atomic.Bool{}
+	if conf.Primary.ReadOnly {
+		log.Info("starting in read-only mode, new leaves are rejected")
+		p.ReadOnly.Store(true)
+	}
 
-	switch conf.Backend {
-	default:
-		return nil, crypto.PublicKey{}, fmt.Errorf("unknown backend %q, must be \"trillian\" (default) or \"ephemeral\"", conf.Backend)
-	case "ephemeral":
-		p.DbClient = db.NewMemoryDb()
-	case "trillian":
-		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.PrimaryTree, conf.TrillianTreeIDFile)
-		if err != nil {
-			return nil, crypto.PublicKey{}, err
-		}
-		p.DbClient = trillianClient
+	p.DbClient, err = db.Open(conf, db.PrimaryTree)
+	if err != nil {
+		return nil, crypto.PublicKey{}, err
 	}
 	// Setup secondary node configuration.
-	var secondary api.Secondary
+	var secondary state.SecondaryTree
 	var secondaryPub crypto.PublicKey
 	if conf.Primary.SecondaryURL != "" && conf.Primary.SecondaryPubkeyFile != "" {
 		var err error
@@ -198,15 +349,98 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 		if err != nil {
 			return nil, crypto.PublicKey{}, fmt.Errorf("failed to read secondary node pubkey: %v", err)
 		}
-		secondary = client.New(client.Config{URL: conf.Primary.SecondaryURL})
+		var httpClient *http.Client
+		if conf.AuthInternal {
+			httpClient = nodeauth.NewClient(signer)
+		}
+		secondary = freshness.NewClient(conf.Primary.SecondaryURL, httpClient)
+		if conf.Primary.SecondaryNotifyInterval > 0 {
+			p.Notifier = notify.NewNotifier(p.DbClient, notify.NewClient(conf.Primary.SecondaryURL, httpClient),
+				conf.Primary.SecondaryNotifyInterval)
+		}
+	} else if conf.AuthInternal {
+		return nil, crypto.PublicKey{}, fmt.Errorf("authenticate-internal requires secondary-url and secondary-pubkey-file")
 	}
 
 	// Setup state manager.
-	p.Stateman, err = state.NewStateManagerSingle(p.DbClient, signer, conf.Timeout,
+	stateman, err := state.NewStateManagerSingle(p.DbClient, signer, conf.Timeout,
 		secondary, &secondaryPub, conf.Primary.SthFile)
 	if err != nil {
 		return nil, crypto.PublicKey{}, fmt.Errorf("NewStateManagerSingle: %v", err)
 	}
+	if secondary != nil {
+		stateman.SetReplicationMetrics(metrics.NewReplicationMetrics())
+		if conf.Primary.SecondaryRequireFresh {
+			stateman.RequireFreshSecondary()
+		}
+	}
+	if len(conf.Primary.ExportDirectory) > 0 {
+		log.Debug("exporting published tree heads to directory: %s", conf.Primary.ExportDirectory)
+		stateman.AddPublisher(tiles.NewExporter(conf.Primary.ExportDirectory, tiles.New(p.DbClient), &publicKey))
+	}
+	if conf.Primary.LongPollTimeout > 0 {
+		if conf.Primary.LongPollTimeout > conf.Timeout {
+			log.Warning("long-poll-timeout %v exceeds timeout %v, add-leaf requests wait at most %v",
+				conf.Primary.LongPollTimeout, conf.Timeout, conf.Timeout)
+		}
+		p.LongPoll = longpoll.NewWaiter(conf.Primary.LongPollTimeout, conf.Primary.LongPollMaxWaiters)
+		stateman.AddPublisher(p.LongPoll)
+	}
+	if len(conf.Primary.WebhookQueueFile) > 0 {
+		var hooks map[string]webhook.Hook
+		if len(conf.Primary.WebhookHooksFile) > 0 {
+			f, err := os.Open(conf.Primary.WebhookHooksFile)
+			if err != nil {
+				return nil, crypto.PublicKey{}, fmt.Errorf("opening webhook hooks file failed: %v", err)
+			}
+			defer f.Close()
+			hooks, err = webhook.ReadHooks(f)
+			if err != nil {
+				return nil, crypto.PublicKey{}, fmt.Errorf("reading webhook hooks failed: %v", err)
+			}
+		}
+		p.Webhooks, err = webhook.NewQueue(conf.Primary.WebhookQueueFile, signer, p.DbClient, hooks,
+			conf.Primary.WebhookAllowURLs, conf.Timeout)
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+		stateman.AddPublisher(p.Webhooks)
+	}
+	if conf.Primary.ReceiptDeadline > 0 {
+		if conf.Primary.ReceiptDeadline < 2*conf.Interval {
+			log.Warning("receipt-deadline %v is less than two intervals, receipts may be overdue even when the log works as intended",
+				conf.Primary.ReceiptDeadline)
+		}
+		if len(conf.Primary.ReceiptFile) == 0 {
+			return nil, crypto.PublicKey{}, fmt.Errorf("receipt-deadline requires receipt-file")
+		}
+		p.Receipts, err = receipt.NewTracker(conf.Primary.ReceiptFile, signer, p.DbClient,
+			conf.Primary.ReceiptDeadline, metrics.NewReceiptMetrics())
+		if err != nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("loading receipts failed: %v", err)
+		}
+		stateman.AddPublisher(p.Receipts)
+	}
+	if conf.Primary.MaxBacklog < 0 {
+		return nil, crypto.PublicKey{}, fmt.Errorf("invalid max-backlog %d", conf.Primary.MaxBacklog)
+	}
+	if conf.Primary.MaxBacklog > 0 || conf.Primary.MaxBackendLatency > 0 {
+		p.Admission = admission.NewController(uint64(conf.Primary.MaxBacklog), conf.Primary.MaxBackendLatency,
+			conf.Interval, metrics.NewAdmissionMetrics())
+	}
+	p.Stateman = stateman
+	p.Finalizer = stateman
+	p.Retiring = &atomic.Bool{}
+	if stateman.IsFinal() {
+		log.Info("log is retired, new leaves are rejected")
+		p.ReadOnly.Store(true)
+	}
+	if len(conf.Primary.KeyIndexFile) > 0 {
+		p.KeyIndex, err = lookup.NewKeyIndex(conf.Primary.KeyIndexFile, p.DbClient, conf.Interval)
+		if err != nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("loading key index failed: %v", err)
+		}
+	}
 
 	p.TokenVerifier = token.NewDnsVerifier(&publicKey)
 	if len(conf.Primary.RateLimitFile) > 0 {
@@ -225,6 +459,17 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 	return &p, publicKey, nil
 }
 
+// Marks all responses from the log once it is retired, so that clients
+// know the tree head is final.
+func markRetired(isFinal func() bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isFinal() {
+			w.Header().Set("Sigsum-Log-Retired", "true")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func configuredWitnesses(file string) ([]policy.Entity, error) {
 	if len(file) == 0 {
 		return nil, nil
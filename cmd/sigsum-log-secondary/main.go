@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"sync"
 	"syscall"
 	"time"
@@ -15,10 +16,14 @@ import (
 	"github.com/pborman/getopt/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"sigsum.org/log-go/internal/audit"
 	"sigsum.org/log-go/internal/config"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/freshness"
 	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/log-go/internal/node/secondary"
+	"sigsum.org/log-go/internal/nodeauth"
+	"sigsum.org/log-go/internal/notify"
 	"sigsum.org/sigsum-go/pkg/client"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/key"
@@ -34,6 +39,12 @@ func ParseFlags(c *config.Config) {
 	help := false
 	getopt.SetParameters("")
 	getopt.FlagLong(&c.Secondary.PrimaryURL, "primary-url", 0, "Primary node endpoint for fetching leaves.", "url")
+	getopt.FlagLong(&c.Secondary.PrimaryPublicURL, "primary-public-url", 0, "Primary node public endpoint, for verifying replicated leaves against the published tree head.", "url")
+	getopt.FlagLong(&c.Secondary.PrimaryPubkeyFile, "primary-pubkey-file", 0, "Public key of the log, for verifying the published tree head.", "file")
+	getopt.FlagLong(&c.Secondary.BatchSize, "batch-size", 0, "Number of leaves to request from the primary per request.")
+	getopt.FlagLong(&c.Secondary.FetchConcurrency, "fetch-concurrency", 0, "Maximum number of concurrent requests for leaves.")
+	getopt.FlagLong(&c.Secondary.ReadReplica, "read-replica", 0, "Serve the public read endpoints on the external endpoint, requires primary-public-url.")
+	getopt.FlagLong(&c.Secondary.SubmitterKeysFile, "submitter-keys-file", 0, "File listing hex-encoded submitter public keys, for verifying replicated leaf signatures.", "file")
 	getopt.FlagLong(&help, "help", '?', "Display help.")
 	getopt.Parse()
 	if help {
@@ -93,15 +104,36 @@ func main() {
 		cancel() // must have periodic running
 	}()
 
-	// No external endpoints but we want to return 404.
-	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: http.NewServeMux()}
+	serverMetrics := metrics.NewServerMetrics(hex.EncodeToString(publicKey[:]))
+	// Unless serving as a read replica, no external endpoints but we want to return 404.
+	var extHandler http.Handler = http.NewServeMux()
+	if node.Replica != nil {
+		log.Debug("adding read replica handler under prefix: %s", conf.Prefix)
+		extHandler = server.NewLog(&server.Config{
+			Prefix:  conf.Prefix,
+			Timeout: conf.Timeout,
+			Metrics: serverMetrics,
+		}, node.Replica)
+	}
+	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: extHandler}
 	// Register HTTP endpoints.
 	internalMux := http.NewServeMux()
-	internalMux.Handle("/", server.NewSecondary(&server.Config{
+	var intHandler http.Handler = server.NewSecondary(&server.Config{
 		Prefix:  conf.Prefix,
 		Timeout: conf.Timeout,
-		Metrics: metrics.NewServerMetrics(hex.EncodeToString(publicKey[:])),
-	}, node))
+		Metrics: serverMetrics,
+	}, node)
+	var notifyHandler http.Handler = notify.NewHandler(node.NotifyLeaves)
+	var freshHandler http.Handler = freshness.NewHandler(conf.Timeout, node.GetFreshSecondaryTreeHead)
+	if conf.AuthInternal {
+		log.Debug("requiring signed requests from primary on internal endpoint")
+		intHandler = nodeauth.NewHandler(&node.PrimaryPub, intHandler)
+		notifyHandler = nodeauth.NewHandler(&node.PrimaryPub, notifyHandler)
+		freshHandler = nodeauth.NewHandler(&node.PrimaryPub, freshHandler)
+	}
+	internalMux.Handle("/", intHandler)
+	internalMux.Handle("/"+path.Join(conf.Prefix, notify.Endpoint), notifyHandler)
+	internalMux.Handle("/"+path.Join(conf.Prefix, freshness.Endpoint)+"/", freshHandler)
 	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
 	internalMux.Handle("/metrics", promhttp.Handler())
 	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: internalMux}
@@ -153,21 +185,53 @@ func setupSecondaryFromFlags(conf *config.Config) (*secondary.Secondary, crypto.
 	}
 
 	s.Interval = conf.Interval
+	s.Trigger = make(chan struct{}, 1)
+	s.BatchSize = conf.Secondary.BatchSize
+	s.FetchConcurrency = conf.Secondary.FetchConcurrency
 
-	switch conf.Backend {
-	default:
-		return nil, crypto.PublicKey{}, fmt.Errorf("unknown backend %q, must be \"trillian\" (default) or \"ephemeral\"", conf.Backend)
-	case "ephemeral":
-		s.DbClient = db.NewMemoryDb()
-	case "trillian":
-		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.SecondaryTree, conf.TrillianTreeIDFile)
+	s.DbClient, err = db.Open(conf, db.SecondaryTree)
+	if err != nil {
+		return nil, crypto.PublicKey{}, err
+	}
+	// Setup primary node configuration.
+	if conf.Secondary.PrimaryPubkeyFile != "" {
+		s.PrimaryPub, err = key.ReadPublicKeyFile(conf.Secondary.PrimaryPubkeyFile)
 		if err != nil {
-			return nil, crypto.PublicKey{}, err
+			return nil, crypto.PublicKey{}, fmt.Errorf("failed to read primary node pubkey: %v", err)
+		}
+	}
+	var httpClient *http.Client
+	if conf.AuthInternal {
+		if conf.Secondary.PrimaryPubkeyFile == "" {
+			return nil, crypto.PublicKey{}, fmt.Errorf("authenticate-internal requires primary-pubkey-file")
+		}
+		httpClient = nodeauth.NewClient(s.Signer)
+	}
+	s.Primary = client.New(client.Config{URL: conf.Secondary.PrimaryURL, HTTPClient: httpClient})
+	s.Metrics = metrics.NewReplicationMetrics()
+	if conf.Secondary.PrimaryPublicURL != "" && conf.Secondary.PrimaryPubkeyFile != "" {
+		s.PrimaryPublic = client.New(client.Config{URL: conf.Secondary.PrimaryPublicURL})
+		s.Alarm = &secondary.Alarm{}
+	} else {
+		log.Warning("primary-public-url or primary-pubkey-file not configured, replicated leaves are not verified against the primary's tree head")
+	}
+	if conf.Secondary.ReadReplica {
+		if s.PrimaryPublic == nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("read-replica requires primary-public-url and primary-pubkey-file")
+		}
+		s.Replica = secondary.NewReplica(s.DbClient, conf.MaxRange)
+	}
+	if len(conf.Secondary.SubmitterKeysFile) > 0 {
+		f, err := os.Open(conf.Secondary.SubmitterKeysFile)
+		if err != nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("opening submitter keys file failed: %v", err)
+		}
+		defer f.Close()
+		s.SubmitterKeys, err = audit.ReadKeys(f)
+		if err != nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("reading submitter keys failed: %v", err)
 		}
-		s.DbClient = trillianClient
 	}
-	// Setup primary node configuration.
-	s.Primary = client.New(client.Config{URL: conf.Secondary.PrimaryURL})
 
 	return &s, s.Signer.Public(), nil
 }
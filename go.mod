@@ -8,6 +8,8 @@ require (
 	github.com/BurntSushi/toml v1.2.1
 	github.com/golang/mock v1.6.0
 	github.com/google/trillian v1.5.1
+	github.com/lib/pq v1.10.7
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/prometheus/client_golang v1.14.0
 	google.golang.org/grpc v1.51.0
@@ -56,7 +58,6 @@ require (
 	github.com/jackc/pgtype v1.11.0 // indirect
 	github.com/jackc/pgx/v4 v4.16.1 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	github.com/lib/pq v1.10.7 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/prometheus/client_model v0.3.0 // indirect
 	github.com/prometheus/common v0.37.0 // indirect
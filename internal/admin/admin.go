@@ -0,0 +1,199 @@
+// Package admin implements the primary's internal admin endpoints,
+// used by the operator to control a running primary. Requests must be
+// signed by the operator's admin key, as described in package
+// nodeauth.
+//
+// The read-only endpoint reports, on GET, and changes, on POST,
+// whether the primary is in read-only mode, in which new leaves are
+// rejected. Both the POST request body and the response body are
+// "read_only=<0 or 1>\n", in sigsum's ascii format.
+//
+// The retire endpoint reports, on GET, the log's retirement state,
+// and, on POST with an empty body, starts retiring the log. The
+// response body is "state=<active, retiring or retired>\n".
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sigsum.org/log-go/internal/nodeauth"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Endpoint names, relative to the primary's internal url prefix.
+const (
+	ReadOnlyEndpoint = "read-only"
+	RetireEndpoint   = "retire"
+)
+
+// Client makes signed requests to a primary's admin endpoints.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the primary with the given internal
+// base url, signing requests with the admin key.
+func NewClient(url string, signer crypto.Signer) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(url, "/") + "/",
+		httpClient: nodeauth.NewClient(signer),
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Sigsum log-go admin")
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, api.NewError(rsp.StatusCode,
+			fmt.Errorf("%s failed: %s: %s", endpoint, rsp.Status, strings.TrimSpace(string(data))))
+	}
+	return data, nil
+}
+
+// GetReadOnly returns the primary's current mode.
+func (c *Client) GetReadOnly(ctx context.Context) (bool, error) {
+	data, err := c.do(ctx, http.MethodGet, ReadOnlyEndpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	return parseReadOnly(bytes.NewReader(data))
+}
+
+// SetReadOnly switches the primary's mode.
+func (c *Client) SetReadOnly(ctx context.Context, readOnly bool) error {
+	var body bytes.Buffer
+	if err := writeReadOnly(&body, readOnly); err != nil {
+		return err
+	}
+	_, err := c.do(ctx, http.MethodPost, ReadOnlyEndpoint, body.Bytes())
+	return err
+}
+
+// GetRetirementState returns the log's retirement state.
+func (c *Client) GetRetirementState(ctx context.Context) (string, error) {
+	data, err := c.do(ctx, http.MethodGet, RetireEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	return parseState(bytes.NewReader(data))
+}
+
+// Retire starts retiring the log, and returns the resulting state.
+// Retirement completes asynchronously.
+func (c *Client) Retire(ctx context.Context) (string, error) {
+	data, err := c.do(ctx, http.MethodPost, RetireEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	return parseState(bytes.NewReader(data))
+}
+
+func parseState(r io.Reader) (string, error) {
+	p := ascii.NewParser(r)
+	v, err := p.GetValues("state", 1)
+	if err != nil {
+		return "", err
+	}
+	return v[0], p.GetEOF()
+}
+
+func writeReadOnly(w io.Writer, readOnly bool) error {
+	var v uint64
+	if readOnly {
+		v = 1
+	}
+	return ascii.WriteInt(w, "read_only", v)
+}
+
+func parseReadOnly(r io.Reader) (bool, error) {
+	p := ascii.NewParser(r)
+	v, err := p.GetInt("read_only")
+	if err != nil {
+		return false, err
+	}
+	if v > 1 {
+		return false, fmt.Errorf("invalid read_only value %d", v)
+	}
+	return v == 1, p.GetEOF()
+}
+
+// NewReadOnlyHandler returns a handler for the read-only endpoint,
+// using get and set to access the primary's mode.
+func NewReadOnlyHandler(get func() bool, set func(bool) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			readOnly, err := parseReadOnly(http.MaxBytesReader(w, r.Body, 100))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := set(readOnly); err != nil {
+				http.Error(w, err.Error(), api.ErrorStatusCode(err))
+				return
+			}
+			log.Info("read-only mode set to %v by admin request", readOnly)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var buf bytes.Buffer
+		if err := writeReadOnly(&buf, get()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// NewRetireHandler returns a handler for the retire endpoint, using
+// state to get the log's retirement state, and retire to start
+// retirement.
+func NewRetireHandler(state func() string, retire func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := retire(); err != nil {
+				http.Error(w, err.Error(), api.ErrorStatusCode(err))
+				return
+			}
+			log.Info("retirement requested by admin request")
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var buf bytes.Buffer
+		if err := ascii.WriteLine(&buf, "state", state()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
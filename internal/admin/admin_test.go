@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sigsum.org/log-go/internal/nodeauth"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly := false
+	locked := false
+	mux := http.NewServeMux()
+	mux.Handle("/prefix/"+ReadOnlyEndpoint, nodeauth.NewHandler(&pub, NewReadOnlyHandler(
+		func() bool { return readOnly },
+		func(v bool) error {
+			if locked {
+				return api.NewError(http.StatusConflict, fmt.Errorf("locked"))
+			}
+			readOnly = v
+			return nil
+		})))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL+"/prefix", signer)
+	if err := client.SetReadOnly(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if !readOnly {
+		t.Errorf("read-only mode not set")
+	}
+	if got, err := client.GetReadOnly(ctx); err != nil || !got {
+		t.Errorf("unexpected mode %v, err %v", got, err)
+	}
+	if err := client.SetReadOnly(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := client.GetReadOnly(ctx); err != nil || got {
+		t.Errorf("unexpected mode %v, err %v", got, err)
+	}
+
+	locked = true
+	if err := client.SetReadOnly(ctx, true); api.ErrorStatusCode(err) != http.StatusConflict {
+		t.Errorf("unexpected error for refused change: %v", err)
+	}
+	if err := NewClient(server.URL+"/prefix", otherSigner).SetReadOnly(ctx, true); api.ErrorStatusCode(err) != http.StatusUnauthorized {
+		t.Errorf("unexpected error for request with wrong key: %v", err)
+	}
+	if readOnly {
+		t.Errorf("read-only mode changed by refused request")
+	}
+}
+
+func TestRetire(t *testing.T) {
+	ctx := context.Background()
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := "active"
+	mux := http.NewServeMux()
+	mux.Handle("/"+RetireEndpoint, nodeauth.NewHandler(&pub, NewRetireHandler(
+		func() string { return state },
+		func() error {
+			state = "retiring"
+			return nil
+		})))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL, signer)
+	if got, err := client.GetRetirementState(ctx); err != nil || got != "active" {
+		t.Errorf("unexpected state %q, err %v", got, err)
+	}
+	if got, err := client.Retire(ctx); err != nil || got != "retiring" {
+		t.Errorf("unexpected state %q, err %v", got, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package admission implements admission control for the primary,
+// shedding new submissions when the backend falls behind.
+//
+// The primary reports each leaf it queues in the backend, and the
+// latency of its backend calls. A background routine polls the
+// backend's tree size; leaves are sequenced in order, so the backlog
+// of queued but not yet sequenced leaves shrinks by the growth of the
+// tree. While the backlog, or the smoothed backend latency, is above
+// its configured limit, add-leaf and add-leaves requests are answered
+// with status 503 and a Retry-After header, without involving the
+// log.
+package admission
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/metrics"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Interval for polling the backend's tree size.
+	PollInterval = time.Second
+
+	// Weight of each new latency observation in the smoothed
+	// backend latency.
+	latencyWeight = 0.2
+)
+
+// Tree is the subset of the db.Client interface used for tracking
+// sequencing.
+type Tree interface {
+	GetTreeHead(context.Context) (types.TreeHead, error)
+}
+
+// Controller tracks the backend's sequencing backlog and latency, and
+// decides if new submissions are admitted.
+type Controller struct {
+	maxBacklog uint64        // 0 for no limit
+	maxLatency time.Duration // 0 for no limit
+	retryAfter time.Duration
+	metrics    metrics.AdmissionMetrics
+
+	mu      sync.Mutex
+	polled  bool   // Set when the tree size is known
+	size    uint64 // Latest known tree size
+	backlog uint64
+	latency time.Duration
+}
+
+// NewController creates a controller rejecting new submissions while
+// more than maxBacklog leaves are waiting to be sequenced, or the
+// smoothed backend latency exceeds maxLatency, with a zero limit
+// meaning no limit. Rejected clients are asked to retry after
+// retryAfter, typically the log's publication interval.
+func NewController(maxBacklog uint64, maxLatency, retryAfter time.Duration, m metrics.AdmissionMetrics) *Controller {
+	return &Controller{
+		maxBacklog: maxBacklog,
+		maxLatency: maxLatency,
+		retryAfter: retryAfter,
+		metrics:    m,
+	}
+}
+
+// OnQueued records leaves newly queued in the backend.
+func (c *Controller) OnQueued(count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backlog += uint64(count)
+	c.metrics.SetBacklog(c.backlog)
+}
+
+// OnBackendCall records the latency of a backend call.
+func (c *Controller) OnBackendCall(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latency == 0 {
+		c.latency = latency
+	} else {
+		c.latency += time.Duration(latencyWeight * float64(latency-c.latency))
+	}
+	c.metrics.SetBackendLatency(c.latency)
+}
+
+// Update records the backend's current tree size.
+func (c *Controller) Update(size uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.polled {
+		if size <= c.size {
+			return
+		}
+		// Leaves queued before a restart are not in the
+		// backlog, hence the clamping.
+		if sequenced := size - c.size; sequenced < c.backlog {
+			c.backlog -= sequenced
+		} else {
+			c.backlog = 0
+		}
+	}
+	c.polled = true
+	c.size = size
+	c.metrics.SetBacklog(c.backlog)
+}
+
+// Backlog returns the number of leaves queued, but not yet sequenced.
+func (c *Controller) Backlog() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.backlog
+}
+
+// Check returns an error if new submissions are currently rejected.
+func (c *Controller) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxBacklog > 0 && c.backlog > c.maxBacklog {
+		c.metrics.OnRejected("backlog")
+		return fmt.Errorf("sequencing backlog of %d leaves exceeds limit %d", c.backlog, c.maxBacklog)
+	}
+	if c.maxLatency > 0 && c.latency > c.maxLatency {
+		c.metrics.OnRejected("latency")
+		return fmt.Errorf("backend latency %v exceeds limit %v", c.latency, c.maxLatency)
+	}
+	return nil
+}
+
+// Run polls the backend's tree size until the context is cancelled.
+func (c *Controller) Run(ctx context.Context, tree Tree) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		th, err := tree.GetTreeHead(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warning("admission control: getting backend tree head failed: %v", err)
+		} else {
+			c.OnBackendCall(time.Since(start))
+			c.Update(th.Size)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// NewHandler wraps the handler for add-leaf or add-leaves requests,
+// rejecting requests while the backend is overloaded.
+func (c *Controller) NewHandler(prefix string, next http.Handler) http.Handler {
+	addLeafPath := "/" + path.Join(prefix, "add-leaf")
+	addLeavesPath := "/" + path.Join(prefix, "add-leaves")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != addLeafPath && r.URL.Path != addLeavesPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := c.Check(); err != nil {
+			log.Debug("rejecting submission: %v", err)
+			w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(c.retryAfter)))
+			http.Error(w, fmt.Sprintf("log overloaded: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rounds up to whole seconds, at least one.
+func retrySeconds(d time.Duration) int {
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		return 1
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package admission
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/metrics"
+)
+
+func TestBacklog(t *testing.T) {
+	c := NewController(10, 0, time.Minute, metrics.NoAdmissionMetrics{})
+	for _, table := range []struct {
+		desc    string
+		queued  int
+		size    uint64 // Polled size, or 0 for no poll
+		backlog uint64
+	}{
+		{"queued before first poll", 5, 0, 5},
+		{"first poll", 0, 100, 5},
+		{"some sequenced", 3, 102, 6},
+		{"stale size", 0, 101, 6},
+		{"all sequenced", 0, 110, 0},
+		{"queued", 7, 0, 7},
+	} {
+		c.OnQueued(table.queued)
+		if table.size > 0 {
+			c.Update(table.size)
+		}
+		if got := c.Backlog(); got != table.backlog {
+			t.Errorf("%s: got backlog %d, want %d", table.desc, got, table.backlog)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	c := NewController(0, time.Second, time.Minute, metrics.NoAdmissionMetrics{})
+	c.OnBackendCall(500 * time.Millisecond)
+	if err := c.Check(); err != nil {
+		t.Fatalf("unexpected rejection: %v", err)
+	}
+	// A single slow call is smoothed out.
+	c.OnBackendCall(2 * time.Second)
+	if err := c.Check(); err != nil {
+		t.Fatalf("unexpected rejection: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		c.OnBackendCall(2 * time.Second)
+	}
+	if err := c.Check(); err == nil {
+		t.Fatalf("expected rejection on high latency")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := NewController(2, 0, 1500*time.Millisecond, metrics.NoAdmissionMetrics{})
+	c.Update(10)
+	h := c.NewHandler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	for _, table := range []struct {
+		desc   string
+		queued int
+		path   string
+		status int
+	}{
+		{"below limit", 2, "/test/add-leaf", http.StatusAccepted},
+		{"add-leaf above limit", 1, "/test/add-leaf", http.StatusServiceUnavailable},
+		{"add-leaves above limit", 0, "/test/add-leaves", http.StatusServiceUnavailable},
+		{"other endpoint", 0, "/test/get-tree-head", http.StatusAccepted},
+	} {
+		c.OnQueued(table.queued)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, table.path, nil))
+		if got, want := w.Code, table.status; got != want {
+			t.Errorf("%s: got status %d, want %d", table.desc, got, want)
+		}
+		retryAfter := w.Header().Get("Retry-After")
+		if w.Code == http.StatusServiceUnavailable && retryAfter != "2" {
+			t.Errorf("%s: got Retry-After %q, want \"2\"", table.desc, retryAfter)
+		}
+		if w.Code != http.StatusServiceUnavailable && retryAfter != "" {
+			t.Errorf("%s: unexpected Retry-After %q", table.desc, retryAfter)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package audit implements an offline check of a log's backend
+// against its signed tree head.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Subset of the db/client interface.
+type Tree interface {
+	GetLeaves(context.Context, *requests.Leaves) ([]types.Leaf, error)
+	GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error)
+}
+
+// Result summarizes a successful audit.
+type Result struct {
+	Size uint64
+	// Number of leaves with a verified signature.
+	VerifiedLeaves uint64
+	// Number of leaves signed by keys not known to the auditor.
+	UnverifiedLeaves uint64
+}
+
+// LeafError identifies the first leaf found to be bad.
+type LeafError struct {
+	Index  uint64
+	Reason string
+}
+
+func (e *LeafError) Error() string {
+	return fmt.Sprintf("leaf %d: %s", e.Index, e.Reason)
+}
+
+// ReadKeys reads a list of submitter public keys, one hex-encoded
+// key per line, with # used for comments. The returned map is
+// indexed by key hash.
+func ReadKeys(r io.Reader) (map[crypto.Hash]crypto.PublicKey, error) {
+	keys := make(map[crypto.Hash]crypto.PublicKey)
+	for scanner := bufio.NewScanner(r); scanner.Scan(); {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		pub, err := crypto.PublicKeyFromHex(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %v", line, err)
+		}
+		keys[crypto.HashBytes(pub[:])] = pub
+	}
+	return keys, nil
+}
+
+// Audit reads all leaves covered by the tree head, verifies the
+// signature of each leaf signed by one of the given keys, and
+// recomputes the root hash. If the root hash doesn't match the tree
+// head, the first leaf inconsistent with the tree head is identified
+// using consistency proofs. These proofs are verified against the
+// tree head, so the leaves before the reported one are known to be
+// good, but a bad proof from the tree makes the reported leaf too
+// early.
+func Audit(ctx context.Context, tree Tree, th *types.TreeHead,
+	keys map[crypto.Hash]crypto.PublicKey, batchSize uint64) (Result, error) {
+	if batchSize == 0 {
+		return Result{}, fmt.Errorf("invalid batch size 0")
+	}
+	result := Result{Size: th.Size}
+	leafHashes := make([]crypto.Hash, 0, th.Size)
+	for index := uint64(0); index < th.Size; {
+		req := requests.Leaves{
+			StartIndex: index,
+			EndIndex:   index + batchSize,
+		}
+		if req.EndIndex > th.Size {
+			req.EndIndex = th.Size
+		}
+		leaves, err := tree.GetLeaves(ctx, &req)
+		if err != nil {
+			return Result{}, fmt.Errorf("reading leaves [%d:%d] failed: %v", req.StartIndex, req.EndIndex, err)
+		}
+		if len(leaves) == 0 || uint64(len(leaves)) > req.EndIndex-req.StartIndex {
+			return Result{}, fmt.Errorf("reading leaves [%d:%d] returned unexpected leaf count %d",
+				req.StartIndex, req.EndIndex, len(leaves))
+		}
+		for i, leaf := range leaves {
+			if pub, ok := keys[leaf.KeyHash]; ok {
+				if !leaf.Verify(&pub) {
+					return Result{}, &LeafError{Index: index + uint64(i), Reason: "invalid leaf signature"}
+				}
+				result.VerifiedLeaves++
+			} else {
+				result.UnverifiedLeaves++
+			}
+			leafHashes = append(leafHashes, merkle.HashLeafNode(leaf.ToBinary()))
+		}
+		index += uint64(len(leaves))
+		log.Debug("audited leaves up to index %d", index)
+	}
+	if rootHash(leafHashes) == th.RootHash {
+		return result, nil
+	}
+	index, err := firstInconsistentLeaf(ctx, tree, th, leafHashes)
+	if err != nil {
+		return Result{}, fmt.Errorf("root hash mismatch, failed to identify bad leaf: %v", err)
+	}
+	return Result{}, &LeafError{Index: index, Reason: "inconsistent with signed tree head"}
+}
+
+// Binary search for the largest prefix that is provably consistent
+// with the tree head. The leaf following that prefix is the first
+// bad one.
+func firstInconsistentLeaf(ctx context.Context, tree Tree, th *types.TreeHead, leafHashes []crypto.Hash) (uint64, error) {
+	// The empty prefix is trivially consistent, and the full tree is known to not be.
+	lo, hi := uint64(0), th.Size
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		proof, err := tree.GetConsistencyProof(ctx, &requests.ConsistencyProof{
+			OldSize: mid,
+			NewSize: th.Size,
+		})
+		if err != nil {
+			return 0, err
+		}
+		prefix := types.TreeHead{Size: mid, RootHash: rootHash(leafHashes[:mid])}
+		if proof.Verify(&prefix, th) == nil {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// Computes the root hash of the tree with the given leaf hashes, as
+// defined in RFC 6962.
+func rootHash(leafHashes []crypto.Hash) crypto.Hash {
+	switch n := uint64(len(leafHashes)); n {
+	case 0:
+		return merkle.HashEmptyTree()
+	case 1:
+		return leafHashes[0]
+	default:
+		k := uint64(1) << (bits.Len64(n-1) - 1)
+		left, right := rootHash(leafHashes[:k]), rootHash(leafHashes[k:])
+		return merkle.HashInteriorNode(&left, &right)
+	}
+}
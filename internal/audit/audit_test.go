@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Wraps a tree, replacing the leaf at one index.
+type tamperedTree struct {
+	db.Client
+	index uint64
+	leaf  types.Leaf
+}
+
+func (t *tamperedTree) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	leaves, err := t.Client.GetLeaves(ctx, req)
+	if err == nil && t.index >= req.StartIndex && t.index < req.StartIndex+uint64(len(leaves)) {
+		leaves[t.index-req.StartIndex] = t.leaf
+	}
+	return leaves, err
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[crypto.Hash]crypto.PublicKey{crypto.HashBytes(pub[:]): pub}
+
+	tree := db.NewMemoryDb()
+	leaves := make([]types.Leaf, 13)
+	for i := range leaves {
+		leaves[i] = mustLeaf(t, signer, crypto.Hash{byte(i)})
+	}
+	if err := tree.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := tree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, batchSize := range []uint64{1, 5, 100} {
+		result, err := Audit(ctx, tree, &th, keys, batchSize)
+		if err != nil {
+			t.Fatalf("audit with batch size %d failed: %v", batchSize, err)
+		}
+		if result.Size != 13 || result.VerifiedLeaves != 13 || result.UnverifiedLeaves != 0 {
+			t.Errorf("unexpected result: %#v", result)
+		}
+	}
+	if result, err := Audit(ctx, tree, &th, nil, 5); err != nil {
+		t.Errorf("audit without keys failed: %v", err)
+	} else if result.VerifiedLeaves != 0 || result.UnverifiedLeaves != 13 {
+		t.Errorf("unexpected result without keys: %#v", result)
+	}
+
+	for _, table := range []struct {
+		desc  string
+		index uint64
+		leaf  types.Leaf
+		keys  map[crypto.Hash]crypto.PublicKey
+	}{
+		{"bad signature", 7, func() types.Leaf { l := leaves[7]; l.Signature[0] ^= 1; return l }(), keys},
+		{"other leaf", 0, mustLeaf(t, signer, crypto.Hash{100}), nil},
+		{"other leaf", 4, mustLeaf(t, signer, crypto.Hash{100}), nil},
+		{"other leaf", 12, mustLeaf(t, signer, crypto.Hash{100}), nil},
+	} {
+		_, err := Audit(ctx, &tamperedTree{Client: tree, index: table.index, leaf: table.leaf},
+			&th, table.keys, 5)
+		var leafErr *LeafError
+		if !errors.As(err, &leafErr) {
+			t.Errorf("%s: expected leaf error for index %d, got: %v", table.desc, table.index, err)
+		} else if leafErr.Index != table.index {
+			t.Errorf("%s: expected leaf error for index %d, got: %v", table.desc, table.index, leafErr)
+		}
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	keys, err := ReadKeys(strings.NewReader(`
+# Comment
+4cb5abf6ad79fbf5abbccafcc269d85cd2651ed4b885b5869f241aedf0a5ba29  # Test key
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("unexpected number of keys: %d", len(keys))
+	}
+	if _, err := ReadKeys(strings.NewReader("4cb5abf6")); err == nil {
+		t.Errorf("invalid key accepted")
+	}
+}
+
+func mustLeaf(t *testing.T, signer crypto.Signer, msg crypto.Hash) types.Leaf {
+	t.Helper()
+	sig, err := types.SignLeafMessage(signer, msg[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := requests.Leaf{Message: msg, Signature: sig, PublicKey: signer.Public()}
+	leaf, err := req.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
@@ -0,0 +1,248 @@
+// Package batch implements the primary's add-leaves endpoint, for
+// submitting many leaves in a single request.
+//
+// The request body is a sequence of leaves, each in the same ascii
+// format as an add-leaf request, i.e., the three lines message,
+// signature and public_key. An optional Sigsum-Token header applies
+// to all leaves of the request. Leaves are rate limited individually,
+// so a request can be partially accepted. The response body has one
+// line per leaf, in request order, either "status=accepted" (leaf is
+// queued, but not yet in the published tree), "status=sequenced"
+// (leaf is in the published tree), or "status=rejected <reason>".
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+// Endpoint name, relative to the log's url prefix.
+const Endpoint = "add-leaves"
+
+const (
+	submitTokenHeader = "Sigsum-Token"
+	linesPerLeaf      = 3
+	// Upper bound on the size of the ascii representation of a leaf.
+	maxLeafSize = 400
+)
+
+type Status string
+
+const (
+	Accepted  Status = "accepted"
+	Sequenced Status = "sequenced"
+	Rejected  Status = "rejected"
+)
+
+// Reasons for rejecting a leaf.
+const (
+	ReasonInvalidSignature = "invalid-signature"
+	ReasonRateLimited      = "rate-limited"
+)
+
+// Result is the outcome for a single leaf.
+type Result struct {
+	Status Status
+	Reason string // Set only for rejected leaves, with no spaces
+}
+
+// AddLeavesFunc adds a batch of leaves, returning one result per
+// leaf. An error means that the request as a whole failed.
+type AddLeavesFunc func(context.Context, []requests.Leaf, *token.SubmitHeader) ([]Result, error)
+
+func WriteLeaves(w io.Writer, leaves []requests.Leaf) error {
+	for i := range leaves {
+		if err := leaves[i].ToASCII(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseLeaves parses a request body, with at most maxLeaves leaves.
+func ParseLeaves(r io.Reader, maxLeaves int) ([]requests.Leaf, error) {
+	scanner := bufio.NewScanner(r)
+	var leaves []requests.Leaf
+	for {
+		var buf bytes.Buffer
+		lines := 0
+		for ; lines < linesPerLeaf && scanner.Scan(); lines++ {
+			buf.Write(scanner.Bytes())
+			buf.WriteByte('\n')
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		if lines == 0 {
+			break
+		}
+		if len(leaves) >= maxLeaves {
+			return nil, fmt.Errorf("too many leaves, at most %d allowed", maxLeaves)
+		}
+		var leaf requests.Leaf
+		if err := leaf.FromASCII(&buf); err != nil {
+			return nil, fmt.Errorf("invalid leaf %d: %v", len(leaves), err)
+		}
+		leaves = append(leaves, leaf)
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("no leaves in request")
+	}
+	return leaves, nil
+}
+
+func WriteResults(w io.Writer, results []Result) error {
+	for _, r := range results {
+		var err error
+		if r.Status == Rejected {
+			err = ascii.WriteLine(w, "status", string(r.Status), r.Reason)
+		} else {
+			err = ascii.WriteLine(w, "status", string(r.Status))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ParseResults(r io.Reader) ([]Result, error) {
+	scanner := bufio.NewScanner(r)
+	var results []Result
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "status=") {
+			return nil, fmt.Errorf("invalid result line %q", line)
+		}
+		status, reason, _ := strings.Cut(strings.TrimPrefix(line, "status="), " ")
+		switch Status(status) {
+		case Accepted, Sequenced:
+			if len(reason) > 0 {
+				return nil, fmt.Errorf("invalid result line %q", line)
+			}
+		case Rejected:
+			if len(reason) == 0 {
+				return nil, fmt.Errorf("missing reason for rejected leaf")
+			}
+		default:
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+		results = append(results, Result{Status: Status(status), Reason: reason})
+	}
+	return results, scanner.Err()
+}
+
+// Client submits batches of leaves to a log.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the log with the given base url,
+// i.e., the same url as used for add-leaf. If httpClient is nil, a
+// default client is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{
+		url:        strings.TrimSuffix(url, "/") + "/" + Endpoint,
+		httpClient: httpClient,
+	}
+}
+
+// AddLeaves submits leaves, with an optional submit token, and
+// returns one result per leaf.
+func (c *Client) AddLeaves(ctx context.Context, leaves []requests.Leaf, t *token.SubmitHeader) ([]Result, error) {
+	var body bytes.Buffer
+	if err := WriteLeaves(&body, leaves); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Sigsum log-go server")
+	if t != nil {
+		req.Header.Set(submitTokenHeader, t.ToHeader())
+	}
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 1000))
+		return nil, api.NewError(rsp.StatusCode, fmt.Errorf("add-leaves failed: %s", strings.TrimSpace(string(msg))))
+	}
+	results, err := ParseResults(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(leaves) {
+		return nil, fmt.Errorf("unexpected result count %d, for %d leaves", len(results), len(leaves))
+	}
+	return results, nil
+}
+
+// NewHandler returns a handler for add-leaves requests of at most
+// maxLeaves leaves.
+func NewHandler(timeout time.Duration, maxLeaves int, addLeaves AddLeavesFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var t *token.SubmitHeader
+		if headerValue := r.Header.Get(submitTokenHeader); len(headerValue) > 0 {
+			t = &token.SubmitHeader{}
+			if err := t.FromHeader(headerValue); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s header: %v", submitTokenHeader, err), http.StatusBadRequest)
+				return
+			}
+		}
+		leaves, err := ParseLeaves(http.MaxBytesReader(w, r.Body, int64(maxLeaves)*maxLeafSize), maxLeaves)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, fmt.Sprintf("request too large, at most %d leaves allowed", maxLeaves), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		results, err := addLeaves(ctx, leaves, t)
+		if err == nil && len(results) != len(leaves) {
+			err = fmt.Errorf("internal error, got %d results for %d leaves", len(results), len(leaves))
+		}
+		if err != nil {
+			code := api.ErrorStatusCode(err)
+			if code == http.StatusInternalServerError {
+				log.Error("add-leaves failed: %v", err)
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := WriteResults(w, results); err != nil {
+			log.Debug("writing add-leaves response failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+func newLeaves(n int) []requests.Leaf {
+	leaves := make([]requests.Leaf, n)
+	for i := range leaves {
+		leaves[i] = requests.Leaf{
+			Message:   crypto.Hash{byte(i)},
+			Signature: crypto.Signature{byte(i), 1},
+			PublicKey: crypto.PublicKey{byte(i), 2},
+		}
+	}
+	return leaves
+}
+
+func TestParseLeaves(t *testing.T) {
+	leaves := newLeaves(3)
+	var buf bytes.Buffer
+	if err := WriteLeaves(&buf, leaves); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ParseLeaves(bytes.NewReader(buf.Bytes()), 3); err != nil {
+		t.Errorf("parsing failed: %v", err)
+	} else if !reflect.DeepEqual(got, leaves) {
+		t.Errorf("unexpected leaves: got %v, wanted %v", got, leaves)
+	}
+	if _, err := ParseLeaves(bytes.NewReader(buf.Bytes()), 2); err == nil {
+		t.Errorf("parsing succeeded despite too many leaves")
+	}
+	if _, err := ParseLeaves(bytes.NewReader(buf.Bytes()[:buf.Len()-10]), 3); err == nil {
+		t.Errorf("parsing of truncated request succeeded")
+	}
+	if _, err := ParseLeaves(bytes.NewReader(nil), 3); err == nil {
+		t.Errorf("parsing of empty request succeeded")
+	}
+}
+
+func TestResults(t *testing.T) {
+	results := []Result{
+		{Status: Accepted},
+		{Status: Rejected, Reason: ReasonRateLimited},
+		{Status: Sequenced},
+	}
+	var buf bytes.Buffer
+	if err := WriteResults(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "status=accepted\nstatus=rejected rate-limited\nstatus=sequenced\n"; got != want {
+		t.Errorf("unexpected response: got %q, wanted %q", got, want)
+	}
+	if got, err := ParseResults(&buf); err != nil {
+		t.Errorf("parsing failed: %v", err)
+	} else if !reflect.DeepEqual(got, results) {
+		t.Errorf("unexpected results: got %v, wanted %v", got, results)
+	}
+	for _, bad := range []string{"status=rejected\n", "status=accepted now\n", "status=lost\n", "foo=accepted\n"} {
+		if _, err := ParseResults(bytes.NewBufferString(bad)); err == nil {
+			t.Errorf("parsing of invalid response %q succeeded", bad)
+		}
+	}
+}
+
+func TestClientAndHandler(t *testing.T) {
+	leaves := newLeaves(4)
+	submitToken := token.SubmitHeader{Domain: "example.org", Token: crypto.Signature{3}}
+	var handlerErr error
+	var gotToken *token.SubmitHeader
+
+	mux := http.NewServeMux()
+	mux.Handle("/test/"+Endpoint, NewHandler(time.Minute, 4,
+		func(_ context.Context, reqs []requests.Leaf, t *token.SubmitHeader) ([]Result, error) {
+			if handlerErr != nil {
+				return nil, handlerErr
+			}
+			gotToken = t
+			results := make([]Result, len(reqs))
+			for i := range results {
+				results[i] = Result{Status: Accepted}
+			}
+			return results, nil
+		}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := NewClient(server.URL+"/test/", nil)
+
+	results, err := client.AddLeaves(context.Background(), leaves, &submitToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(leaves) {
+		t.Errorf("unexpected result count %d", len(results))
+	}
+	if gotToken == nil || *gotToken != submitToken {
+		t.Errorf("unexpected submit token: %v", gotToken)
+	}
+	if _, err := client.AddLeaves(context.Background(), newLeaves(5), nil); err == nil {
+		t.Errorf("request with too many leaves succeeded")
+	} else if got := api.ErrorStatusCode(err); got != http.StatusBadRequest && got != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status code %d for too many leaves", got)
+	}
+
+	handlerErr = api.NewError(http.StatusTooManyRequests, errors.New("slow down"))
+	if _, err := client.AddLeaves(context.Background(), leaves, nil); err == nil {
+		t.Errorf("expected error from failing handler")
+	} else if got := api.ErrorStatusCode(err); got != http.StatusTooManyRequests {
+		t.Errorf("unexpected status code %d", got)
+	}
+}
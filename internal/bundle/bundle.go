@@ -0,0 +1,316 @@
+// Package bundle implements export and import of log bundles. A
+// bundle is a directory holding a prefix of a log's tree together with
+// the cosigned tree head covering it, and serves both as an offline
+// archive of the log, and for bootstrapping a new secondary without
+// replicating each leaf over the internal get-leaves api.
+//
+// The bundle directory contains the file "tree-head", with the
+// cosigned tree head in the same format as a get-tree-head response,
+// and files "leaves/<start>-<end>", each holding the leaves with
+// indices start <= i < end, in binary format, concatenated. The leaf
+// ranges are consecutive, and together cover the tree head.
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/log-go/internal/audit"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	TreeHeadFile = "tree-head"
+	LeavesDir    = "leaves"
+
+	leafSize = 2*crypto.HashSize + crypto.SignatureSize
+)
+
+// Subset of the db/client interface, for export.
+type Source interface {
+	GetLeaves(context.Context, *requests.Leaves) ([]types.Leaf, error)
+}
+
+// Subset of the db/client interface, for import.
+type Backend interface {
+	GetTreeHead(context.Context) (types.TreeHead, error)
+	AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error
+}
+
+// Subset of sigsum-go's policy.Policy, for verifying cosignatures on
+// import.
+type Policy interface {
+	VerifyCosignedTreeHead(logKeyHash *crypto.Hash, cth *types.CosignedTreeHead) error
+}
+
+// Export writes a bundle covering the given tree head to dir, which
+// must be empty or not exist, with leaves read from source in batches
+// of batchSize, and rangeSize leaves per leaf file. The written leaves are verified against the
+// tree head before the tree head is written, so a bundle without the
+// tree head file is incomplete.
+func Export(ctx context.Context, source Source, cth *types.CosignedTreeHead, dir string,
+	rangeSize, batchSize uint64) error {
+	if rangeSize == 0 || batchSize == 0 {
+		return fmt.Errorf("invalid range size %d or batch size %d", rangeSize, batchSize)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("bundle directory %q is not empty", dir)
+	}
+	for start := uint64(0); start < cth.Size; start += rangeSize {
+		end := start + rangeSize
+		if end > cth.Size {
+			end = cth.Size
+		}
+		var data bytes.Buffer
+		for index := start; index < end; {
+			req := requests.Leaves{StartIndex: index, EndIndex: index + batchSize}
+			if req.EndIndex > end {
+				req.EndIndex = end
+			}
+			leaves, err := source.GetLeaves(ctx, &req)
+			if err != nil {
+				return fmt.Errorf("reading leaves [%d:%d] failed: %v", req.StartIndex, req.EndIndex, err)
+			}
+			if len(leaves) == 0 || uint64(len(leaves)) > req.EndIndex-req.StartIndex {
+				return fmt.Errorf("reading leaves [%d:%d] returned unexpected leaf count %d",
+					req.StartIndex, req.EndIndex, len(leaves))
+			}
+			for _, leaf := range leaves {
+				data.Write(leaf.ToBinary())
+			}
+			index += uint64(len(leaves))
+		}
+		if err := writeFile(filepath.Join(dir, LeavesDir, rangeName(start, end)), data.Bytes()); err != nil {
+			return err
+		}
+		log.Debug("exported leaves [%d:%d]", start, end)
+	}
+
+	b, err := Open(dir, cth.Size)
+	if err != nil {
+		return err
+	}
+	if _, err := audit.Audit(ctx, b, &cth.TreeHead, nil, batchSize); err != nil {
+		return fmt.Errorf("exported leaves don't match tree head: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := cth.ToASCII(&buf); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, TreeHeadFile), buf.Bytes())
+}
+
+// ReadTreeHead reads the bundle's tree head, and verifies the log's
+// signature. Cosignatures are not verified.
+func ReadTreeHead(dir string, logKey *crypto.PublicKey) (types.CosignedTreeHead, error) {
+	f, err := os.Open(filepath.Join(dir, TreeHeadFile))
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	defer f.Close()
+	var cth types.CosignedTreeHead
+	if err := cth.FromASCII(f); err != nil {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid bundle tree head: %v", err)
+	}
+	if !cth.Verify(logKey) {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid log signature on bundle tree head")
+	}
+	return cth, nil
+}
+
+// Import verifies the bundle in dir against its tree head and the
+// log's key, and adds its leaves to an empty backend. It then waits
+// until the backend has integrated all leaves, and checks that its
+// root hash matches the tree head. If policy is non-nil, the tree
+// head's cosignatures must satisfy it. Leaf signatures are verified
+// for keys in submitterKeys, which may be nil.
+func Import(ctx context.Context, backend Backend, dir string, logKey *crypto.PublicKey, policy Policy,
+	submitterKeys map[crypto.Hash]crypto.PublicKey, batchSize uint64) (types.CosignedTreeHead, error) {
+	cth, err := ReadTreeHead(dir, logKey)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	if policy != nil {
+		logKeyHash := crypto.HashBytes(logKey[:])
+		if err := policy.VerifyCosignedTreeHead(&logKeyHash, &cth); err != nil {
+			return types.CosignedTreeHead{}, fmt.Errorf("bundle tree head not accepted by policy: %v", err)
+		}
+	}
+	b, err := Open(dir, cth.Size)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	if _, err := audit.Audit(ctx, b, &cth.TreeHead, submitterKeys, batchSize); err != nil {
+		return types.CosignedTreeHead{}, fmt.Errorf("bundle leaves don't match its tree head: %v", err)
+	}
+
+	th, err := backend.GetTreeHead(ctx)
+	if err != nil {
+		return types.CosignedTreeHead{}, fmt.Errorf("getting backend tree head failed: %v", err)
+	}
+	if th.Size > 0 {
+		return types.CosignedTreeHead{}, fmt.Errorf("backend is not empty, tree size %d", th.Size)
+	}
+	for index := uint64(0); index < cth.Size; {
+		leaves, err := b.GetLeaves(ctx, &requests.Leaves{StartIndex: index, EndIndex: index + batchSize})
+		if err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		if err := backend.AddSequencedLeaves(ctx, leaves, int64(index)); err != nil {
+			return types.CosignedTreeHead{}, fmt.Errorf("adding leaves at index %d failed: %v", index, err)
+		}
+		index += uint64(len(leaves))
+		log.Debug("imported leaves up to index %d", index)
+	}
+
+	// Backends may integrate leaves asynchronously.
+	for {
+		th, err := backend.GetTreeHead(ctx)
+		if err != nil {
+			return types.CosignedTreeHead{}, fmt.Errorf("getting backend tree head failed: %v", err)
+		}
+		if th.Size > cth.Size {
+			return types.CosignedTreeHead{}, fmt.Errorf("unexpected backend tree size %d, expected %d", th.Size, cth.Size)
+		}
+		if th.Size == cth.Size {
+			if th.RootHash != cth.RootHash {
+				return types.CosignedTreeHead{}, fmt.Errorf("backend root hash doesn't match bundle tree head")
+			}
+			return cth, nil
+		}
+		log.Debug("waiting for backend to integrate leaves, size %d of %d", th.Size, cth.Size)
+		select {
+		case <-ctx.Done():
+			return types.CosignedTreeHead{}, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Bundle reads leaves from a bundle directory, and implements
+// audit.Tree, except that consistency proofs aren't supported.
+type Bundle struct {
+	dir    string
+	ranges []leafRange
+}
+
+type leafRange struct {
+	start, end uint64
+}
+
+func rangeName(start, end uint64) string {
+	return fmt.Sprintf("%d-%d", start, end)
+}
+
+// Open lists the leaf files of the bundle in dir, and checks that they
+// cover the tree of the given size.
+func Open(dir string, size uint64) (*Bundle, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, LeavesDir))
+	if err != nil && !(os.IsNotExist(err) && size == 0) {
+		return nil, err
+	}
+	b := Bundle{dir: dir}
+	for _, e := range entries {
+		name := e.Name()
+		// Temporary files left by an interrupted export.
+		if strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		r, err := parseRangeName(name)
+		if err != nil {
+			return nil, err
+		}
+		b.ranges = append(b.ranges, r)
+	}
+	sort.Slice(b.ranges, func(i, j int) bool { return b.ranges[i].start < b.ranges[j].start })
+	next := uint64(0)
+	for _, r := range b.ranges {
+		if r.start != next {
+			return nil, fmt.Errorf("missing leaves [%d:%d] in bundle", next, r.start)
+		}
+		next = r.end
+	}
+	if next != size {
+		return nil, fmt.Errorf("bundle has %d leaves, expected %d", next, size)
+	}
+	return &b, nil
+}
+
+func parseRangeName(name string) (leafRange, error) {
+	parts := strings.Split(name, "-")
+	if len(parts) == 2 {
+		start, err1 := strconv.ParseUint(parts[0], 10, 63)
+		end, err2 := strconv.ParseUint(parts[1], 10, 63)
+		if err1 == nil && err2 == nil && start < end && rangeName(start, end) == name {
+			return leafRange{start: start, end: end}, nil
+		}
+	}
+	return leafRange{}, fmt.Errorf("invalid leaf file name %q", name)
+}
+
+// GetLeaves returns leaves from a single leaf file, possibly fewer
+// than requested.
+func (b *Bundle) GetLeaves(_ context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	i := sort.Search(len(b.ranges), func(i int) bool { return b.ranges[i].end > req.StartIndex })
+	if req.StartIndex >= req.EndIndex || i == len(b.ranges) {
+		return nil, fmt.Errorf("leaves [%d:%d] not in bundle", req.StartIndex, req.EndIndex)
+	}
+	r := b.ranges[i]
+	end := req.EndIndex
+	if end > r.end {
+		end = r.end
+	}
+	f, err := os.Open(filepath.Join(b.dir, LeavesDir, rangeName(r.start, r.end)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, (end-req.StartIndex)*leafSize)
+	if _, err := f.Seek(int64((req.StartIndex-r.start)*leafSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, fmt.Errorf("reading leaves [%d:%d] from bundle failed: %v", req.StartIndex, end, err)
+	}
+	leaves := make([]types.Leaf, end-req.StartIndex)
+	for i := range leaves {
+		if err := leaves[i].FromBinary(data[i*leafSize : (i+1)*leafSize]); err != nil {
+			return nil, err
+		}
+	}
+	return leaves, nil
+}
+
+func (b *Bundle) GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	return types.ConsistencyProof{}, fmt.Errorf("consistency proofs not supported by bundles")
+}
+
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := safefile.Create(name, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	// Atomically replace old file with new.
+	return f.Commit()
+}
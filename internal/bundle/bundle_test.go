@@ -0,0 +1,160 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func mustLeaves(t *testing.T, n int) []types.Leaf {
+	t.Helper()
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := make([]types.Leaf, n)
+	for i := range leaves {
+		msg := crypto.Hash{byte(i), byte(i >> 8)}
+		sig, err := types.SignLeafMessage(signer, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves[i], err = (&requests.Leaf{Message: msg, Signature: sig, PublicKey: signer.Public()}).Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return leaves
+}
+
+type acceptingPolicy struct{}
+
+func (acceptingPolicy) VerifyCosignedTreeHead(*crypto.Hash, *types.CosignedTreeHead) error {
+	return nil
+}
+
+type rejectingPolicy struct{}
+
+func (rejectingPolicy) VerifyCosignedTreeHead(*crypto.Hash, *types.CosignedTreeHead) error {
+	return errors.New("not enough cosignatures")
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustLeaves(t, 40)
+	source := db.NewMemoryDb()
+	if err := source.AddSequencedLeaves(ctx, leaves[:30], 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := source.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := th.Sign(logSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cth := types.CosignedTreeHead{SignedTreeHead: sth}
+	// Leaves beyond the exported tree head.
+	if err := source.AddSequencedLeaves(ctx, leaves[30:], 30); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "bundle")
+	if err := Export(ctx, source, &cth, dir, 7, 3); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if err := Export(ctx, source, &cth, dir, 7, 3); err == nil {
+		t.Errorf("export to non-empty directory succeeded")
+	}
+	backend := db.NewMemoryDb()
+	imported, err := Import(ctx, backend, dir, &logPub, nil, nil, 4)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if imported.SignedTreeHead != sth {
+		t.Errorf("unexpected imported tree head")
+	}
+	if got, err := backend.GetTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	} else if got != th {
+		t.Errorf("unexpected backend tree head after import, size %d", got.Size)
+	}
+
+	// Import into a non-empty backend.
+	if _, err := Import(ctx, backend, dir, &logPub, nil, nil, 4); err == nil {
+		t.Errorf("import into non-empty backend succeeded")
+	}
+	// Policy rejecting the tree head, e.g., too few cosignatures.
+	if _, err := Import(ctx, db.NewMemoryDb(), dir, &logPub, rejectingPolicy{}, nil, 4); err == nil {
+		t.Errorf("import rejected by policy succeeded")
+	}
+	// Policy accepting the tree head.
+	if _, err := Import(ctx, db.NewMemoryDb(), dir, &logPub, acceptingPolicy{}, nil, 4); err != nil {
+		t.Errorf("import accepted by policy failed: %v", err)
+	}
+	// Wrong log key.
+	if _, err := Import(ctx, db.NewMemoryDb(), dir, &otherPub, nil, nil, 4); err == nil {
+		t.Errorf("import with wrong log key succeeded")
+	}
+
+	// Tampered leaf.
+	name := filepath.Join(dir, LeavesDir, "14-21")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[leafSize+5] ^= 1
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(ctx, db.NewMemoryDb(), dir, &logPub, nil, nil, 4); err == nil {
+		t.Errorf("import of tampered bundle succeeded")
+	}
+
+	// Missing leaf file.
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, th.Size); err == nil {
+		t.Errorf("open of incomplete bundle succeeded")
+	}
+}
+
+func TestExportMismatch(t *testing.T) {
+	ctx := context.Background()
+	_, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := db.NewMemoryDb()
+	if err := source.AddSequencedLeaves(ctx, mustLeaves(t, 10), 0); err != nil {
+		t.Fatal(err)
+	}
+	sth, err := (&types.TreeHead{Size: 10, RootHash: crypto.Hash{1}}).Sign(logSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := Export(ctx, source, &types.CosignedTreeHead{SignedTreeHead: sth}, dir, 4, 4); err == nil {
+		t.Errorf("export of leaves not matching tree head succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, TreeHeadFile)); err == nil {
+		t.Errorf("tree head written for bad export")
+	}
+}
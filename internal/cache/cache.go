@@ -0,0 +1,211 @@
+// Package cache adds caching headers to the log's read endpoints, and
+// supports conditional requests, so that a caching reverse proxy can
+// be put in front of the log.
+//
+// Each successful response gets an ETag, and a request with a
+// matching If-None-Match header gets an empty 304 response, without
+// involving the backend. The ETags are computed from the request,
+// not the response body, which is passed through unbuffered.
+//
+// The get-tree-head response is cached for a short time only, since
+// it is replaced on each rotation, and varies with the long polling
+// header; a long polling request never gets a 304 response. Its weak ETag is derived from the
+// size and root hash of the published tree head; the cosignatures
+// may change without changing the ETag. Consistency and inclusion
+// proofs, and get-leaves responses with the full requested range,
+// are immutable: the log refuses requests beyond the published tree
+// size, and the tree below it never changes. Their strong ETags are
+// derived from the request path, and for get-leaves, from the range
+// of leaves in the response. A conditional request for them gets a
+// 304 response only if it is within the published tree.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigsum.org/log-go/internal/headers"
+	"sigsum.org/log-go/internal/longpoll"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Proofs and full ranges of leaves never change, and can be
+	// cached indefinitely.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Errors, e.g., for leaves not yet included, may change at
+	// any time.
+	noCacheControl = "no-cache"
+
+	// Maximum age of cached tree heads, and partial ranges of
+	// leaves.
+	TreeHeadMaxAge = 10 * time.Second
+)
+
+// NewHandler wraps the log's public handler, adding caching headers
+// to responses from the read endpoints. The maxRange is the log's
+// maximum number of leaves per get-leaves response, and getTreeHead
+// returns the published tree head.
+func NewHandler(prefix string, maxRange int, getTreeHead func(context.Context) (types.CosignedTreeHead, error),
+	next http.Handler) http.Handler {
+	prefix = "/" + path.Join(prefix)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		endpoint, args := splitPath(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/"))
+		var etag, cacheControl string
+		// The "*" condition matches any existing response,
+		// which is known to exist only for get-tree-head.
+		matchAny := false
+		// Tree size needed for the response to exist, checked
+		// before answering a conditional request.
+		var needSize uint64
+		conditional := true
+		switch endpoint {
+		case "get-tree-head":
+			// Responses depend on the long polling header.
+			w.Header().Set("Vary", longpoll.WaitSizeHeader)
+			cth, err := getTreeHead(r.Context())
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			etag = fmt.Sprintf("W/\"%d-%x\"", cth.Size, cth.RootHash[:8])
+			cacheControl = shortCacheControl()
+			matchAny = true
+			if len(r.Header.Get(longpoll.WaitSizeHeader)) > 0 {
+				// A client waiting for a larger tree
+				// gets the tree head, even if unchanged.
+				conditional = false
+			}
+		case "get-consistency-proof", "get-inclusion-proof":
+			size, ok := proofSize(endpoint, args)
+			if !ok {
+				// Rejected by the log.
+				break
+			}
+			etag = pathETag(endpoint, args)
+			cacheControl = immutableCacheControl
+			needSize = size
+		case "get-leaves":
+			start, end, ok := leafRange(args)
+			if !ok {
+				// Rejected by the log.
+				break
+			}
+			needSize = end
+			cacheControl = immutableCacheControl
+			if end-start > uint64(maxRange) {
+				// Truncated, depending on the
+				// server's configuration.
+				end = start + uint64(maxRange)
+				cacheControl = shortCacheControl()
+			}
+			etag = pathETag(endpoint, []string{strconv.FormatUint(start, 10), strconv.FormatUint(end, 10)})
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if conditional && len(etag) > 0 && matchesETag(r.Header.Get("If-None-Match"), etag, matchAny) &&
+			isPublished(r.Context(), getTreeHead, needSize) {
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writer := headers.NewWriter(w, func(h http.Header, status int) {
+			if status != http.StatusOK || len(etag) == 0 {
+				h.Set("Cache-Control", noCacheControl)
+				return
+			}
+			h.Set("Cache-Control", cacheControl)
+			h.Set("ETag", etag)
+		})
+		next.ServeHTTP(writer, r)
+		writer.Finish()
+	})
+}
+
+func shortCacheControl() string {
+	return fmt.Sprintf("public, max-age=%d", int(TreeHeadMaxAge.Seconds()))
+}
+
+// Returns a strong etag for an immutable response, from a truncated
+// sha256 hash of the endpoint and its arguments.
+func pathETag(endpoint string, args []string) string {
+	h := sha256.Sum256([]byte(path.Join(append([]string{endpoint}, args...)...)))
+	return fmt.Sprintf("%q", hex.EncodeToString(h[:16]))
+}
+
+// Returns true if the published tree has at least the given size. A
+// request beyond the published tree is refused by the log, so it
+// must not get a 304 response.
+func isPublished(ctx context.Context, getTreeHead func(context.Context) (types.CosignedTreeHead, error), size uint64) bool {
+	if size == 0 {
+		return true
+	}
+	cth, err := getTreeHead(ctx)
+	return err == nil && size <= cth.Size
+}
+
+// Returns the tree size of a consistency or inclusion proof request,
+// i.e., new_size or tree_size.
+func proofSize(endpoint string, args []string) (uint64, bool) {
+	if len(args) != 2 {
+		return 0, false
+	}
+	arg := args[0]
+	if endpoint == "get-consistency-proof" {
+		arg = args[1]
+	}
+	size, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || size == 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// Splits an endpoint path into the endpoint name and its arguments.
+func splitPath(p string) (string, []string) {
+	parts := strings.Split(p, "/")
+	return parts[0], parts[1:]
+}
+
+// Parses the arguments of a get-leaves request.
+func leafRange(args []string) (uint64, uint64, bool) {
+	if len(args) != 2 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || end <= start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// Checks an If-None-Match header, a comma-separated list of etags, or
+// "*", which is accepted only if matchAny is set. Uses the weak
+// comparison of RFC 9110.
+func matchesETag(header, etag string, matchAny bool) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if (candidate == "*" && matchAny) || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
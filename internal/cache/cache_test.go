@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sigsum.org/log-go/internal/longpoll"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestHandler(t *testing.T) {
+	var cth types.CosignedTreeHead
+	cth.Size = 10
+	calls := 0
+	h := NewHandler("prefix", 2, func(_ context.Context) (types.CosignedTreeHead, error) {
+		return cth, nil
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/get-tree-head"):
+			fmt.Fprintf(w, "size=%d\n", cth.Size)
+		case strings.Contains(r.URL.Path, "/get-leaves/"):
+			// Truncates to at most two leaves.
+			fmt.Fprintf(w, "leaf=1 2 3\nleaf=4 5 6\n")
+		case strings.Contains(r.URL.Path, "/get-inclusion-proof/"):
+			http.Error(w, "not found", http.StatusNotFound)
+		default:
+			fmt.Fprintf(w, "ok\n")
+		}
+	}))
+	for _, table := range []struct {
+		desc      string
+		method    string
+		path      string
+		wantCode  int
+		wantCache string // Empty for no caching headers
+	}{
+		{"tree head", http.MethodGet, "/prefix/get-tree-head", http.StatusOK, "public, max-age=10"},
+		{"proof", http.MethodGet, "/prefix/get-consistency-proof/1/2", http.StatusOK, immutableCacheControl},
+		{"full range", http.MethodGet, "/prefix/get-leaves/1/3", http.StatusOK, immutableCacheControl},
+		{"partial range", http.MethodGet, "/prefix/get-leaves/1/5", http.StatusOK, "public, max-age=10"},
+		{"invalid range", http.MethodGet, "/prefix/get-leaves/3/1", http.StatusOK, noCacheControl},
+		{"not found", http.MethodGet, "/prefix/get-inclusion-proof/2/00", http.StatusNotFound, noCacheControl},
+		{"add-leaf", http.MethodPost, "/prefix/add-leaf", http.StatusOK, ""},
+		{"other endpoint", http.MethodGet, "/prefix/checkpoint", http.StatusOK, ""},
+	} {
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest(table.method, table.path, nil))
+		if rsp.Code != table.wantCode {
+			t.Errorf("%s: unexpected status %d, wanted %d", table.desc, rsp.Code, table.wantCode)
+		}
+		if got := rsp.Header().Get("Cache-Control"); got != table.wantCache {
+			t.Errorf("%s: unexpected Cache-Control %q, wanted %q", table.desc, got, table.wantCache)
+		}
+		etag := rsp.Header().Get("ETag")
+		if (len(etag) > 0) != (table.wantCode == http.StatusOK && len(table.wantCache) > 0 && table.wantCache != noCacheControl) {
+			t.Errorf("%s: unexpected ETag %q", table.desc, etag)
+		}
+		if len(etag) == 0 {
+			continue
+		}
+		matches := []string{etag, "\"other\", W/" + strings.TrimPrefix(etag, "W/")}
+		if table.desc == "tree head" {
+			matches = append(matches, "*")
+		}
+		for _, match := range matches {
+			req := httptest.NewRequest(table.method, table.path, nil)
+			req.Header.Set("If-None-Match", match)
+			rsp := httptest.NewRecorder()
+			calls = 0
+			h.ServeHTTP(rsp, req)
+			if rsp.Code != http.StatusNotModified || rsp.Body.Len() > 0 || rsp.Header().Get("ETag") != etag {
+				t.Errorf("%s: unexpected conditional response for %q, status %d, body %q",
+					table.desc, match, rsp.Code, rsp.Body.String())
+			}
+			if calls > 0 {
+				t.Errorf("%s: conditional response for %q not answered by the cache", table.desc, match)
+			}
+		}
+		for _, match := range []string{"\"other\"", "*"} {
+			if table.desc == "tree head" && match == "*" {
+				continue
+			}
+			req := httptest.NewRequest(table.method, table.path, nil)
+			req.Header.Set("If-None-Match", match)
+			rsp = httptest.NewRecorder()
+			h.ServeHTTP(rsp, req)
+			if rsp.Code != http.StatusOK {
+				t.Errorf("%s: unexpected status %d for mismatched etag %q", table.desc, rsp.Code, match)
+			}
+		}
+	}
+}
+
+func TestTreeHeadETag(t *testing.T) {
+	var cth types.CosignedTreeHead
+	h := NewHandler("", 2, func(_ context.Context) (types.CosignedTreeHead, error) {
+		return cth, nil
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	etag := func() string {
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, "/get-tree-head", nil))
+		return rsp.Header().Get("ETag")
+	}
+	cth.Size = 3
+	old := etag()
+	cth.Cosignatures = append(cth.Cosignatures, types.Cosignature{Timestamp: 1})
+	if got := etag(); got != old {
+		t.Errorf("etag changed with cosignatures, from %q to %q", old, got)
+	}
+	cth.Size = 4
+	if got := etag(); got == old {
+		t.Errorf("etag unchanged for new tree head")
+	}
+}
+
+func TestBeyondTree(t *testing.T) {
+	var cth types.CosignedTreeHead
+	cth.Size = 3
+	h := NewHandler("", 10, func(_ context.Context) (types.CosignedTreeHead, error) {
+		return cth, nil
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "outside of current tree", http.StatusBadRequest)
+	}))
+	for _, p := range []string{
+		"/get-leaves/1/5",
+		"/get-consistency-proof/1/5",
+		"/get-inclusion-proof/5/" + strings.Repeat("00", 32),
+	} {
+		endpoint, args := splitPath(strings.TrimPrefix(p, "/"))
+		etag := pathETag(endpoint, args)
+		req := httptest.NewRequest(http.MethodGet, p, nil)
+		req.Header.Set("If-None-Match", etag)
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, req)
+		if rsp.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status %d for request beyond the tree", p, rsp.Code)
+		}
+		if got := rsp.Header().Get("ETag"); got != "" {
+			t.Errorf("%s: unexpected ETag %q for request beyond the tree", p, got)
+		}
+	}
+}
+
+func TestTreeHeadWait(t *testing.T) {
+	var cth types.CosignedTreeHead
+	cth.Size = 3
+	h := NewHandler("", 2, func(_ context.Context) (types.CosignedTreeHead, error) {
+		return cth, nil
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "size=%d\n", cth.Size)
+	}))
+	rsp := httptest.NewRecorder()
+	h.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, "/get-tree-head", nil))
+	etag := rsp.Header().Get("ETag")
+	if got := rsp.Header().Get("Vary"); got != longpoll.WaitSizeHeader {
+		t.Errorf("unexpected Vary header %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/get-tree-head", nil)
+	req.Header.Set("If-None-Match", etag)
+	req.Header.Set(longpoll.WaitSizeHeader, "3")
+	rsp = httptest.NewRecorder()
+	h.ServeHTTP(rsp, req)
+	if rsp.Code != http.StatusOK {
+		t.Errorf("unexpected status %d for long polling request", rsp.Code)
+	}
+	if got := rsp.Header().Get("Vary"); got != longpoll.WaitSizeHeader {
+		t.Errorf("unexpected Vary header %q for long polling request", got)
+	}
+}
@@ -12,18 +12,49 @@ import (
 
 // Primary Config
 type Primary struct {
-	PolicyFile          string `toml:"policy-file"`
-	RateLimitFile       string `toml:"rate-limit-file"`
-	AllowTestDomain     bool   `toml:"allow-test-domain"`
-	SecondaryURL        string `toml:"secondary-url"`
-	SecondaryPubkeyFile string `toml:"secondary-pubkey-file"`
-	SthFile             string `toml:"sth-file"`
-	MaxRange            int    `toml:"max-range"`
+	PolicyFile              string        `toml:"policy-file"`
+	RateLimitFile           string        `toml:"rate-limit-file"`
+	AllowTestDomain         bool          `toml:"allow-test-domain"`
+	SecondaryURL            string        `toml:"secondary-url"`
+	SecondaryPubkeyFile     string        `toml:"secondary-pubkey-file"`
+	SecondaryNotifyInterval time.Duration `toml:"secondary-notify-interval"`
+	SecondaryRequireFresh   bool          `toml:"secondary-require-fresh"`
+	SthFile                 string        `toml:"sth-file"`
+	MaxRange                int           `toml:"max-range"`
+	MaxBatchSize            int           `toml:"max-batch-size"`
+	LongPollTimeout         time.Duration `toml:"long-poll-timeout"`
+	LongPollMaxWaiters      int           `toml:"long-poll-max-waiters"`
+	WebhookQueueFile        string        `toml:"webhook-queue-file"`
+	WebhookHooksFile        string        `toml:"webhook-hooks-file"`
+	WebhookAllowURLs        bool          `toml:"webhook-allow-urls"`
+	ReceiptDeadline         time.Duration `toml:"receipt-deadline"`
+	ReceiptFile             string        `toml:"receipt-file"`
+	KeyIndexFile            string        `toml:"key-index-file"`
+	ReadOnly                bool          `toml:"read-only"`
+	AdminPubkeyFile         string        `toml:"admin-pubkey-file"`
+	MaxBacklog              int           `toml:"max-backlog"`
+	MaxBackendLatency       time.Duration `toml:"max-backend-latency"`
+	ServeTiles              bool          `toml:"serve-tiles"`
+	ExportDirectory         string        `toml:"export-directory"`
 }
 
 // Secondary Config
 type Secondary struct {
-	PrimaryURL string `toml:"primary-url"`
+	PrimaryURL        string `toml:"primary-url"`
+	PrimaryPublicURL  string `toml:"primary-public-url"`
+	PrimaryPubkeyFile string `toml:"primary-pubkey-file"`
+	SubmitterKeysFile string `toml:"submitter-keys-file"`
+	BatchSize         int    `toml:"batch-size"`
+	FetchConcurrency  int    `toml:"fetch-concurrency"`
+	ReadReplica       bool   `toml:"read-replica"`
+}
+
+// Mirror Config
+type Mirror struct {
+	LogURL        string `toml:"log-url"`
+	LogPubkeyFile string `toml:"log-pubkey-file"`
+	PolicyFile    string `toml:"policy-file"`
+	BatchSize     int    `toml:"batch-size"`
 }
 
 type Config struct {
@@ -37,9 +68,12 @@ type Config struct {
 	TrillianRpcServer  string        `toml:"trillian-rpc-server"`
 	Backend            string        `toml:"backend"`
 	TrillianTreeIDFile string        `toml:"trillian-tree-id-file"`
+	SqlDatabase        string        `toml:"sql-database"`
 	KeyFile            string        `toml:"key-file"`
+	AuthInternal       bool          `toml:"authenticate-internal"`
 	Primary            `toml:"primary"`
 	Secondary          `toml:"secondary"`
+	Mirror             `toml:"mirror"`
 }
 
 func NewConfig() *Config {
@@ -51,22 +85,53 @@ func NewConfig() *Config {
 		Backend:            "trillian",
 		Prefix:             "",
 		TrillianTreeIDFile: "/var/lib/sigsum-log/tree-id",
+		SqlDatabase:        "/var/lib/sigsum-log/tree.db",
 		Timeout:            time.Second * 10,
 		KeyFile:            "",
+		AuthInternal:       false,
 		Interval:           time.Second * 30,
 		LogFile:            "",
 		LogLevel:           "info",
 		Primary: Primary{
-			PolicyFile:          "",
-			RateLimitFile:       "",
-			AllowTestDomain:     false,
-			SecondaryURL:        "",
-			SecondaryPubkeyFile: "",
-			SthFile:             "/var/lib/sigsum-log/sth",
-			MaxRange:            10,
+			PolicyFile:              "",
+			RateLimitFile:           "",
+			AllowTestDomain:         false,
+			SecondaryURL:            "",
+			SecondaryPubkeyFile:     "",
+			SecondaryRequireFresh:   false,
+			SecondaryNotifyInterval: time.Second,
+			SthFile:                 "/var/lib/sigsum-log/sth",
+			MaxRange:                10,
+			MaxBatchSize:            1000,
+			LongPollTimeout:         0,
+			LongPollMaxWaiters:      1000,
+			WebhookQueueFile:        "",
+			WebhookHooksFile:        "",
+			WebhookAllowURLs:        false,
+			ReceiptDeadline:         0,
+			ReceiptFile:             "",
+			KeyIndexFile:            "",
+			ReadOnly:                false,
+			AdminPubkeyFile:         "",
+			MaxBacklog:              0,
+			MaxBackendLatency:       0,
+			ServeTiles:              false,
+			ExportDirectory:         "",
 		},
 		Secondary: Secondary{
-			PrimaryURL: "",
+			PrimaryURL:        "",
+			PrimaryPublicURL:  "",
+			PrimaryPubkeyFile: "",
+			SubmitterKeysFile: "",
+			BatchSize:         100,
+			FetchConcurrency:  4,
+			ReadReplica:       false,
+		},
+		Mirror: Mirror{
+			LogURL:        "",
+			LogPubkeyFile: "",
+			PolicyFile:    "",
+			BatchSize:     100,
 		},
 	}
 }
@@ -106,11 +171,13 @@ func (c *Config) ServerFlags(set *getopt.Set) {
 	set.FlagLong(&c.ExternalEndpoint, "external-endpoint", 0, "TCP listen port for serving clients.", "host:port")
 	set.FlagLong(&c.InternalEndpoint, "internal-endpoint", 0, "Internal TCP listen port, for metrics and replication with other nodes.", "host:port")
 	set.FlagLong(&c.TrillianRpcServer, "trillian-rpc-server", 0, "TCP port for Trillian backend server.", "host:port")
-	set.FlagLong(&c.Backend, "backend", 0, "Either \"trillian\" (connect to an external Trillian server) or \"ephemeral\" (use in-memory backend, with NO persistent storage.")
+	set.FlagLong(&c.Backend, "backend", 0, "One of \"trillian\" (connect to an external Trillian server), \"sqlite\" or \"postgres\" (store the tree directly in a SQL database), or \"ephemeral\" (use in-memory backend, with NO persistent storage).")
 	set.FlagLong(&c.Prefix, "url-prefix", 0, "Optional URL prefix, preceding endpoint names such as /get-tree-head.", "string")
 	set.FlagLong(&c.TrillianTreeIDFile, "trillian-tree-id-file", 0, "Trillian backend tree identifier.", "file")
+	set.FlagLong(&c.SqlDatabase, "sql-database", 0, "Database file for the sqlite backend, or connection string for the postgres backend.", "string")
 	set.FlagLong(&c.Timeout, "timeout", 0, "Timeout for outgoing requests.")
 	set.FlagLong(&c.KeyFile, "key-file", 0, "Key file (openssh format), either an unencrypted private key, or a public key (accessed via ssh-agent).", "file")
+	set.FlagLong(&c.AuthInternal, "authenticate-internal", 0, "Sign requests to, and require signed requests from, the other node on the internal endpoint.")
 	set.FlagLong(&c.Interval, "interval", 0, "Interval used to rotate the log's cosigned tree head.")
 	set.FlagLong(&c.LogFile, "log-file", 0, "File to write logs to, or stderr if unset.", "file")
 	set.FlagLong(&c.LogLevel, "log-level", 0, "Log level (Available options: debug, info, warning, error).", "level")
@@ -12,22 +12,53 @@ trillian-rpc-server = "localhost:6962"
 backend = "trillian"
 url-prefix = ""
 trillian-tree-id-file = "/var/lib/sigsum-log/tree-id"
+sql-database = "/var/lib/sigsum-log/tree.db"
 timeout = "10s"
 interval = "30s"
 key-file = "test"
+authenticate-internal = false
 log-file = ""
 log-level = "info"
 
 [primary]
 max-range = 10
+max-batch-size = 1000
+long-poll-timeout = "0s"
+long-poll-max-waiters = 1000
+webhook-queue-file = ""
+webhook-hooks-file = ""
+webhook-allow-urls = false
+receipt-deadline = "0s"
+receipt-file = ""
+key-index-file = ""
+read-only = false
+admin-pubkey-file = ""
+max-backlog = 0
+max-backend-latency = "0s"
 rate-limit-file = ""
 allow-test-domain = false
 secondary-url = ""
 secondary-pubkey-file = ""
+secondary-notify-interval = "1s"
+secondary-require-fresh = false
 sth-file = "/var/lib/sigsum-log/sth"
+serve-tiles = false
+export-directory = ""
 
 [secondary]
 primary-url = "http://localhost:9091"
+primary-public-url = ""
+primary-pubkey-file = ""
+submitter-keys-file = ""
+batch-size = 100
+fetch-concurrency = 4
+read-replica = false
+
+[mirror]
+log-url = "https://log.example.org/"
+log-pubkey-file = ""
+policy-file = ""
+batch-size = 100
 `
 
 func TestReadConfig(t *testing.T) {
@@ -42,4 +73,7 @@ func TestReadConfig(t *testing.T) {
 	if conf.Secondary.PrimaryURL != "http://localhost:9091" {
 		t.Fatalf("Failed to parse primary configuration")
 	}
+	if conf.Mirror.LogURL != "https://log.example.org/" {
+		t.Fatalf("Failed to parse mirror configuration")
+	}
 }
@@ -18,6 +18,10 @@ var ErrNotIncluded = errors.New("not included")
 // Client is an interface that interacts with a log's database backend
 type Client interface {
 	AddLeaf(context.Context, *types.Leaf, uint64) (AddLeafStatus, error)
+	// AddLeaves is the batch version of AddLeaf, returning one
+	// status per leaf, in order. On error, an unspecified subset
+	// of the leaves may have been added.
+	AddLeaves(context.Context, []types.Leaf, uint64) ([]AddLeafStatus, error)
 	AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error
 	GetTreeHead(context.Context) (types.TreeHead, error)
 	GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error)
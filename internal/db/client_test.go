@@ -0,0 +1,180 @@
+package db
+
+// Behavioural tests shared by all Client implementations.
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func testClientAddLeaf(t *testing.T, db Client) {
+	leaves := newLeaves(2)
+
+	for _, table := range []struct {
+		desc string
+		leaf *types.Leaf
+		size uint64
+		want AddLeafStatus
+	}{
+		{"new leaf", &leaves[0], 0, AddLeafStatus{}},
+		{"existing leaf", &leaves[0], 0, AddLeafStatus{AlreadyExists: true}},
+		{"sequenced leaf", &leaves[0], 1, AddLeafStatus{AlreadyExists: true, IsSequenced: true}},
+		// Corner case; this backend sequences leaves immediately.
+		{"second leaf", &leaves[1], 1, AddLeafStatus{}},
+	} {
+		status, err := db.AddLeaf(context.Background(), table.leaf, table.size)
+		if err != nil {
+			t.Fatalf("AddLeaf failed in test: %q: %v", table.desc, err)
+		}
+		if status != table.want {
+			t.Errorf("got status %#v, wanted %#v in test: %q", status, table.want, table.desc)
+		}
+	}
+}
+
+func testClientAddLeaves(t *testing.T, db Client) {
+	leaves := newLeaves(4)
+	if _, err := db.AddLeaf(context.Background(), &leaves[0], 0); err != nil {
+		t.Fatalf("AddLeaf of initial leaf failed: %v", err)
+	}
+	batch := []types.Leaf{leaves[1], leaves[0], leaves[2], leaves[1]}
+	status, err := db.AddLeaves(context.Background(), batch, 1)
+	if err != nil {
+		t.Fatalf("AddLeaves failed: %v", err)
+	}
+	want := []AddLeafStatus{{}, {AlreadyExists: true, IsSequenced: true}, {}, {AlreadyExists: true}}
+	if len(status) != len(want) {
+		t.Fatalf("got %d status values, wanted %d", len(status), len(want))
+	}
+	for i := range want {
+		if status[i] != want[i] {
+			t.Errorf("got status %#v, wanted %#v for leaf %d", status[i], want[i], i)
+		}
+	}
+	th, err := db.GetTreeHead(context.Background())
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if th.Size != 3 {
+		t.Errorf("unexpected tree size %d after AddLeaves, wanted 3", th.Size)
+	}
+}
+
+func testClientAddSequencedLeaves(t *testing.T, db Client) {
+	leaves := newLeaves(5)
+	if _, err := db.AddLeaf(context.Background(), &leaves[0], 0); err != nil {
+		t.Fatalf("AddLeaf of initial leaf failed: %v", err)
+	}
+	if err := db.AddSequencedLeaves(context.Background(), leaves[1:], 0); err == nil {
+		t.Fatalf("AddSequencedLeaves with bad index unexpectedly succeeded")
+	}
+	if err := db.AddSequencedLeaves(context.Background(), leaves[1:], 1); err != nil {
+		t.Fatalf("AddSequencedLeaves (1:5) failed: %v", err)
+	}
+}
+
+func testClientGetLeaves(t *testing.T, db Client) {
+	leaves := newLeaves(5)
+	if err := db.AddSequencedLeaves(context.Background(), leaves[:], 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	for start := 0; start <= 5; start++ {
+		for end := 0; end <= 5; end++ {
+			res, err := db.GetLeaves(context.Background(), &requests.Leaves{uint64(start), uint64(end)})
+			if start >= end {
+				if err == nil {
+					t.Errorf("no error for invalid range start %d, end %d", start, end)
+				}
+			} else if err != nil {
+				t.Errorf("GetLeaves failed for range start %d, end %d: %v", start, end, err)
+			} else if len(res) != end-start {
+				t.Errorf("unexpected result len %d for range start %d, end %d", len(res), start, end)
+			} else {
+				for i, leaf := range res {
+					if leaf != leaves[start+i] {
+						t.Errorf("wrong leaf data for leaf %d (start %d): got %#v, wanted: %#v",
+							start+i, start, leaf, leaves[start+i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func testClientInclusionProof(t *testing.T, db Client) {
+	leaves := newLeaves(5)
+	for i, leaf := range leaves {
+		if _, err := db.AddLeaf(context.Background(), &leaf, 0); err != nil {
+			t.Fatalf("AddLeaf failed of leaf %d failed: %v", i, err)
+		}
+	}
+	th, err := db.GetTreeHead(context.Background())
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if th.Size != 5 {
+		t.Fatalf("unexpected tree size, got %d, expected 5", th.Size)
+	}
+	for i, leaf := range leaves {
+		leafHash := merkle.HashLeafNode(leaf.ToBinary())
+		proof, err := db.GetInclusionProof(context.Background(), &requests.InclusionProof{
+			LeafHash: leafHash,
+			Size:     5,
+		})
+		if err != nil {
+			t.Errorf("GetInclusionProof for leaf %d failed: %v", i, err)
+		} else if proof.LeafIndex != uint64(i) {
+			t.Errorf("GetInclusionProof index: got %d, wanted %d", proof.LeafIndex, i)
+		} else if err := proof.Verify(&leafHash, &th); err != nil {
+			t.Errorf("inclusion path for leaf %d is invalid: %v", i, err)
+		}
+	}
+}
+
+func testClientConsistencyProof(t *testing.T, db Client) {
+	leaves := newLeaves(5)
+	treeHeads := []types.TreeHead{{Size: 0, RootHash: merkle.HashEmptyTree()}}
+
+	for i, leaf := range leaves {
+		if _, err := db.AddLeaf(context.Background(), &leaf, 0); err != nil {
+			t.Fatalf("AddLeaf failed of leaf %d failed: %v", i, err)
+		}
+		th, err := db.GetTreeHead(context.Background())
+		if err != nil {
+			t.Fatalf("GetTreeHead failed after leaf %d: %v", i, err)
+		}
+		if th.Size != uint64(i)+1 {
+			t.Fatalf("GetTreeHead return unexpected tree size %d after leaf %d", th.Size, i)
+		}
+		treeHeads = append(treeHeads, th)
+	}
+	for oldSize := 0; oldSize <= 5; oldSize++ {
+		for newSize := oldSize; newSize <= 5; newSize++ {
+			proof, err := db.GetConsistencyProof(context.Background(), &requests.ConsistencyProof{
+				OldSize: uint64(oldSize),
+				NewSize: uint64(newSize),
+			})
+			if err != nil {
+				t.Errorf("GetConsistencyProof failed for oldSize %d, newSize %d: %v", oldSize, newSize, err)
+			} else if err := proof.Verify(&treeHeads[oldSize], &treeHeads[newSize]); err != nil {
+				t.Errorf("consistent path for oldSize %d, newSize %d is invalid: %v", oldSize, newSize, err)
+			}
+		}
+	}
+}
+
+func newLeaves(n int) []types.Leaf {
+	leaves := make([]types.Leaf, n)
+	for i := 0; i < n; i++ {
+		var blob [8]byte
+		binary.BigEndian.PutUint64(blob[:], uint64(i))
+		leaves[i].Checksum = crypto.HashBytes(blob[:])
+	}
+	return leaves
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+)
+
+// CopyLeaves copies the leaves with indices [start, end) from src to
+// dst, which must currently have size start. Leaves are read and
+// written in batches of at most batchSize leaves.
+func CopyLeaves(ctx context.Context, dst Client, src Client, start, end uint64, batchSize uint64) error {
+	if batchSize == 0 {
+		return fmt.Errorf("invalid batch size 0")
+	}
+	for index := start; index < end; {
+		req := requests.Leaves{
+			StartIndex: index,
+			EndIndex:   index + batchSize,
+		}
+		if req.EndIndex > end {
+			req.EndIndex = end
+		}
+		leaves, err := src.GetLeaves(ctx, &req)
+		if err != nil {
+			return fmt.Errorf("reading leaves [%d:%d] failed: %v", req.StartIndex, req.EndIndex, err)
+		}
+		if len(leaves) == 0 {
+			return fmt.Errorf("reading leaves [%d:%d] returned no leaves", req.StartIndex, req.EndIndex)
+		}
+		if uint64(len(leaves)) > req.EndIndex-req.StartIndex {
+			return fmt.Errorf("reading leaves [%d:%d] returned too many leaves: %d",
+				req.StartIndex, req.EndIndex, len(leaves))
+		}
+		if err := dst.AddSequencedLeaves(ctx, leaves, int64(index)); err != nil {
+			return fmt.Errorf("writing leaves [%d:%d] failed: %v", index, index+uint64(len(leaves)), err)
+		}
+		index += uint64(len(leaves))
+		log.Debug("copied leaves up to index %d", index)
+	}
+	return nil
+}
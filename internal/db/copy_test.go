@@ -0,0 +1,40 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCopyLeaves(t *testing.T) {
+	ctx := context.Background()
+	leaves := newLeaves(17)
+	src := NewMemoryDb()
+	if err := src.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	want, err := src.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	for _, batchSize := range []uint64{1, 3, 17, 100} {
+		dst := NewMemoryDb()
+		// Copy in two steps, to exercise resuming a partial copy.
+		if err := CopyLeaves(ctx, dst, src, 0, 5, batchSize); err != nil {
+			t.Fatalf("CopyLeaves (batch size %d) of [0:5] failed: %v", batchSize, err)
+		}
+		if err := CopyLeaves(ctx, dst, src, 5, 17, batchSize); err != nil {
+			t.Fatalf("CopyLeaves (batch size %d) of [5:17] failed: %v", batchSize, err)
+		}
+		if got, err := dst.GetTreeHead(ctx); err != nil {
+			t.Fatalf("GetTreeHead failed: %v", err)
+		} else if got != want {
+			t.Errorf("unexpected tree head (batch size %d), got %v, wanted %v", batchSize, got, want)
+		}
+	}
+	if err := CopyLeaves(ctx, NewMemoryDb(), src, 1, 5, 3); err == nil {
+		t.Errorf("CopyLeaves to destination of wrong size unexpectedly succeeded")
+	}
+	if err := CopyLeaves(ctx, NewMemoryDb(), src, 0, 18, 3); err == nil {
+		t.Errorf("CopyLeaves beyond end of source unexpectedly succeeded")
+	}
+}
@@ -40,6 +40,17 @@ func (db *MemoryDb) AddLeaf(_ context.Context, leaf *types.Leaf, treeSize uint64
 	return AddLeafStatus{}, nil
 }
 
+func (db *MemoryDb) AddLeaves(ctx context.Context, leaves []types.Leaf, treeSize uint64) ([]AddLeafStatus, error) {
+	res := make([]AddLeafStatus, len(leaves))
+	for i := range leaves {
+		var err error
+		if res[i], err = db.AddLeaf(ctx, &leaves[i], treeSize); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
 func (db *MemoryDb) AddSequencedLeaves(_ context.Context, leaves []types.Leaf, index int64) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
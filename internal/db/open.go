@@ -0,0 +1,25 @@
+package db
+
+import (
+	"fmt"
+
+	"sigsum.org/log-go/internal/config"
+)
+
+// Open returns a client for the backend selected by conf.Backend,
+// using the trillian or sql settings of conf. The tree type is only
+// checked for the trillian backend.
+func Open(conf *config.Config, treeType TreeType) (Client, error) {
+	switch conf.Backend {
+	case "ephemeral":
+		return NewMemoryDb(), nil
+	case "sqlite":
+		return OpenSqlDb(SqliteDriver, conf.SqlDatabase)
+	case "postgres":
+		return OpenSqlDb(PostgresDriver, conf.SqlDatabase)
+	case "trillian":
+		return DialTrillian(conf.TrillianRpcServer, conf.Timeout, treeType, conf.TrillianTreeIDFile)
+	default:
+		return nil, fmt.Errorf("unknown backend %q, must be \"trillian\" (default), \"sqlite\", \"postgres\", or \"ephemeral\"", conf.Backend)
+	}
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"sigsum.org/log-go/internal/config"
+)
+
+func TestOpen(t *testing.T) {
+	conf := config.NewConfig()
+
+	conf.Backend = "ephemeral"
+	if client, err := Open(conf, PrimaryTree); err != nil {
+		t.Errorf("ephemeral: %v", err)
+	} else if _, ok := client.(*MemoryDb); !ok {
+		t.Errorf("ephemeral: unexpected client type %T", client)
+	}
+
+	conf.Backend = "sqlite"
+	conf.SqlDatabase = filepath.Join(t.TempDir(), "sigsum-test.db")
+	if client, err := Open(conf, SecondaryTree); err != nil {
+		t.Errorf("sqlite: %v", err)
+	} else if sqlClient, ok := client.(*SqlClient); !ok {
+		t.Errorf("sqlite: unexpected client type %T", client)
+	} else {
+		sqlClient.Close()
+	}
+
+	conf.Backend = "foo"
+	if _, err := Open(conf, AnyTree); err == nil {
+		t.Errorf("expected error for unknown backend")
+	}
+}
@@ -0,0 +1,452 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	SqliteDriver   = "sqlite3"
+	PostgresDriver = "postgres"
+)
+
+// Leaves are stored by index, together with the leaf hash. The
+// nodes table caches the hash of each complete subtree, identified
+// by level (log2 of the number of leaves it covers) and index
+// within that level. Level 0, i.e., the leaf hashes, is not
+// duplicated in the nodes table.
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS leaves (
+	leaf_index BIGINT NOT NULL PRIMARY KEY,
+	leaf_hash  %[1]s NOT NULL UNIQUE,
+	leaf_data  %[1]s NOT NULL
+);
+CREATE TABLE IF NOT EXISTS nodes (
+	level      BIGINT NOT NULL,
+	node_index BIGINT NOT NULL,
+	hash       %[1]s NOT NULL,
+	PRIMARY KEY (level, node_index)
+);
+`
+
+// Common subset of sql.DB and sql.Tx.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SqlClient implements the Client interface by storing the tree
+// directly in a SQL database, either SQLite or PostgreSQL. Leaves are
+// sequenced immediately when added. The database must not be
+// modified by any other process while in use.
+type SqlClient struct {
+	db *sql.DB
+	// Serializes all writes, since each new leaf is assigned the
+	// index corresponding to the current tree size.
+	mu sync.Mutex
+}
+
+// OpenSqlDb opens the database, and creates the needed tables if they
+// don't already exist. For SQLite, dataSource is the file name, for
+// PostgreSQL, it is a connection string.
+func OpenSqlDb(driver, dataSource string) (*SqlClient, error) {
+	var blobType string
+	switch driver {
+	case SqliteDriver:
+		blobType = "BLOB"
+	case PostgresDriver:
+		blobType = "BYTEA"
+	default:
+		return nil, fmt.Errorf("unsupported sql driver %q", driver)
+	}
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("opening database failed: %v", err)
+	}
+	if driver == SqliteDriver {
+		// Avoid "database is locked" errors, and make
+		// in-memory databases work as expected.
+		db.SetMaxOpenConns(1)
+	}
+	for _, stmt := range strings.Split(fmt.Sprintf(sqlSchema, blobType), ";") {
+		if len(strings.TrimSpace(stmt)) == 0 {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating database tables failed: %v", err)
+		}
+	}
+	return &SqlClient{db: db}, nil
+}
+
+func (c *SqlClient) Close() error {
+	return c.db.Close()
+}
+
+func (c *SqlClient) AddLeaf(ctx context.Context, leaf *types.Leaf, treeSize uint64) (AddLeafStatus, error) {
+	blob := leaf.ToBinary()
+	h := merkle.HashLeafNode(blob)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AddLeafStatus{}, err
+	}
+	defer tx.Rollback()
+
+	index, err := getLeafIndex(ctx, tx, &h)
+	if err == nil {
+		return AddLeafStatus{
+			AlreadyExists: true,
+			IsSequenced:   index < treeSize,
+		}, nil
+	}
+	if err != ErrNotIncluded {
+		return AddLeafStatus{}, err
+	}
+	size, err := getTreeSize(ctx, tx)
+	if err != nil {
+		return AddLeafStatus{}, err
+	}
+	if err := appendLeaf(ctx, tx, size, blob, &h); err != nil {
+		return AddLeafStatus{}, err
+	}
+	return AddLeafStatus{}, tx.Commit()
+}
+
+// AddLeaves adds all leaves in a single transaction.
+func (c *SqlClient) AddLeaves(ctx context.Context, leaves []types.Leaf, treeSize uint64) ([]AddLeafStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	size, err := getTreeSize(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]AddLeafStatus, len(leaves))
+	for i, leaf := range leaves {
+		blob := leaf.ToBinary()
+		h := merkle.HashLeafNode(blob)
+		index, err := getLeafIndex(ctx, tx, &h)
+		if err == nil {
+			res[i] = AddLeafStatus{AlreadyExists: true, IsSequenced: index < treeSize}
+			continue
+		}
+		if err != ErrNotIncluded {
+			return nil, err
+		}
+		if err := appendLeaf(ctx, tx, size, blob, &h); err != nil {
+			return nil, err
+		}
+		size++
+	}
+	return res, tx.Commit()
+}
+
+func (c *SqlClient) AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	size, err := getTreeSize(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if size != uint64(index) {
+		return fmt.Errorf("incorrect index %d, tree size %d", index, size)
+	}
+	for i, leaf := range leaves {
+		blob := leaf.ToBinary()
+		h := merkle.HashLeafNode(blob)
+		if err := appendLeaf(ctx, tx, size+uint64(i), blob, &h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *SqlClient) GetTreeHead(ctx context.Context) (types.TreeHead, error) {
+	size, err := getTreeSize(ctx, c.db)
+	if err != nil {
+		return types.TreeHead{}, err
+	}
+	rootHash, err := rangeHash(ctx, c.db, 0, size)
+	if err != nil {
+		return types.TreeHead{}, err
+	}
+	return types.TreeHead{Size: size, RootHash: rootHash}, nil
+}
+
+func (c *SqlClient) GetConsistencyProof(ctx context.Context, req *requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	if req.OldSize > req.NewSize {
+		return types.ConsistencyProof{}, fmt.Errorf("invalid consistency request, old size %d > new size %d",
+			req.OldSize, req.NewSize)
+	}
+	if err := checkTreeSize(ctx, c.db, req.NewSize); err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	// Trivial cases with empty proof.
+	if req.OldSize == 0 || req.OldSize == req.NewSize {
+		return types.ConsistencyProof{}, nil
+	}
+	path, err := consistencyPath(ctx, c.db, req.OldSize, 0, req.NewSize, true)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	return types.ConsistencyProof{Path: path}, nil
+}
+
+func (c *SqlClient) GetInclusionProof(ctx context.Context, req *requests.InclusionProof) (types.InclusionProof, error) {
+	index, err := getLeafIndex(ctx, c.db, &req.LeafHash)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	if index >= req.Size {
+		return types.InclusionProof{}, ErrNotIncluded
+	}
+	if err := checkTreeSize(ctx, c.db, req.Size); err != nil {
+		return types.InclusionProof{}, err
+	}
+	path, err := inclusionPath(ctx, c.db, index, 0, req.Size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	return types.InclusionProof{
+		LeafIndex: index,
+		Path:      path,
+	}, nil
+}
+
+func (c *SqlClient) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	size, err := getTreeSize(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+	if req.StartIndex >= size || req.EndIndex > size || req.StartIndex >= req.EndIndex {
+		return nil, fmt.Errorf("out of range request: start %d, end %d, size %d",
+			req.StartIndex, req.EndIndex, size)
+	}
+	rows, err := c.db.QueryContext(ctx,
+		"SELECT leaf_index, leaf_data FROM leaves WHERE leaf_index >= $1 AND leaf_index < $2 ORDER BY leaf_index",
+		req.StartIndex, req.EndIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]types.Leaf, 0, req.EndIndex-req.StartIndex)
+	for rows.Next() {
+		var index uint64
+		var blob []byte
+		if err := rows.Scan(&index, &blob); err != nil {
+			return nil, err
+		}
+		if want := req.StartIndex + uint64(len(list)); index != want {
+			return nil, fmt.Errorf("unexpected leaf(%d): got index %d", want, index)
+		}
+		var leaf types.Leaf
+		if err := leaf.FromBinary(blob); err != nil {
+			return nil, fmt.Errorf("unexpected leaf(%d): %v", index, err)
+		}
+		list = append(list, leaf)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if got, want := len(list), int(req.EndIndex-req.StartIndex); got != want {
+		return nil, fmt.Errorf("unexpected number of leaves: %d", got)
+	}
+	return list, nil
+}
+
+func getTreeSize(ctx context.Context, q sqlQuerier) (uint64, error) {
+	var size uint64
+	if err := q.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(leaf_index) + 1, 0) FROM leaves").Scan(&size); err != nil {
+		return 0, fmt.Errorf("reading tree size failed: %v", err)
+	}
+	return size, nil
+}
+
+func checkTreeSize(ctx context.Context, q sqlQuerier, treeSize uint64) error {
+	size, err := getTreeSize(ctx, q)
+	if err != nil {
+		return err
+	}
+	if treeSize > size {
+		return fmt.Errorf("requested tree size %d exceeds current size %d", treeSize, size)
+	}
+	return nil
+}
+
+func getLeafIndex(ctx context.Context, q sqlQuerier, leafHash *crypto.Hash) (uint64, error) {
+	var index uint64
+	err := q.QueryRowContext(ctx,
+		"SELECT leaf_index FROM leaves WHERE leaf_hash = $1", leafHash[:]).Scan(&index)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotIncluded
+	}
+	return index, err
+}
+
+// Returns the hash of the complete subtree at the given level and index.
+func getNode(ctx context.Context, q sqlQuerier, level int, index uint64) (crypto.Hash, error) {
+	var blob []byte
+	var err error
+	if level == 0 {
+		err = q.QueryRowContext(ctx,
+			"SELECT leaf_hash FROM leaves WHERE leaf_index = $1", index).Scan(&blob)
+	} else {
+		err = q.QueryRowContext(ctx,
+			"SELECT hash FROM nodes WHERE level = $1 AND node_index = $2", level, index).Scan(&blob)
+	}
+	if err != nil {
+		return crypto.Hash{}, fmt.Errorf("reading node (%d, %d) failed: %v", level, index, err)
+	}
+	var h crypto.Hash
+	if len(blob) != len(h) {
+		return crypto.Hash{}, fmt.Errorf("unexpected hash length %d for node (%d, %d)", len(blob), level, index)
+	}
+	copy(h[:], blob)
+	return h, nil
+}
+
+// Adds a leaf at the given index, which must equal the current tree
+// size, and the hashes of all subtrees completed by that leaf.
+func appendLeaf(ctx context.Context, q sqlQuerier, index uint64, blob []byte, leafHash *crypto.Hash) error {
+	if _, err := q.ExecContext(ctx,
+		"INSERT INTO leaves (leaf_index, leaf_hash, leaf_data) VALUES ($1, $2, $3)",
+		index, leafHash[:], blob); err != nil {
+		return fmt.Errorf("adding leaf at index %d failed: %v", index, err)
+	}
+	h := *leafHash
+	for level, i := 0, index; i&1 == 1; level, i = level+1, i>>1 {
+		left, err := getNode(ctx, q, level, i-1)
+		if err != nil {
+			return err
+		}
+		h = merkle.HashInteriorNode(&left, &h)
+		if _, err := q.ExecContext(ctx,
+			"INSERT INTO nodes (level, node_index, hash) VALUES ($1, $2, $3)",
+			level+1, i>>1, h[:]); err != nil {
+			return fmt.Errorf("adding node (%d, %d) failed: %v", level+1, i>>1, err)
+		}
+	}
+	return nil
+}
+
+// Returns the largest power of two smaller than n, for n > 1.
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// Returns the hash of the leaves [start, end). The start index must
+// be aligned so that the range is a right-most subtree of the tree
+// of size end, as is the case for all ranges arising in RFC 6962
+// proofs.
+func rangeHash(ctx context.Context, q sqlQuerier, start, end uint64) (crypto.Hash, error) {
+	n := end - start
+	if n == 0 {
+		return merkle.HashEmptyTree(), nil
+	}
+	if n&(n-1) == 0 {
+		level := bits.TrailingZeros64(n)
+		return getNode(ctx, q, level, start>>level)
+	}
+	k := splitPoint(n)
+	left, err := rangeHash(ctx, q, start, start+k)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	right, err := rangeHash(ctx, q, start+k, end)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	return merkle.HashInteriorNode(&left, &right), nil
+}
+
+// Inclusion path for leaf index in the subtree [start, end), as
+// defined in RFC 6962, section 2.1.1.
+func inclusionPath(ctx context.Context, q sqlQuerier, index, start, end uint64) ([]crypto.Hash, error) {
+	if end-start <= 1 {
+		return nil, nil
+	}
+	k := splitPoint(end - start)
+	var path []crypto.Hash
+	var sibling crypto.Hash
+	var err error
+	if index < start+k {
+		if path, err = inclusionPath(ctx, q, index, start, start+k); err != nil {
+			return nil, err
+		}
+		sibling, err = rangeHash(ctx, q, start+k, end)
+	} else {
+		if path, err = inclusionPath(ctx, q, index, start+k, end); err != nil {
+			return nil, err
+		}
+		sibling, err = rangeHash(ctx, q, start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// Consistency subproof for old size m, in the subtree [start, end),
+// as defined in RFC 6962, section 2.1.2.
+func consistencyPath(ctx context.Context, q sqlQuerier, m, start, end uint64, complete bool) ([]crypto.Hash, error) {
+	if m == end {
+		if complete {
+			return nil, nil
+		}
+		h, err := rangeHash(ctx, q, start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []crypto.Hash{h}, nil
+	}
+	k := splitPoint(end - start)
+	var path []crypto.Hash
+	var sibling crypto.Hash
+	var err error
+	if m <= start+k {
+		if path, err = consistencyPath(ctx, q, m, start, start+k, complete); err != nil {
+			return nil, err
+		}
+		sibling, err = rangeHash(ctx, q, start+k, end)
+	} else {
+		if path, err = consistencyPath(ctx, q, m, start+k, end, false); err != nil {
+			return nil, err
+		}
+		sibling, err = rangeHash(ctx, q, start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
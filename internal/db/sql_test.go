@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSqlDb(t *testing.T, file string) *SqlClient {
+	t.Helper()
+	db, err := OpenSqlDb(SqliteDriver, file)
+	if err != nil {
+		t.Fatalf("OpenSqlDb failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestSqlDbTemp(t *testing.T) *SqlClient {
+	t.Helper()
+	return newTestSqlDb(t, filepath.Join(t.TempDir(), "sigsum-test.db"))
+}
+
+func TestSqlAddLeaf(t *testing.T) {
+	testClientAddLeaf(t, newTestSqlDbTemp(t))
+}
+
+func TestSqlAddLeaves(t *testing.T) {
+	testClientAddLeaves(t, newTestSqlDbTemp(t))
+}
+
+func TestSqlAddSequencedLeaves(t *testing.T) {
+	testClientAddSequencedLeaves(t, newTestSqlDbTemp(t))
+}
+
+func TestSqlGetLeaves(t *testing.T) {
+	testClientGetLeaves(t, newTestSqlDbTemp(t))
+}
+
+func TestSqlInclusionProof(t *testing.T) {
+	testClientInclusionProof(t, newTestSqlDbTemp(t))
+}
+
+func TestSqlConsistencyProof(t *testing.T) {
+	testClientConsistencyProof(t, newTestSqlDbTemp(t))
+}
+
+func TestSqlReopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sigsum-test.db")
+	leaves := newLeaves(11)
+	ctx := context.Background()
+
+	db := newTestSqlDb(t, file)
+	if err := db.AddSequencedLeaves(ctx, leaves[:7], 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	want, err := db.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	db.Close()
+
+	db = newTestSqlDb(t, file)
+	got, err := db.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead after reopen failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("unexpected tree head after reopen, got %v, wanted %v", got, want)
+	}
+	// Leaves must be appended at the end of the persisted tree.
+	if err := db.AddSequencedLeaves(ctx, leaves[7:], 7); err != nil {
+		t.Fatalf("AddSequencedLeaves after reopen failed: %v", err)
+	}
+	mem := NewMemoryDb()
+	if err := mem.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatalf("AddSequencedLeaves to memory db failed: %v", err)
+	}
+	if got, err := db.GetTreeHead(ctx); err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	} else if want, _ := mem.GetTreeHead(ctx); got != want {
+		t.Errorf("unexpected tree head, got %v, wanted %v", got, want)
+	}
+}
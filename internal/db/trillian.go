@@ -2,9 +2,9 @@ package db
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/google/trillian"
@@ -35,13 +35,10 @@ type TreeType int
 const (
 	PrimaryTree TreeType = iota
 	SecondaryTree
+	// Accepts either type, for tools that only read the tree.
+	AnyTree
 )
 
-// This is an error if it happens for a get-inclusion-proof request
-// from a log client, but we must accept it when we internally ask for
-// an inclusion proof for the very first leaf.
-var errEmptyInclusionProof = errors.New("not an inclusion proof: empty")
-
 func (treeType TreeType) checkTrillianTreeType(trillianType trillian.TreeType) error {
 	switch treeType {
 	case PrimaryTree:
@@ -54,6 +51,11 @@ func (treeType TreeType) checkTrillianTreeType(trillianType trillian.TreeType) e
 			return fmt.Errorf("trillian tree of type %s, but must be of type PREORDERED_LOG for a Sigsum secondary",
 				trillianType.String())
 		}
+	case AnyTree:
+		if trillianType != trillian.TreeType_LOG && trillianType != trillian.TreeType_PREORDERED_LOG {
+			return fmt.Errorf("trillian tree of type %s, but must be of type LOG or PREORDERED_LOG",
+				trillianType.String())
+		}
 	default:
 		panic(fmt.Sprintf("internal error, invalid tree type %d", treeType))
 	}
@@ -118,17 +120,40 @@ func (c *TrillianClient) AddLeaf(ctx context.Context, leaf *types.Leaf, treeSize
 		return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: true}, nil
 	case ErrNotIncluded:
 		return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: false}, nil
-	case errEmptyInclusionProof:
-		if treeSize == 1 {
-			// An empty proof is expected, and means that the leaf is present.
-			return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: true}, nil
-		}
-		fallthrough
 	default:
 		return AddLeafStatus{}, fmt.Errorf("back-end rpc failure: %v", err)
 	}
 }
 
+// Maximum number of concurrent QueueLeaf requests per AddLeaves call.
+const maxQueueLeafConcurrency = 16
+
+// AddLeaves adds a batch of leaves. Trillian's gRPC api no longer
+// has a QueueLeaves method, so each leaf is queued separately, with
+// up to maxQueueLeafConcurrency requests in flight.
+func (c *TrillianClient) AddLeaves(ctx context.Context, leaves []types.Leaf, treeSize uint64) ([]AddLeafStatus, error) {
+	res := make([]AddLeafStatus, len(leaves))
+	errs := make([]error, len(leaves))
+	sem := make(chan struct{}, maxQueueLeafConcurrency)
+	var wg sync.WaitGroup
+	for i := range leaves {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res[i], errs[i] = c.AddLeaf(ctx, &leaves[i], treeSize)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
 // AddSequencedLeaves adds a set of already sequenced leaves to the tree.
 func (c *TrillianClient) AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error {
 	trilLeaves := make([]*trillian.LogLeaf, len(leaves))
@@ -240,7 +265,12 @@ func (c *TrillianClient) GetInclusionProof(ctx context.Context, req *requests.In
 	}
 	proof := rsp.Proof[0]
 	if len(proof.Hashes) == 0 {
-		return types.InclusionProof{}, errEmptyInclusionProof
+		// The inclusion proof for the only leaf of a tree of
+		// size 1 is empty, but in any larger tree, it's not.
+		if req.Size != 1 {
+			return types.InclusionProof{}, fmt.Errorf("not an inclusion proof: empty")
+		}
+		return types.InclusionProof{LeafIndex: 0}, nil
 	}
 	path, err := nodePathFromHashes(proof.Hashes)
 	if err != nil {
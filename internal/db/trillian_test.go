@@ -89,6 +89,36 @@ func TestAddLeaf(t *testing.T) {
 	}
 }
 
+func TestAddLeaves(t *testing.T) {
+	leaves := newLeaves(3)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	grpc := mocksTrillian.NewMockTrillianLogClient(ctrl)
+	grpc.EXPECT().QueueLeaf(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req *trillian.QueueLeafRequest, _ ...interface{}) (*trillian.QueueLeafResponse, error) {
+			if bytes.Equal(req.Leaf.LeafValue, leaves[1].ToBinary()) {
+				return nil, status.Error(codes.AlreadyExists, "duplicate")
+			}
+			return &trillian.QueueLeafResponse{}, nil
+		}).Times(3)
+	client := TrillianClient{logClient: grpc}
+
+	// Tree size 0, so no inclusion proofs requested.
+	res, err := client.AddLeaves(context.Background(), leaves, 0)
+	if err != nil {
+		t.Fatalf("AddLeaves failed: %v", err)
+	}
+	want := []AddLeafStatus{{}, {AlreadyExists: true}, {}}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got status %#v, wanted %#v", res, want)
+	}
+
+	grpc.EXPECT().QueueLeaf(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("something went wrong")).Times(3)
+	if _, err := client.AddLeaves(context.Background(), leaves, 0); err == nil {
+		t.Errorf("AddLeaves succeeded despite backend failure")
+	}
+}
+
 func TestGetTreeHead(t *testing.T) {
 	// valid root
 	root := &ttypes.LogRootV1{
@@ -340,6 +370,18 @@ func TestGetInclusionProof(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			description: "valid: tree of size 1",
+			req:         &requests.InclusionProof{Size: 1},
+			rsp: &trillian.GetInclusionProofByHashResponse{
+				Proof: []*trillian.Proof{
+					&trillian.Proof{
+						Hashes: [][]byte{},
+					},
+				},
+			},
+			wantProof: types.InclusionProof{LeafIndex: 0},
+		},
 		{
 			description: "valid",
 			req:         req,
@@ -0,0 +1,203 @@
+// Package freshness implements the secondary's internal
+// get-fresh-secondary-tree-head endpoint. Unlike
+// get-secondary-tree-head, the response is bound to a nonce chosen by
+// the primary for each request, so that the primary can reject
+// replayed responses, e.g., if the secondary has lost data after a
+// restore from backup.
+//
+// A request is a GET of
+// <prefix>/get-fresh-secondary-tree-head/<hex nonce>, where the nonce
+// is 32 random bytes. The response is, in sigsum's ascii format, the
+// secondary's signed tree head, as for get-secondary-tree-head,
+// followed by a line nonce_signature=..., the secondary's signature
+// binding the tree head to the nonce, see TreeHead.
+package freshness
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Endpoint name, relative to the secondary's internal url prefix.
+	Endpoint = "get-fresh-secondary-tree-head"
+	// Name of the older endpoint, without nonce.
+	LegacyEndpoint = "get-secondary-tree-head"
+
+	signatureNamespace = "sigsum.org/v1/fresh-secondary-tree-head"
+)
+
+// TreeHead is the secondary's response.
+type TreeHead struct {
+	types.SignedTreeHead
+	// Signature on the tree head and the nonce, see signedData.
+	NonceSignature crypto.Signature
+}
+
+// NewNonce returns a random nonce.
+func NewNonce() (crypto.Hash, error) {
+	var nonce crypto.Hash
+	_, err := rand.Read(nonce[:])
+	return nonce, err
+}
+
+func signedData(th *types.TreeHead, nonce *crypto.Hash) []byte {
+	return []byte(fmt.Sprintf("%s\n%x\n%d\n%x\n", signatureNamespace, *nonce, th.Size, th.RootHash))
+}
+
+// Sign returns the signed tree head, bound to the nonce.
+func Sign(signer crypto.Signer, th *types.TreeHead, nonce *crypto.Hash) (TreeHead, error) {
+	sth, err := th.Sign(signer)
+	if err != nil {
+		return TreeHead{}, err
+	}
+	sig, err := signer.Sign(signedData(th, nonce))
+	if err != nil {
+		return TreeHead{}, err
+	}
+	return TreeHead{SignedTreeHead: sth, NonceSignature: sig}, nil
+}
+
+// Verify checks the secondary's signatures, and that the response is
+// bound to the nonce.
+func (th *TreeHead) Verify(pub *crypto.PublicKey, nonce *crypto.Hash) error {
+	if !th.SignedTreeHead.Verify(pub) {
+		return fmt.Errorf("invalid signature on secondary's tree head")
+	}
+	if !crypto.Verify(pub, signedData(&th.TreeHead, nonce), &th.NonceSignature) {
+		return fmt.Errorf("invalid nonce signature on secondary's tree head")
+	}
+	return nil
+}
+
+func (th *TreeHead) ToASCII(w io.Writer) error {
+	if err := th.SignedTreeHead.ToASCII(w); err != nil {
+		return err
+	}
+	return ascii.WriteSignature(w, "nonce_signature", &th.NonceSignature)
+}
+
+func (th *TreeHead) FromASCII(r io.Reader) (err error) {
+	p := ascii.NewParser(r)
+	if th.Size, err = p.GetInt("size"); err != nil {
+		return err
+	}
+	if th.RootHash, err = p.GetHash("root_hash"); err != nil {
+		return err
+	}
+	if th.Signature, err = p.GetSignature("signature"); err != nil {
+		return err
+	}
+	if th.NonceSignature, err = p.GetSignature("nonce_signature"); err != nil {
+		return err
+	}
+	return p.GetEOF()
+}
+
+// Client fetches tree heads from a secondary's internal endpoints.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the secondary with the given base
+// url. If httpClient is nil, a default client is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{
+		url:        strings.TrimSuffix(url, "/") + "/",
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, parse func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Sigsum log-go server")
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, rsp.Body)
+		return api.NewError(rsp.StatusCode, fmt.Errorf("%s failed: %s", endpoint, rsp.Status))
+	}
+	if err := parse(rsp.Body); err != nil {
+		return fmt.Errorf("invalid %s response: %v", endpoint, err)
+	}
+	return nil
+}
+
+// GetFreshSecondaryTreeHead returns the secondary's response, without
+// verifying any signatures.
+func (c *Client) GetFreshSecondaryTreeHead(ctx context.Context, nonce *crypto.Hash) (TreeHead, error) {
+	var th TreeHead
+	err := c.get(ctx, fmt.Sprintf("%s/%x", Endpoint, *nonce), th.FromASCII)
+	return th, err
+}
+
+// GetSecondaryTreeHead uses the legacy endpoint, for secondaries not
+// yet supporting nonces.
+func (c *Client) GetSecondaryTreeHead(ctx context.Context) (types.SignedTreeHead, error) {
+	var sth types.SignedTreeHead
+	err := c.get(ctx, LegacyEndpoint, sth.FromASCII)
+	return sth, err
+}
+
+// Returns the nonce in the last path element, after the endpoint name.
+func parseNoncePath(p string) (crypto.Hash, error) {
+	i := strings.LastIndex(p, "/"+Endpoint+"/")
+	if i < 0 {
+		return crypto.Hash{}, fmt.Errorf("invalid path %q", p)
+	}
+	return crypto.HashFromHex(p[i+len(Endpoint)+2:])
+}
+
+// NewHandler returns a handler for the endpoint, with responses
+// produced by getTreeHead.
+func NewHandler(timeout time.Duration, getTreeHead func(context.Context, *crypto.Hash) (TreeHead, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		nonce, err := parseNoncePath(r.URL.Path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		th, err := getTreeHead(ctx, &nonce)
+		if err != nil {
+			log.Debug("%s failed: %v", Endpoint, err)
+			http.Error(w, err.Error(), api.ErrorStatusCode(err))
+			return
+		}
+		var buf bytes.Buffer
+		if err := th.ToASCII(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,78 @@
+package freshness
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestClientAndHandler(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	th := types.TreeHead{Size: 17, RootHash: crypto.Hash{1}}
+	var handlerErr error
+
+	mux := http.NewServeMux()
+	mux.Handle("/test/"+Endpoint+"/", NewHandler(time.Minute, func(_ context.Context, nonce *crypto.Hash) (TreeHead, error) {
+		if handlerErr != nil {
+			return TreeHead{}, handlerErr
+		}
+		return Sign(signer, &th, nonce)
+	}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := NewClient(server.URL+"/test/", nil)
+
+	nonce, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := client.GetFreshSecondaryTreeHead(context.Background(), &nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.TreeHead != th {
+		t.Errorf("unexpected tree head: %v", fresh.TreeHead)
+	}
+	if err := fresh.Verify(&pub, &nonce); err != nil {
+		t.Errorf("verification failed: %v", err)
+	}
+	if err := fresh.Verify(&otherPub, &nonce); err == nil {
+		t.Errorf("verification with wrong key succeeded")
+	}
+	// A response to a different request is rejected.
+	otherNonce, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.Verify(&pub, &otherNonce); err == nil {
+		t.Errorf("verification with wrong nonce succeeded")
+	}
+
+	handlerErr = api.NewError(http.StatusInternalServerError, errors.New("refusing to sign"))
+	if _, err := client.GetFreshSecondaryTreeHead(context.Background(), &nonce); err == nil {
+		t.Errorf("expected error from failing handler")
+	} else if got := api.ErrorStatusCode(err); got != http.StatusInternalServerError {
+		t.Errorf("unexpected status code %d", got)
+	}
+
+	// A secondary without the endpoint.
+	if _, err := NewClient(server.URL+"/other/", nil).GetFreshSecondaryTreeHead(context.Background(), &nonce); err == nil {
+		t.Errorf("expected error for missing endpoint")
+	} else if got := api.ErrorStatusCode(err); got != http.StatusNotFound {
+		t.Errorf("unexpected status code %d for missing endpoint", got)
+	}
+}
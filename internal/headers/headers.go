@@ -0,0 +1,102 @@
+// Package headers passes HTTP headers between the handlers for the
+// log's public endpoints and the Primary methods they call. The
+// handlers from sigsum-go pass only the parsed request and the
+// request's context to the Primary, so optional request headers,
+// e.g., for long polling, and extra response headers, e.g., for
+// receipts, are passed via the context.
+package headers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type key struct{}
+
+// Headers of a request being handled.
+type exchange struct {
+	request http.Header
+
+	mu       sync.Mutex
+	response http.Header
+}
+
+// Get returns the value of a request header. Returns "" if the
+// header is missing, or if the context doesn't come from a request
+// handled by NewHandler.
+func Get(ctx context.Context, name string) string {
+	x, ok := ctx.Value(key{}).(*exchange)
+	if !ok {
+		return ""
+	}
+	return x.request.Get(name)
+}
+
+// Set sets a response header. Does nothing if the context doesn't
+// come from a request handled by NewHandler.
+func Set(ctx context.Context, name, value string) {
+	x, ok := ctx.Value(key{}).(*exchange)
+	if !ok {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.response.Set(name, value)
+}
+
+func (x *exchange) copyTo(h http.Header) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for k, v := range x.response {
+		h[k] = v
+	}
+}
+
+// NewHandler wraps the log's public handler, making request headers
+// available to Get, and adding headers set by Set to the response.
+func NewHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x := exchange{request: r.Header, response: make(http.Header)}
+		writer := NewWriter(w, func(h http.Header, _ int) { x.copyTo(h) })
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), key{}, &x)))
+		writer.Finish()
+	})
+}
+
+// Writer wraps a response writer, calling a function with the
+// response headers and status code just before the headers are
+// written. The response body is passed through unbuffered.
+type Writer struct {
+	http.ResponseWriter
+	beforeHeader func(http.Header, int)
+	wroteHeader  bool
+}
+
+func NewWriter(w http.ResponseWriter, beforeHeader func(http.Header, int)) *Writer {
+	return &Writer{ResponseWriter: w, beforeHeader: beforeHeader}
+}
+
+func (w *Writer) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.beforeHeader(w.Header(), status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Finish must be called when the wrapped handler returns. It writes
+// the headers of an empty 200 (OK) response, if the handler wrote
+// nothing.
+func (w *Writer) Finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+}
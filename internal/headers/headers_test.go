@@ -0,0 +1,47 @@
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := Get(r.Context(), "X-Request"); len(v) > 0 {
+			Set(r.Context(), "X-Response", v)
+		}
+		switch r.URL.Path {
+		case "/implicit":
+			fmt.Fprintf(w, "ok\n")
+		case "/empty":
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	for _, table := range []struct {
+		desc     string
+		path     string
+		header   string
+		wantCode int
+	}{
+		{"no header", "/", "", http.StatusAccepted},
+		{"header", "/", "foo", http.StatusAccepted},
+		{"implicit status", "/implicit", "bar", http.StatusOK},
+		{"empty response", "/empty", "baz", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, table.path, nil)
+		if len(table.header) > 0 {
+			req.Header.Set("X-Request", table.header)
+		}
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, req)
+		if rsp.Code != table.wantCode {
+			t.Errorf("%s: unexpected status %d, wanted %d", table.desc, rsp.Code, table.wantCode)
+		}
+		if got := rsp.Header().Get("X-Response"); got != table.header {
+			t.Errorf("%s: unexpected response header %q, wanted %q", table.desc, got, table.header)
+		}
+	}
+}
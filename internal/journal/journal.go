@@ -0,0 +1,141 @@
+// Package journal implements append-only files of text records, one
+// record per line, for state that changes with each request, where
+// rewriting the whole file on each change would be too expensive.
+// Each change is appended as a record, and the file is occasionally
+// compacted by replacing it with the records of the current state,
+// see MaybeCompact. A partially written last line, e.g., after a
+// crash, is discarded on startup.
+package journal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// MaybeCompact compacts the file when it has this many records more
+// than twice the number of records needed for the current state.
+const CompactSlack = 1000
+
+// Journal is an append-only file of records.
+type Journal struct {
+	file    string
+	records int // Number of records in the file
+}
+
+// Open opens the journal in file, and returns its records. A missing
+// file is treated as an empty journal.
+func Open(file string) (*Journal, []string, error) {
+	j := Journal{file: file}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &j, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i < len(data)-1 {
+		log.Warning("discarding partial last line of journal %q", file)
+		data = data[:i+1]
+		if err := os.Truncate(file, int64(len(data))); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(data) == 0 {
+		return &j, nil, nil
+	}
+	records := strings.Split(string(data[:len(data)-1]), "\n")
+	j.records = len(records)
+	return &j, records, nil
+}
+
+// Records returns the number of records in the file, for deciding
+// when to compact it.
+func (j *Journal) Records() int {
+	return j.records
+}
+
+// Append appends records to the file, and syncs it to disk. Records
+// must not contain newlines. On failure, the file is truncated to its
+// previous size, so that the records can be appended again without
+// duplicating those that were written.
+func (j *Journal) Append(records ...string) error {
+	if err := os.MkdirAll(filepath.Dir(j.file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := appendAndSync(f, join(records)); err != nil {
+		if terr := f.Truncate(size); terr != nil {
+			log.Error("truncating journal %q after failed append failed: %v", j.file, terr)
+		}
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	j.records += len(records)
+	return nil
+}
+
+func appendAndSync(f *os.File, data string) error {
+	if _, err := io.WriteString(f, data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Compact atomically replaces the file with the given records.
+func (j *Journal) Compact(records []string) error {
+	if err := os.MkdirAll(filepath.Dir(j.file), 0755); err != nil {
+		return err
+	}
+	f, err := safefile.Create(j.file, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.WriteString(f, join(records)); err != nil {
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	j.records = len(records)
+	return nil
+}
+
+// MaybeCompact compacts the file if most of its records are
+// obsolete, i.e., if it has many more records than the live records
+// describing the current state. The records function is called only
+// when compacting, and must return those live records.
+func (j *Journal) MaybeCompact(live int, records func() []string) error {
+	if j.records <= 2*live+CompactSlack {
+		return nil
+	}
+	return j.Compact(records())
+}
+
+func join(records []string) string {
+	var b strings.Builder
+	for _, r := range records {
+		b.WriteString(r)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
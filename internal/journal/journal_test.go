@@ -0,0 +1,81 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dir", "journal")
+	j, records, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 || j.Records() != 0 {
+		t.Fatalf("unexpected records in missing file: %q", records)
+	}
+	if err := j.Append("a 1", "b 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append("c 3"); err != nil {
+		t.Fatal(err)
+	}
+	if j.Records() != 3 {
+		t.Errorf("unexpected number of records %d", j.Records())
+	}
+
+	// Simulate a crash while appending.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("d")
+	f.Close()
+
+	j, records, err = Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a 1", "b 2", "c 3"}; !reflect.DeepEqual(records, want) || j.Records() != 3 {
+		t.Errorf("unexpected records %q, wanted %q", records, want)
+	}
+	if err := j.Compact([]string{"c 3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append("e 5"); err != nil {
+		t.Fatal(err)
+	}
+	if j, records, err = Open(file); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c 3", "e 5"}; !reflect.DeepEqual(records, want) || j.Records() != 2 {
+		t.Errorf("unexpected records %q after compaction, wanted %q", records, want)
+	}
+}
+
+func TestMaybeCompact(t *testing.T) {
+	j, _, err := Open(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < CompactSlack+3; i++ {
+		if err := j.Append("x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live := func() []string { return []string{"x"} }
+	if err := j.MaybeCompact(2, live); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := j.Records(), CompactSlack+3; got != want {
+		t.Errorf("compacted too early, got %d records, want %d", got, want)
+	}
+	if err := j.MaybeCompact(1, live); err != nil {
+		t.Fatal(err)
+	}
+	if got := j.Records(); got != 1 {
+		t.Errorf("not compacted, %d records", got)
+	}
+}
@@ -0,0 +1,142 @@
+// Package longpoll implements optional long polling for the
+// get-tree-head and add-leaf endpoints, so that clients waiting for
+// their leaf to be published need not poll in a loop.
+//
+// A get-tree-head request with the header "Sigsum-Wait-Size: N" is
+// delayed until the published tree is larger than N. An add-leaf
+// request with the header "Sigsum-Wait-Sequenced: true" that would
+// get a 202 (Accepted) response is delayed until the leaf is included
+// in the published tree, and then gets a 200 (OK) response; the
+// primary's AddLeaf does the waiting, using a Waiter. In both cases,
+// the wait is limited by a server-side timeout, after which the
+// response is the same as without the header. If too many requests
+// are waiting already, new requests are answered immediately.
+package longpoll
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	WaitSizeHeader      = "Sigsum-Wait-Size"
+	WaitSequencedHeader = "Sigsum-Wait-Sequenced"
+)
+
+// Waiter implements state.Publisher, and lets requests wait for the
+// next time a new tree head is published.
+type Waiter struct {
+	maxWait time.Duration
+	waiters chan struct{} // Semaphore limiting concurrent waiters
+
+	mu        sync.Mutex
+	published chan struct{}
+}
+
+func NewWaiter(maxWait time.Duration, maxWaiters int) *Waiter {
+	return &Waiter{
+		maxWait:   maxWait,
+		waiters:   make(chan struct{}, maxWaiters),
+		published: make(chan struct{}),
+	}
+}
+
+func (w *Waiter) Publish(_ context.Context, _ *types.CosignedTreeHead) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.published)
+	w.published = make(chan struct{})
+	return nil
+}
+
+// Returns a channel that is closed when the next tree head is
+// published.
+func (w *Waiter) next() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.published
+}
+
+// Wait calls done each time a tree head is published, until it
+// returns true, the maximum wait time has passed, or the context is
+// done. Returns true if done returned true. If too many requests are
+// waiting already, returns false immediately, without calling done.
+func (w *Waiter) Wait(ctx context.Context, done func(context.Context) bool) bool {
+	select {
+	case w.waiters <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-w.waiters }()
+
+	ctx, cancel := context.WithTimeout(ctx, w.maxWait)
+	defer cancel()
+	for {
+		// Must get the channel before checking, to not miss
+		// a publication in between.
+		published := w.next()
+		if done(ctx) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-published:
+		}
+	}
+}
+
+// CheckWaitSequenced checks the value of the Sigsum-Wait-Sequenced
+// header, and returns true if it asks for waiting.
+func CheckWaitSequenced(value string) (bool, error) {
+	switch value {
+	case "":
+		return false, nil
+	case "true":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid %s header %q, must be \"true\"", WaitSequencedHeader, value)
+}
+
+// Handler wraps the log's public handler, adding long polling for
+// get-tree-head.
+type Handler struct {
+	getTreeHeadPath string
+	next            http.Handler
+	log             api.Log // For checking the published tree
+	waiter          *Waiter
+}
+
+func NewHandler(prefix string, log api.Log, waiter *Waiter, next http.Handler) *Handler {
+	return &Handler{
+		getTreeHeadPath: "/" + path.Join(prefix, "get-tree-head"),
+		next:            next,
+		log:             log,
+		waiter:          waiter,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.getTreeHeadPath || len(r.Header.Get(WaitSizeHeader)) == 0 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	size, err := strconv.ParseUint(r.Header.Get(WaitSizeHeader), 10, 63)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid %s header: %v", WaitSizeHeader, err), http.StatusBadRequest)
+		return
+	}
+	h.waiter.Wait(r.Context(), func(ctx context.Context) bool {
+		cth, err := h.log.GetTreeHead(ctx)
+		return err == nil && cth.Size > size
+	})
+	h.next.ServeHTTP(w, r)
+}
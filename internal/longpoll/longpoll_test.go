@@ -0,0 +1,136 @@
+package longpoll
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Published tree, identified by its size.
+type testLog struct {
+	api.Log
+	mu   sync.Mutex
+	size uint64
+}
+
+func (l *testLog) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var cth types.CosignedTreeHead
+	cth.Size = l.size
+	return cth, nil
+}
+
+func (l *testLog) publish(w *Waiter, size uint64) {
+	l.mu.Lock()
+	l.size = size
+	l.mu.Unlock()
+	w.Publish(context.Background(), nil)
+}
+
+// Answers get-tree-head with the size.
+func (l *testLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cth, _ := l.GetTreeHead(r.Context())
+	fmt.Fprintf(w, "size=%d\n", cth.Size)
+}
+
+func TestGetTreeHead(t *testing.T) {
+	for _, table := range []struct {
+		desc       string
+		maxWaiters int
+		waitSize   string
+		publish    bool
+		wantCode   int
+		wantBody   string
+	}{
+		{"no header", 1, "", true, http.StatusOK, "size=2\n"},
+		{"already larger", 1, "1", true, http.StatusOK, "size=2\n"},
+		{"published", 1, "2", true, http.StatusOK, "size=3\n"},
+		{"timeout", 1, "2", false, http.StatusOK, "size=2\n"},
+		{"too many waiters", 0, "2", true, http.StatusOK, "size=2\n"},
+		{"invalid header", 1, "x", true, http.StatusBadRequest, ""},
+	} {
+		l := &testLog{size: 2}
+		waiter := NewWaiter(200*time.Millisecond, table.maxWaiters)
+		h := NewHandler("", l, waiter, l)
+		if table.publish {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				l.publish(waiter, 3)
+			}()
+		}
+		req := httptest.NewRequest(http.MethodGet, "/get-tree-head", nil)
+		if len(table.waitSize) > 0 {
+			req.Header.Set(WaitSizeHeader, table.waitSize)
+		}
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, req)
+		if rsp.Code != table.wantCode {
+			t.Errorf("%s: unexpected status %d", table.desc, rsp.Code)
+		} else if table.wantCode == http.StatusOK && rsp.Body.String() != table.wantBody {
+			t.Errorf("%s: unexpected response %q, wanted %q", table.desc, rsp.Body.String(), table.wantBody)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	for _, table := range []struct {
+		desc       string
+		maxWaiters int
+		publish    bool
+		want       bool
+	}{
+		{"published", 1, true, true},
+		{"timeout", 1, false, false},
+		{"too many waiters", 0, true, false},
+	} {
+		l := &testLog{size: 2}
+		waiter := NewWaiter(200*time.Millisecond, table.maxWaiters)
+		if table.publish {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				// Some other leaf first.
+				l.publish(waiter, 3)
+				time.Sleep(20 * time.Millisecond)
+				l.publish(waiter, 4)
+			}()
+		}
+		calls := 0
+		got := waiter.Wait(context.Background(), func(ctx context.Context) bool {
+			calls++
+			cth, _ := l.GetTreeHead(ctx)
+			return cth.Size >= 4
+		})
+		if got != table.want {
+			t.Errorf("%s: unexpected result %v, wanted %v", table.desc, got, table.want)
+		}
+		// Without waiting, the condition isn't checked at all.
+		if (calls > 0) != (table.maxWaiters > 0) {
+			t.Errorf("%s: unexpected number of checks %d", table.desc, calls)
+		}
+	}
+}
+
+func TestCheckWaitSequenced(t *testing.T) {
+	for _, table := range []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"true", true, false},
+		{"yes", false, true},
+	} {
+		got, err := CheckWaitSequenced(table.value)
+		if got != table.want || (err != nil) != table.wantErr {
+			t.Errorf("%q: unexpected result %v, err %v", table.value, got, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package lookup
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/journal"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+)
+
+// Number of leaves to index per backend request.
+const indexBatchSize = 1000
+
+// KeyIndex maps submitter key hashes to the indexes of their leaves.
+// It follows the local tree, indexing leaves as they are sequenced,
+// and is persisted in a journal file, see package journal, with the
+// hex key hash of each indexed leaf as a record, in order. The file
+// is never compacted.
+type KeyIndex struct {
+	journal  *journal.Journal
+	file     string
+	dbClient db.Client
+	interval time.Duration
+
+	mu     sync.RWMutex
+	size   uint64
+	leaves map[crypto.Hash][]uint64
+}
+
+// NewKeyIndex creates an index persisted in file, loading any
+// previously indexed leaves. The tree is checked for new leaves every
+// interval.
+func NewKeyIndex(file string, dbClient db.Client, interval time.Duration) (*KeyIndex, error) {
+	k := KeyIndex{
+		file:     file,
+		dbClient: dbClient,
+		interval: interval,
+		leaves:   make(map[crypto.Hash][]uint64),
+	}
+	j, records, err := journal.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		keyHash, err := crypto.HashFromHex(record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key index file %q, line %d: %v", file, k.size+1, err)
+		}
+		k.add(&keyHash)
+	}
+	k.journal = j
+	return &k, nil
+}
+
+// Must be called with the lock held, or before the index is shared.
+func (k *KeyIndex) add(keyHash *crypto.Hash) {
+	k.leaves[*keyHash] = append(k.leaves[*keyHash], k.size)
+	k.size++
+}
+
+// LeafIndexes returns the number of indexed leaves, and the indexes of
+// the leaves signed by the key, in increasing order.
+func (k *KeyIndex) LeafIndexes(keyHash *crypto.Hash) (uint64, []uint64) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.size, append([]uint64(nil), k.leaves[*keyHash]...)
+}
+
+func (k *KeyIndex) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := k.update(ctx); err != nil {
+			log.Warning("updating key index failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Indexes all leaves in the local tree that aren't yet indexed.
+func (k *KeyIndex) update(ctx context.Context) error {
+	th, err := k.dbClient.GetTreeHead(ctx)
+	if err != nil {
+		return fmt.Errorf("getting tree head failed: %v", err)
+	}
+	// Only this goroutine modifies the index, so size can be read
+	// without the lock.
+	if th.Size < k.size {
+		return fmt.Errorf("tree size %d less than indexed size %d, index file %q doesn't match the tree",
+			th.Size, k.size, k.file)
+	}
+	for k.size < th.Size {
+		req := requests.Leaves{StartIndex: k.size, EndIndex: k.size + indexBatchSize}
+		if req.EndIndex > th.Size {
+			req.EndIndex = th.Size
+		}
+		leaves, err := k.dbClient.GetLeaves(ctx, &req)
+		if err != nil {
+			return fmt.Errorf("getting leaves [%d:%d] failed: %v", req.StartIndex, req.EndIndex, err)
+		}
+		if len(leaves) == 0 || uint64(len(leaves)) > req.EndIndex-req.StartIndex {
+			return fmt.Errorf("unexpected leaf count %d when asking for [%d:%d]", len(leaves), req.StartIndex, req.EndIndex)
+		}
+		records := make([]string, len(leaves))
+		for i, leaf := range leaves {
+			records[i] = hex.EncodeToString(leaf.KeyHash[:])
+		}
+		if err := k.journal.Append(records...); err != nil {
+			return fmt.Errorf("storing key index failed: %v", err)
+		}
+		k.mu.Lock()
+		for _, leaf := range leaves {
+			k.add(&leaf.KeyHash)
+		}
+		k.mu.Unlock()
+	}
+	return nil
+}
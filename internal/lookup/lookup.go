@@ -0,0 +1,128 @@
+// Package lookup implements lookup of leaves by leaf hash, on the
+// public get-leaf-by-hash endpoint, and by submitter key hash, on the
+// internal get-leaves-by-key endpoint.
+//
+// A get-leaf-by-hash request is a GET of
+// <prefix>/get-leaf-by-hash/<hex leaf hash>, and the response is, in
+// sigsum's ascii format, the currently published cosigned tree head,
+// as returned by get-tree-head, followed by the inclusion proof
+// against that tree head, as returned by get-inclusion-proof, which
+// includes the leaf's index.
+//
+// A get-leaves-by-key request is a GET of
+// <prefix>/get-leaves-by-key/<hex key hash>, and the response is the
+// number of leaves indexed so far (size=...), followed by one line
+// leaf_index=... for each of the indexed leaves signed by the key, in
+// increasing order.
+package lookup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Endpoint names, relative to the url prefix.
+	LeafEndpoint = "get-leaf-by-hash"
+	KeyEndpoint  = "get-leaves-by-key"
+)
+
+// GetLeafByHashFunc returns the published tree head, and an inclusion
+// proof for the leaf against it.
+type GetLeafByHashFunc func(context.Context, *crypto.Hash) (types.CosignedTreeHead, types.InclusionProof, error)
+
+// WriteLeafResponse writes a get-leaf-by-hash response.
+func WriteLeafResponse(w io.Writer, cth *types.CosignedTreeHead, proof *types.InclusionProof) error {
+	if err := cth.ToASCII(w); err != nil {
+		return err
+	}
+	return proof.ToASCII(w)
+}
+
+// WriteKeyResponse writes a get-leaves-by-key response.
+func WriteKeyResponse(w io.Writer, size uint64, indexes []uint64) error {
+	if err := ascii.WriteInt(w, "size", size); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := ascii.WriteInt(w, "leaf_index", index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the hash in the last path element, after the endpoint name.
+func parseHashPath(p, endpoint string) (crypto.Hash, error) {
+	i := strings.LastIndex(p, "/"+endpoint+"/")
+	if i < 0 {
+		return crypto.Hash{}, fmt.Errorf("invalid path %q", p)
+	}
+	return crypto.HashFromHex(p[i+len(endpoint)+2:])
+}
+
+// NewLeafHandler returns a handler for the get-leaf-by-hash endpoint.
+func NewLeafHandler(timeout time.Duration, getLeaf GetLeafByHashFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		leafHash, err := parseHashPath(r.URL.Path, LeafEndpoint)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		cth, proof, err := getLeaf(ctx, &leafHash)
+		if err != nil {
+			log.Debug("%s failed: %v", LeafEndpoint, err)
+			http.Error(w, err.Error(), api.ErrorStatusCode(err))
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteLeafResponse(&buf, &cth, &proof); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// NewKeyHandler returns a handler for the get-leaves-by-key endpoint.
+func (k *KeyIndex) NewKeyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		keyHash, err := parseHashPath(r.URL.Path, KeyEndpoint)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		size, indexes := k.LeafIndexes(&keyHash)
+		var buf bytes.Buffer
+		if err := WriteKeyResponse(&buf, size, indexes); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,150 @@
+package lookup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Adds leaves signed by the given keys, in order.
+func addLeaves(t *testing.T, tree db.Client, keys []crypto.Hash) {
+	t.Helper()
+	th, err := tree.GetTreeHead(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		leaf := types.Leaf{Checksum: crypto.HashBytes([]byte(fmt.Sprintf("leaf %d", th.Size+uint64(i)))), KeyHash: key}
+		if _, err := tree.AddLeaf(context.Background(), &leaf, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestKeyIndex(t *testing.T) {
+	ctx := context.Background()
+	a, b := crypto.Hash{1}, crypto.Hash{2}
+	file := filepath.Join(t.TempDir(), "index")
+	tree := db.NewMemoryDb()
+	addLeaves(t, tree, []crypto.Hash{a, b, a})
+
+	k, err := NewKeyIndex(file, tree, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	addLeaves(t, tree, []crypto.Hash{b, a})
+	if err := k.update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check := func(desc string, k *KeyIndex, keyHash crypto.Hash, wantSize uint64, wantIndexes []uint64) {
+		t.Helper()
+		size, indexes := k.LeafIndexes(&keyHash)
+		if size != wantSize || !reflect.DeepEqual(indexes, wantIndexes) {
+			t.Errorf("%s: unexpected result size %d, indexes %v, wanted size %d, indexes %v",
+				desc, size, indexes, wantSize, wantIndexes)
+		}
+	}
+	check("a", k, a, 5, []uint64{0, 2, 4})
+	check("b", k, b, 5, []uint64{1, 3})
+	check("unknown", k, crypto.Hash{3}, 5, nil)
+
+	// Reloading discards a partially written line.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("0102")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	reloaded, err := NewKeyIndex(file, tree, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("reloaded", reloaded, a, 5, []uint64{0, 2, 4})
+	addLeaves(t, tree, []crypto.Hash{b})
+	if err := reloaded.update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check("reloaded and updated", reloaded, b, 6, []uint64{1, 3, 5})
+
+	// An index larger than the tree is an error.
+	other, err := NewKeyIndex(file, db.NewMemoryDb(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.update(ctx); err == nil {
+		t.Errorf("index larger than the tree not detected")
+	}
+}
+
+func TestLeafHandler(t *testing.T) {
+	leafHash := crypto.HashBytes([]byte("leaf"))
+	cth := types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 2}}}
+	proof := types.InclusionProof{LeafIndex: 1, Path: []crypto.Hash{crypto.HashBytes([]byte("other"))}}
+	h := NewLeafHandler(time.Minute, func(_ context.Context, h *crypto.Hash) (types.CosignedTreeHead, types.InclusionProof, error) {
+		if *h != leafHash {
+			return types.CosignedTreeHead{}, types.InclusionProof{}, api.ErrNotFound
+		}
+		return cth, proof, nil
+	})
+	var want bytes.Buffer
+	if err := WriteLeafResponse(&want, &cth, &proof); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []struct {
+		desc     string
+		method   string
+		path     string
+		wantCode int
+	}{
+		{"found", http.MethodGet, fmt.Sprintf("/prefix/get-leaf-by-hash/%x", leafHash), http.StatusOK},
+		{"not found", http.MethodGet, fmt.Sprintf("/prefix/get-leaf-by-hash/%x", crypto.Hash{}), http.StatusNotFound},
+		{"invalid hash", http.MethodGet, "/prefix/get-leaf-by-hash/abcd", http.StatusBadRequest},
+		{"wrong method", http.MethodPost, fmt.Sprintf("/prefix/get-leaf-by-hash/%x", leafHash), http.StatusMethodNotAllowed},
+	} {
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest(table.method, table.path, nil))
+		if rsp.Code != table.wantCode {
+			t.Errorf("%s: unexpected status %d, wanted %d", table.desc, rsp.Code, table.wantCode)
+		} else if rsp.Code == http.StatusOK && rsp.Body.String() != want.String() {
+			t.Errorf("%s: unexpected response %q, wanted %q", table.desc, rsp.Body.String(), want.String())
+		}
+	}
+}
+
+func TestKeyHandler(t *testing.T) {
+	a := crypto.Hash{1}
+	tree := db.NewMemoryDb()
+	addLeaves(t, tree, []crypto.Hash{a, {2}, a})
+	k, err := NewKeyIndex(filepath.Join(t.TempDir(), "index"), tree, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rsp := httptest.NewRecorder()
+	k.NewKeyHandler().ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/get-leaves-by-key/%x", a), nil))
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rsp.Code)
+	}
+	if got, want := rsp.Body.String(), "size=3\nleaf_index=0\nleaf_index=2\n"; got != want {
+		t.Errorf("unexpected response %q, wanted %q", got, want)
+	}
+}
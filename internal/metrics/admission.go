@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/google/trillian/monitoring"
+	"github.com/google/trillian/monitoring/prometheus"
+)
+
+// AdmissionMetrics records the state of the primary's admission
+// control.
+type AdmissionMetrics interface {
+	// Number of leaves queued in the backend, but not yet
+	// sequenced.
+	SetBacklog(leaves uint64)
+	// Smoothed latency of backend calls.
+	SetBackendLatency(latency time.Duration)
+	// Records that a submission was rejected.
+	OnRejected(reason string)
+}
+
+// NoAdmissionMetrics records nothing.
+type NoAdmissionMetrics struct{}
+
+func (NoAdmissionMetrics) SetBacklog(uint64)               {}
+func (NoAdmissionMetrics) SetBackendLatency(time.Duration) {}
+func (NoAdmissionMetrics) OnRejected(string)               {}
+
+type admissionMetrics struct {
+	backlog  monitoring.Gauge
+	latency  monitoring.Gauge
+	rejected monitoring.Counter
+}
+
+// NewAdmissionMetrics registers admission metrics, and must be called
+// at most once per process.
+func NewAdmissionMetrics() AdmissionMetrics {
+	mf := prometheus.MetricFactory{}
+	return &admissionMetrics{
+		backlog:  mf.NewGauge("sequencing_backlog", "number of leaves queued in the backend, but not yet sequenced"),
+		latency:  mf.NewGauge("backend_latency_seconds", "smoothed latency of backend calls"),
+		rejected: mf.NewCounter("admission_rejected", "number of submissions rejected by admission control", "reason"),
+	}
+}
+
+func (m *admissionMetrics) SetBacklog(leaves uint64) {
+	m.backlog.Set(float64(leaves))
+}
+
+func (m *admissionMetrics) SetBackendLatency(latency time.Duration) {
+	m.latency.Set(latency.Seconds())
+}
+
+func (m *admissionMetrics) OnRejected(reason string) {
+	m.rejected.Inc(reason)
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/google/trillian/monitoring"
+	"github.com/google/trillian/monitoring/prometheus"
+)
+
+// ReceiptMetrics records the state of the primary's submission
+// receipts.
+type ReceiptMetrics interface {
+	// Records that a receipt was issued.
+	OnIssued()
+	// Number of receipts for leaves not yet in a published tree head.
+	SetPending(count int)
+	// Records that a receipt's deadline passed without the leaf
+	// appearing in a published tree head.
+	OnOverdue()
+}
+
+// NoReceiptMetrics records nothing.
+type NoReceiptMetrics struct{}
+
+func (NoReceiptMetrics) OnIssued()      {}
+func (NoReceiptMetrics) SetPending(int) {}
+func (NoReceiptMetrics) OnOverdue()     {}
+
+type receiptMetrics struct {
+	issued  monitoring.Counter
+	pending monitoring.Gauge
+	overdue monitoring.Counter
+}
+
+// NewReceiptMetrics registers receipt metrics, and must be called at
+// most once per process.
+func NewReceiptMetrics() ReceiptMetrics {
+	mf := prometheus.MetricFactory{}
+	return &receiptMetrics{
+		issued:  mf.NewCounter("receipts_issued", "number of submission receipts issued"),
+		pending: mf.NewGauge("receipts_pending", "number of receipts for leaves not yet in a published tree head"),
+		overdue: mf.NewCounter("receipts_overdue",
+			"number of receipts whose deadline passed without the leaf in a published tree head"),
+	}
+}
+
+func (m *receiptMetrics) OnIssued() {
+	m.issued.Inc()
+}
+
+func (m *receiptMetrics) SetPending(count int) {
+	m.pending.Set(float64(count))
+}
+
+func (m *receiptMetrics) OnOverdue() {
+	m.overdue.Inc()
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/trillian/monitoring"
+	"github.com/google/trillian/monitoring/prometheus"
+)
+
+// ReplicationMetrics records the state of replication from primary
+// to secondary, as observed by either node.
+type ReplicationMetrics interface {
+	// Latest known size of the primary's tree.
+	SetPrimarySize(size uint64)
+	// Latest known size of the secondary's tree.
+	SetSecondarySize(size uint64)
+	// Records a successful replication check; size is the part of
+	// the primary's tree known to be replicated.
+	OnReplicated(size uint64)
+	// Records a replication failure of the given kind.
+	OnError(kind string)
+	// Records that the primary's published tree head was found
+	// inconsistent with the replicated tree.
+	OnSplitView()
+}
+
+// NoReplicationMetrics records nothing.
+type NoReplicationMetrics struct{}
+
+func (NoReplicationMetrics) SetPrimarySize(uint64)   {}
+func (NoReplicationMetrics) SetSecondarySize(uint64) {}
+func (NoReplicationMetrics) OnReplicated(uint64)     {}
+func (NoReplicationMetrics) OnError(string)          {}
+func (NoReplicationMetrics) OnSplitView()            {}
+
+type replicationMetrics struct {
+	now func() time.Time
+
+	primarySize     monitoring.Gauge
+	secondarySize   monitoring.Gauge
+	replicatedSize  monitoring.Gauge
+	lagLeaves       monitoring.Gauge
+	lagSeconds      monitoring.Gauge
+	lastReplication monitoring.Gauge
+	errors          monitoring.Counter
+	splitView       monitoring.Gauge
+
+	// Protects the below, used for computing lag.
+	mu         sync.Mutex
+	replicated uint64
+	// Primary tree sizes not yet replicated, in increasing order,
+	// with the time each was first observed.
+	pending []observedSize
+}
+
+type observedSize struct {
+	size uint64
+	time time.Time
+}
+
+// Bounds memory use if replication is stuck while the primary keeps
+// growing. When full, the last entry is updated in place, which
+// keeps the age of the oldest entry, and hence the lag, accurate.
+const maxPendingSizes = 1000
+
+// NewReplicationMetrics registers replication metrics, and must be
+// called at most once per process.
+func NewReplicationMetrics() ReplicationMetrics {
+	return newReplicationMetrics(prometheus.MetricFactory{}, time.Now)
+}
+
+func newReplicationMetrics(mf monitoring.MetricFactory, now func() time.Time) *replicationMetrics {
+	return &replicationMetrics{
+		now:            now,
+		primarySize:    mf.NewGauge("replication_primary_tree_size", "latest known size of the primary's tree"),
+		secondarySize:  mf.NewGauge("replication_secondary_tree_size", "latest known size of the secondary's tree"),
+		replicatedSize: mf.NewGauge("replication_replicated_tree_size", "latest size known to be replicated to the secondary"),
+		lagLeaves:      mf.NewGauge("replication_lag_leaves", "number of leaves in the primary's tree not yet known to be replicated"),
+		lagSeconds: mf.NewGauge("replication_lag_seconds",
+			"time since the primary's tree first grew beyond the replicated size"),
+		lastReplication: mf.NewGauge("replication_last_success_timestamp_seconds",
+			"unix time of the latest successful replication check"),
+		errors:    mf.NewCounter("replication_errors", "number of replication errors", "kind"),
+		splitView: mf.NewGauge("replication_split_view", "1 if the primary's published tree head is inconsistent with the replicated tree"),
+	}
+}
+
+// Lag in seconds is the age of the oldest primary tree size not yet
+// replicated, so that it stays small when the secondary keeps up with
+// a primary that grows continuously. Must be called with the lock held.
+func (m *replicationMetrics) updateLag(now time.Time) {
+	i := 0
+	for i < len(m.pending) && m.pending[i].size <= m.replicated {
+		i++
+	}
+	m.pending = m.pending[i:]
+	if len(m.pending) == 0 {
+		m.lagLeaves.Set(0)
+		m.lagSeconds.Set(0)
+		return
+	}
+	m.lagLeaves.Set(float64(m.pending[len(m.pending)-1].size - m.replicated))
+	m.lagSeconds.Set(now.Sub(m.pending[0].time).Seconds())
+}
+
+func (m *replicationMetrics) SetPrimarySize(size uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.primarySize.Set(float64(size))
+	switch n := len(m.pending); {
+	case size <= m.replicated || (n > 0 && size <= m.pending[n-1].size):
+		// Nothing new.
+	case n >= maxPendingSizes:
+		m.pending[n-1].size = size
+	default:
+		m.pending = append(m.pending, observedSize{size: size, time: now})
+	}
+	m.updateLag(now)
+}
+
+func (m *replicationMetrics) SetSecondarySize(size uint64) {
+	m.secondarySize.Set(float64(size))
+}
+
+func (m *replicationMetrics) OnReplicated(size uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.replicated = size
+	m.replicatedSize.Set(float64(size))
+	m.lastReplication.Set(float64(now.Unix()))
+	m.updateLag(now)
+}
+
+func (m *replicationMetrics) OnError(kind string) {
+	m.errors.Inc(kind)
+}
+
+func (m *replicationMetrics) OnSplitView() {
+	m.errors.Inc("split-view")
+	m.splitView.Set(1)
+}
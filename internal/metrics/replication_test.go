@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/trillian/monitoring"
+)
+
+func TestReplicationLag(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := newReplicationMetrics(monitoring.InertMetricFactory{}, func() time.Time { return now })
+	gauge := func(g monitoring.Gauge) float64 {
+		return g.(*monitoring.InertFloat).Value()
+	}
+	check := func(desc string, wantLeaves, wantSeconds float64) {
+		t.Helper()
+		if got := gauge(m.lagLeaves); got != wantLeaves {
+			t.Errorf("%s: unexpected lag in leaves, got %v, want %v", desc, got, wantLeaves)
+		}
+		if got := gauge(m.lagSeconds); got != wantSeconds {
+			t.Errorf("%s: unexpected lag in seconds, got %v, want %v", desc, got, wantSeconds)
+		}
+	}
+	m.SetPrimarySize(10)
+	check("initial", 10, 0)
+	now = now.Add(5 * time.Second)
+	m.SetPrimarySize(15)
+	check("growing", 15, 5)
+
+	now = now.Add(5 * time.Second)
+	m.OnReplicated(10)
+	check("partly replicated", 5, 5)
+	if got, want := gauge(m.replicatedSize), 10.0; got != want {
+		t.Errorf("unexpected replicated size, got %v, want %v", got, want)
+	}
+	if got, want := gauge(m.lastReplication), 1010.0; got != want {
+		t.Errorf("unexpected replication time, got %v, want %v", got, want)
+	}
+
+	// Keeps up with a growing primary.
+	now = now.Add(5 * time.Second)
+	m.SetPrimarySize(20)
+	m.OnReplicated(15)
+	check("keeping up", 5, 0)
+
+	now = now.Add(5 * time.Second)
+	m.OnReplicated(20)
+	check("replicated", 0, 0)
+
+	// Stuck replication.
+	for i := 0; i < 2*maxPendingSizes; i++ {
+		now = now.Add(time.Second)
+		m.SetPrimarySize(uint64(21 + i))
+	}
+	check("stuck", 2*maxPendingSizes, 2*maxPendingSizes-1)
+	if got := len(m.pending); got > maxPendingSizes {
+		t.Errorf("unexpected number of pending sizes: %d", got)
+	}
+}
@@ -52,6 +52,21 @@ func (mr *MockClientMockRecorder) AddLeaf(arg0, arg1, arg2 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLeaf", reflect.TypeOf((*MockClient)(nil).AddLeaf), arg0, arg1, arg2)
 }
 
+// AddLeaves mocks base method.
+func (m *MockClient) AddLeaves(arg0 context.Context, arg1 []types.Leaf, arg2 uint64) ([]db.AddLeafStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddLeaves", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]db.AddLeafStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddLeaves indicates an expected call of AddLeaves.
+func (mr *MockClientMockRecorder) AddLeaves(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLeaves", reflect.TypeOf((*MockClient)(nil).AddLeaves), arg0, arg1, arg2)
+}
+
 // AddSequencedLeaves mocks base method.
 func (m *MockClient) AddSequencedLeaves(arg0 context.Context, arg1 []types.Leaf, arg2 int64) error {
 	m.ctrl.T.Helper()
@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: sigsum.org/log-go/internal/state (interfaces: StateManager)
+// Source: sigsum.org/log-go/internal/state (interfaces: StateManager,SecondaryTree)
 
 // Package state is a generated GoMock package.
 package state
@@ -10,6 +10,8 @@ import (
 	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	freshness "sigsum.org/log-go/internal/freshness"
+	crypto "sigsum.org/sigsum-go/pkg/crypto"
 	policy "sigsum.org/sigsum-go/pkg/policy"
 	types "sigsum.org/sigsum-go/pkg/types"
 )
@@ -76,3 +78,56 @@ func (mr *MockStateManagerMockRecorder) SignedTreeHead() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignedTreeHead", reflect.TypeOf((*MockStateManager)(nil).SignedTreeHead))
 }
+
+// MockSecondaryTree is a mock of SecondaryTree interface.
+type MockSecondaryTree struct {
+	ctrl     *gomock.Controller
+	recorder *MockSecondaryTreeMockRecorder
+}
+
+// MockSecondaryTreeMockRecorder is the mock recorder for MockSecondaryTree.
+type MockSecondaryTreeMockRecorder struct {
+	mock *MockSecondaryTree
+}
+
+// NewMockSecondaryTree creates a new mock instance.
+func NewMockSecondaryTree(ctrl *gomock.Controller) *MockSecondaryTree {
+	mock := &MockSecondaryTree{ctrl: ctrl}
+	mock.recorder = &MockSecondaryTreeMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSecondaryTree) EXPECT() *MockSecondaryTreeMockRecorder {
+	return m.recorder
+}
+
+// GetFreshSecondaryTreeHead mocks base method.
+func (m *MockSecondaryTree) GetFreshSecondaryTreeHead(arg0 context.Context, arg1 *crypto.Hash) (freshness.TreeHead, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFreshSecondaryTreeHead", arg0, arg1)
+	ret0, _ := ret[0].(freshness.TreeHead)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFreshSecondaryTreeHead indicates an expected call of GetFreshSecondaryTreeHead.
+func (mr *MockSecondaryTreeMockRecorder) GetFreshSecondaryTreeHead(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFreshSecondaryTreeHead", reflect.TypeOf((*MockSecondaryTree)(nil).GetFreshSecondaryTreeHead), arg0, arg1)
+}
+
+// GetSecondaryTreeHead mocks base method.
+func (m *MockSecondaryTree) GetSecondaryTreeHead(arg0 context.Context) (types.SignedTreeHead, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSecondaryTreeHead", arg0)
+	ret0, _ := ret[0].(types.SignedTreeHead)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSecondaryTreeHead indicates an expected call of GetSecondaryTreeHead.
+func (mr *MockSecondaryTreeMockRecorder) GetSecondaryTreeHead(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSecondaryTreeHead", reflect.TypeOf((*MockSecondaryTree)(nil).GetSecondaryTreeHead), arg0)
+}
@@ -6,30 +6,65 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
+	"sigsum.org/log-go/internal/batch"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/headers"
+	"sigsum.org/log-go/internal/longpoll"
+	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/receipt"
+	"sigsum.org/log-go/internal/webhook"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
+// Returns the verified domain of the submit token, or nil if there's
+// no token.
+func (p Primary) verifyToken(ctx context.Context, t *token.SubmitHeader) (*string, error) {
+	if t == nil || p.TokenVerifier == nil {
+		return nil, nil
+	}
+	// TODO: Return more appropriate errors from TokenVerifier?
+	if err := p.TokenVerifier.Verify(ctx, t); err != nil {
+		return nil, api.NewError(http.StatusBadRequest, err)
+	}
+	return &t.Domain, nil
+}
+
+// Returns an error if new leaves are rejected.
+func (p Primary) checkWritable() error {
+	if p.IsReadOnly() {
+		return api.NewError(http.StatusServiceUnavailable,
+			fmt.Errorf("log is in read-only mode, not accepting new leaves"))
+	}
+	return nil
+}
+
 func (p Primary) AddLeaf(ctx context.Context, req requests.Leaf, t *token.SubmitHeader) (bool, error) {
 	log.Debug("handling add-leaf request")
-	var domain *string
-	if t != nil && p.TokenVerifier != nil {
-		// TODO: Return more appropriate errors from TokenVerifier?
-		if err := p.TokenVerifier.Verify(ctx, t); err != nil {
-			return false, api.NewError(http.StatusBadRequest, err)
-		}
-		domain = &t.Domain
+	if err := p.checkWritable(); err != nil {
+		return false, err
+	}
+	domain, err := p.verifyToken(ctx, t)
+	if err != nil {
+		return false, err
 	}
 	keyHash := crypto.HashBytes(req.PublicKey[:])
-	relax := p.RateLimiter.AccessAllowed(domain, &keyHash)
+	relax, quota := p.RateLimiter.AccessAllowed(domain, &keyHash)
 	if relax == nil {
-		if domain == nil {
+		rateLimit.RecordQuota(ctx, &quota)
+		switch {
+		case quota.Rule == rateLimit.RuleKey:
+			return false, api.NewError(http.StatusTooManyRequests, fmt.Errorf("rate-limit for submitter key exceeded"))
+		case len(quota.Rule) > 0:
+			return false, api.NewError(http.StatusTooManyRequests, fmt.Errorf("rate-limit for domain %q exceeded", quota.Domain))
+		case domain == nil:
 			return false, api.NewError(http.StatusTooManyRequests, fmt.Errorf("rate-limit for unknown domain exceeded"))
 		}
 		return false, api.NewError(http.StatusTooManyRequests, fmt.Errorf("rate-limit for domain %q exceeded", *domain))
@@ -38,20 +73,172 @@ func (p Primary) AddLeaf(ctx context.Context, req requests.Leaf, t *token.Submit
 	if err != nil {
 		return false, api.NewError(http.StatusForbidden, err)
 	}
+	// Check optional request headers before adding the leaf.
+	var callback string
+	if p.Webhooks != nil {
+		callback = headers.Get(ctx, webhook.CallbackHeader)
+		if len(callback) > 0 {
+			if err := p.Webhooks.CheckTarget(callback); err != nil {
+				return false, api.NewError(http.StatusBadRequest, err)
+			}
+		}
+	}
+	var wait bool
+	if p.LongPoll != nil {
+		if wait, err = longpoll.CheckWaitSequenced(headers.Get(ctx, longpoll.WaitSequencedHeader)); err != nil {
+			return false, api.NewError(http.StatusBadRequest, err)
+		}
+	}
 
 	sth := p.Stateman.SignedTreeHead()
+	start := time.Now()
 	status, err := p.DbClient.AddLeaf(ctx,
 		&leaf, sth.Size)
 	log.Debug("status: %#v, err: %v", status, err)
+	if p.Admission != nil {
+		p.Admission.OnBackendCall(time.Since(start))
+	}
 	if err != nil {
 		return false, err
 	}
-	if status.AlreadyExists {
+	switch {
+	case !status.AlreadyExists:
+		p.onQueued(1)
+	case len(callback) == 0:
 		relax()
+		quota.Remaining++
+	}
+	// A resubmission with a callback still counts towards the
+	// rate limit, since it adds an entry to the callback queue.
+	rateLimit.RecordQuota(ctx, &quota)
+
+	leafHash := merkle.HashLeafNode(leaf.ToBinary())
+	p.onAccepted(ctx, &leafHash, callback)
+	if wait && !status.IsSequenced {
+		if p.LongPoll.Wait(ctx, func(ctx context.Context) bool { return p.isPublished(ctx, &leafHash) }) {
+			log.Debug("long-polled leaf published")
+			return true, nil
+		}
 	}
 	return status.IsSequenced, nil
 }
 
+// Issues a receipt and registers a callback, if enabled, for a leaf
+// accepted by the backend. Failures don't reject the leaf, since it
+// is added already; they are reported in response headers, if at
+// all, and the client can resubmit the leaf to retry.
+func (p Primary) onAccepted(ctx context.Context, leafHash *crypto.Hash, callback string) {
+	if p.Receipts != nil {
+		if r, err := p.Receipts.Issue(leafHash); err != nil {
+			log.Warning("issuing receipt failed: %v", err)
+		} else {
+			headers.Set(ctx, receipt.Header, r)
+		}
+	}
+	if len(callback) > 0 {
+		if err := p.Webhooks.Register(leafHash, callback); err != nil {
+			log.Warning("registering callback failed: %v", err)
+			headers.Set(ctx, webhook.ErrorHeader, err.Error())
+		}
+	}
+}
+
+// Returns true if the leaf is included in the published tree.
+func (p Primary) isPublished(ctx context.Context, leafHash *crypto.Hash) bool {
+	cth := p.Stateman.CosignedTreeHead()
+	if cth.Size == 0 {
+		return false
+	}
+	_, err := p.DbClient.GetInclusionProof(ctx, &requests.InclusionProof{Size: cth.Size, LeafHash: *leafHash})
+	return err == nil
+}
+
+// AddLeaves handles a batch of add-leaf requests, with the submit
+// token, if any, applying to all of them. Each leaf is checked and
+// rate limited separately, and rejected leaves don't prevent the
+// others from being added. All accepted leaves are passed to the
+// backend in a single call.
+func (p Primary) AddLeaves(ctx context.Context, reqs []requests.Leaf, t *token.SubmitHeader) ([]batch.Result, error) {
+	log.Debug("handling add-leaves request, %d leaves", len(reqs))
+	if err := p.checkWritable(); err != nil {
+		return nil, err
+	}
+	domain, err := p.verifyToken(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]batch.Result, len(reqs))
+	var leaves []types.Leaf
+	var indices []int
+	var relaxes []func()
+	var quotas []rateLimit.Quota
+	for i, req := range reqs {
+		// Check signature first, so that invalid leaves don't
+		// count towards the rate limit.
+		leaf, err := req.Verify()
+		if err != nil {
+			results[i] = batch.Result{Status: batch.Rejected, Reason: batch.ReasonInvalidSignature}
+			continue
+		}
+		keyHash := crypto.HashBytes(req.PublicKey[:])
+		relax, quota := p.RateLimiter.AccessAllowed(domain, &keyHash)
+		if relax == nil {
+			rateLimit.RecordQuota(ctx, &quota)
+			results[i] = batch.Result{Status: batch.Rejected, Reason: batch.ReasonRateLimited}
+			continue
+		}
+		leaves = append(leaves, leaf)
+		indices = append(indices, i)
+		relaxes = append(relaxes, relax)
+		quotas = append(quotas, quota)
+	}
+	if len(leaves) == 0 {
+		return results, nil
+	}
+
+	sth := p.Stateman.SignedTreeHead()
+	start := time.Now()
+	status, err := p.DbClient.AddLeaves(ctx, leaves, sth.Size)
+	if p.Admission != nil {
+		p.Admission.OnBackendCall(time.Since(start))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(status) != len(leaves) {
+		return nil, fmt.Errorf("backend returned %d status values for %d leaves", len(status), len(leaves))
+	}
+	added := 0
+	for j, s := range status {
+		if s.AlreadyExists {
+			relaxes[j]()
+			quotas[j].Remaining++
+		} else {
+			added++
+		}
+		rateLimit.RecordQuota(ctx, &quotas[j])
+		if s.IsSequenced {
+			results[indices[j]] = batch.Result{Status: batch.Sequenced}
+		} else {
+			results[indices[j]] = batch.Result{Status: batch.Accepted}
+		}
+	}
+	if added > 0 {
+		p.onQueued(added)
+	}
+	return results, nil
+}
+
+// Records leaves newly added to the backend.
+func (p Primary) onQueued(count int) {
+	if p.Admission != nil {
+		p.Admission.OnQueued(count)
+	}
+	if p.Notifier != nil {
+		p.Notifier.Kick()
+	}
+}
+
 func (p Primary) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
 	log.Debug("handling get-tree-head request")
 	return p.Stateman.CosignedTreeHead(), nil
@@ -129,3 +316,21 @@ func (p Primary) getLeavesGeneral(ctx context.Context, req requests.Leaves,
 func (p Primary) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
 	return p.getLeavesGeneral(ctx, req, p.Stateman.CosignedTreeHead().Size, true)
 }
+
+// GetLeafByHash returns the published tree head, and an inclusion
+// proof against it for the leaf with the given hash.
+func (p Primary) GetLeafByHash(ctx context.Context, leafHash *crypto.Hash) (types.CosignedTreeHead, types.InclusionProof, error) {
+	log.Debug("handling get-leaf-by-hash request")
+	cth := p.Stateman.CosignedTreeHead()
+	if cth.Size == 0 {
+		return types.CosignedTreeHead{}, types.InclusionProof{}, api.ErrNotFound
+	}
+	proof, err := p.DbClient.GetInclusionProof(ctx, &requests.InclusionProof{Size: cth.Size, LeafHash: *leafHash})
+	if err == db.ErrNotIncluded {
+		err = api.ErrNotFound
+	}
+	if err != nil {
+		return types.CosignedTreeHead{}, types.InclusionProof{}, err
+	}
+	return cth, proof, nil
+}
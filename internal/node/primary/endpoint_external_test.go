@@ -4,15 +4,29 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/admission"
+	"sigsum.org/log-go/internal/batch"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/headers"
+	"sigsum.org/log-go/internal/longpoll"
+	"sigsum.org/log-go/internal/metrics"
 	mocksDB "sigsum.org/log-go/internal/mocks/db"
 	mocksState "sigsum.org/log-go/internal/mocks/state"
 	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/receipt"
+	"sigsum.org/log-go/internal/webhook"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
@@ -26,7 +40,15 @@ func TestAddLeaf(t *testing.T) {
 		wantCode    int   // HTTP status
 		committed   bool
 		leafStatus  db.AddLeafStatus // return value from db.AddLeaf()
+		readOnly    bool
+		queued      bool // leaf counted in the sequencing backlog
 	}{
+		{
+			description: "invalid: read-only",
+			req:         mustLeaf(t, crypto.Hash{}, true),
+			readOnly:    true,
+			wantCode:    http.StatusServiceUnavailable,
+		},
 		{
 			description: "invalid: bad request (signature error)",
 			req:         mustLeaf(t, crypto.Hash{}, false),
@@ -41,12 +63,19 @@ func TestAddLeaf(t *testing.T) {
 		{
 			description: "valid: 202",
 			req:         mustLeaf(t, crypto.Hash{}, true),
+			queued:      true,
+		},
+		{
+			description: "valid: 202, duplicate",
+			req:         mustLeaf(t, crypto.Hash{}, true),
+			leafStatus:  db.AddLeafStatus{AlreadyExists: true},
 		},
 		{
 			description: "valid: 200",
 			req:         mustLeaf(t, crypto.Hash{}, true),
 			leafStatus:  db.AddLeafStatus{IsSequenced: true},
 			committed:   true,
+			queued:      true,
 		},
 	} {
 		// Run deferred functions at the end of each iteration
@@ -62,7 +91,10 @@ func TestAddLeaf(t *testing.T) {
 				DbClient:    client,
 				Stateman:    stateman,
 				RateLimiter: rateLimit.NoLimit{},
+				ReadOnly:    &atomic.Bool{},
+				Admission:   admission.NewController(0, 0, time.Minute, metrics.NoAdmissionMetrics{}),
 			}
+			node.ReadOnly.Store(table.readOnly)
 
 			committed, err := node.AddLeaf(context.Background(), table.req, nil)
 			if err := checkError(err, table.wantCode); err != nil {
@@ -70,6 +102,221 @@ func TestAddLeaf(t *testing.T) {
 			} else if got, want := committed, table.committed; got != want {
 				t.Errorf("unexpected commit status, got %v, wanted %v in test %q", got, want, table.description)
 			}
+			if got, want := node.Admission.Backlog() == 1, table.queued; got != want {
+				t.Errorf("unexpected backlog %d in test %q", node.Admission.Backlog(), table.description)
+			}
+		}()
+	}
+}
+
+// Accepts all notifications.
+type testHook struct{}
+
+func (testHook) Deliver(_ context.Context, _ []byte, _ string) error {
+	return nil
+}
+
+func TestAddLeafHeaders(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []struct {
+		description   string
+		callback      string
+		exists        bool // leaf already added
+		failQueue     bool // storing the callback queue fails
+		wait          string
+		published     bool // leaf included in the published tree
+		wantCode      int
+		wantCommitted bool
+		wantError     bool // callback error header
+		wantRelaxed   int
+	}{
+		{description: "no headers"},
+		{description: "callback", callback: "hook:test"},
+		{description: "existing leaf", exists: true, wantRelaxed: 1},
+		{description: "existing leaf, callback", callback: "hook:test", exists: true},
+		{description: "unknown hook", callback: "hook:other", wantCode: http.StatusBadRequest},
+		{description: "callback failure", callback: "hook:test", failQueue: true, wantError: true},
+		{description: "wait, published", wait: "true", published: true, wantCommitted: true},
+		{description: "wait, timeout", wait: "true"},
+		{description: "invalid wait", wait: "yes", wantCode: http.StatusBadRequest},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+				db.AddLeafStatus{AlreadyExists: table.exists}, nil).AnyTimes()
+			if table.published {
+				client.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(types.InclusionProof{}, nil).AnyTimes()
+			} else {
+				client.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(types.InclusionProof{}, db.ErrNotIncluded).AnyTimes()
+			}
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().SignedTreeHead().Return(types.SignedTreeHead{}).AnyTimes()
+			stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{
+				SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 5}}}).AnyTimes()
+
+			dir := filepath.Join(t.TempDir(), "queue")
+			webhooks, err := webhook.NewQueue(filepath.Join(dir, "queue"), signer, client,
+				map[string]webhook.Hook{"test": testHook{}}, false, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.failQueue {
+				// Blocks creating the queue file.
+				if err := os.WriteFile(dir, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			receipts, err := receipt.NewTracker(filepath.Join(t.TempDir(), "receipts"), signer, client,
+				time.Minute, metrics.NoReceiptMetrics{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			limiter := testLimiter{allow: 1}
+			node := Primary{
+				DbClient:    client,
+				Stateman:    stateman,
+				RateLimiter: &limiter,
+				Webhooks:    webhooks,
+				Receipts:    receipts,
+				LongPoll:    longpoll.NewWaiter(50*time.Millisecond, 1),
+			}
+			req := mustLeaf(t, crypto.Hash{}, true)
+			leaf, err := req.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			leafHash := merkle.HashLeafNode(leaf.ToBinary())
+
+			var committed bool
+			h := headers.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				committed, err = node.AddLeaf(r.Context(), req, nil)
+			}))
+			httpReq := httptest.NewRequest(http.MethodPost, "/add-leaf", nil)
+			if len(table.callback) > 0 {
+				httpReq.Header.Set(webhook.CallbackHeader, table.callback)
+			}
+			if len(table.wait) > 0 {
+				httpReq.Header.Set(longpoll.WaitSequencedHeader, table.wait)
+			}
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httpReq)
+			if err := checkError(err, table.wantCode); err != nil {
+				t.Errorf("in test %q: %v", table.description, err)
+				return
+			}
+			if committed != table.wantCommitted {
+				t.Errorf("unexpected commit status %v in test %q", committed, table.description)
+			}
+			receiptHeader := rsp.Header().Get(receipt.Header)
+			if err != nil {
+				if len(receiptHeader) > 0 {
+					t.Errorf("unexpected receipt %q in test %q", receiptHeader, table.description)
+				}
+				return
+			}
+			if _, err := receipt.Verify(&pub, &leafHash, receiptHeader); err != nil {
+				t.Errorf("invalid receipt %q in test %q: %v", receiptHeader, table.description, err)
+			}
+			if got := rsp.Header().Get(webhook.ErrorHeader); (len(got) > 0) != table.wantError {
+				t.Errorf("unexpected callback error header %q in test %q", got, table.description)
+			}
+			if limiter.relaxed != table.wantRelaxed {
+				t.Errorf("unexpected number of undone accesses in test %q: got %d, wanted %d",
+					table.description, limiter.relaxed, table.wantRelaxed)
+			}
+		}()
+	}
+}
+
+// Allows a fixed number of accesses, and counts undone accesses.
+type testLimiter struct {
+	allow   int
+	relaxed int
+}
+
+func (l *testLimiter) AccessAllowed(_ *string, _ *crypto.Hash) (func(), rateLimit.Quota) {
+	if l.allow <= 0 {
+		return nil, rateLimit.Quota{}
+	}
+	l.allow--
+	return func() { l.relaxed++ }, rateLimit.Quota{}
+}
+
+func TestAddLeaves(t *testing.T) {
+	valid := mustLeaf(t, crypto.Hash{1}, true)
+	invalid := mustLeaf(t, crypto.Hash{2}, false)
+	accepted := batch.Result{Status: batch.Accepted}
+	sequenced := batch.Result{Status: batch.Sequenced}
+	badSig := batch.Result{Status: batch.Rejected, Reason: batch.ReasonInvalidSignature}
+	limited := batch.Result{Status: batch.Rejected, Reason: batch.ReasonRateLimited}
+	for _, table := range []struct {
+		description string
+		reqs        []requests.Leaf
+		allow       int
+		leafStatus  []db.AddLeafStatus // return value from db.AddLeaves(), nil if not called
+		errBackend  error
+		wantCode    int // HTTP status
+		want        []batch.Result
+		wantRelaxed int
+	}{
+		{
+			description: "invalid: backend failure",
+			reqs:        []requests.Leaf{valid},
+			allow:       1,
+			leafStatus:  []db.AddLeafStatus{},
+			errBackend:  fmt.Errorf("something went wrong"),
+			wantCode:    http.StatusInternalServerError,
+		},
+		{
+			description: "all rejected",
+			reqs:        []requests.Leaf{invalid, valid},
+			want:        []batch.Result{badSig, limited},
+		},
+		{
+			description: "partially accepted",
+			reqs:        []requests.Leaf{valid, invalid, valid, valid},
+			allow:       2,
+			leafStatus:  []db.AddLeafStatus{{}, {AlreadyExists: true, IsSequenced: true}},
+			want:        []batch.Result{accepted, badSig, sequenced, limited},
+			wantRelaxed: 1,
+		},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			if table.leafStatus != nil {
+				client.EXPECT().AddLeaves(gomock.Any(), gomock.Any(), uint64(5)).Return(table.leafStatus, table.errBackend)
+			}
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().SignedTreeHead().Return(types.SignedTreeHead{TreeHead: types.TreeHead{Size: 5}}).AnyTimes()
+			limiter := testLimiter{allow: table.allow}
+			node := Primary{
+				DbClient:    client,
+				Stateman:    stateman,
+				RateLimiter: &limiter,
+			}
+
+			results, err := node.AddLeaves(context.Background(), table.reqs, nil)
+			if err := checkError(err, table.wantCode); err != nil {
+				t.Errorf("in test %q: %v", table.description, err)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(results, table.want) {
+				t.Errorf("unexpected results in test %q: got %v, wanted %v", table.description, results, table.want)
+			}
+			if limiter.relaxed != table.wantRelaxed {
+				t.Errorf("unexpected number of undone accesses in test %q: got %d, wanted %d",
+					table.description, limiter.relaxed, table.wantRelaxed)
+			}
 		}()
 	}
 }
@@ -241,6 +488,90 @@ func TestGetInclusionProof(t *testing.T) {
 	}
 }
 
+func TestGetLeafByHash(t *testing.T) {
+	leafHash := crypto.HashBytes([]byte("leaf"))
+	for _, table := range []struct {
+		description string
+		sthSize     uint64
+		rootHash    crypto.Hash
+		rsp         types.InclusionProof // inclusion proof from Trillian client
+		err         error                // error from Trillian client
+		wantCode    int                  // HTTP status ok
+	}{
+		{
+			description: "invalid: empty tree",
+			wantCode:    http.StatusNotFound,
+		},
+		{
+			description: "invalid: not the only leaf",
+			sthSize:     1,
+			err:         db.ErrNotIncluded,
+			wantCode:    http.StatusNotFound,
+		},
+		{
+			description: "invalid: backend failure",
+			sthSize:     2,
+			err:         fmt.Errorf("something went wrong"),
+			wantCode:    http.StatusInternalServerError,
+		},
+		{
+			description: "invalid: not included",
+			sthSize:     2,
+			err:         db.ErrNotIncluded,
+			wantCode:    http.StatusNotFound,
+		},
+		{
+			description: "valid: only leaf",
+			sthSize:     1,
+			rootHash:    leafHash,
+		},
+		{
+			description: "valid",
+			sthSize:     2,
+			rsp: types.InclusionProof{
+				LeafIndex: 1,
+				Path: []crypto.Hash{
+					crypto.HashBytes([]byte{}),
+				},
+			},
+		},
+	} {
+		// Run deferred functions at the end of each iteration
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			if table.sthSize > 0 {
+				client.EXPECT().GetInclusionProof(gomock.Any(), &requests.InclusionProof{
+					Size: table.sthSize, LeafHash: leafHash}).Return(table.rsp, table.err)
+			}
+			th := types.TreeHead{Size: table.sthSize, RootHash: table.rootHash}
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().CosignedTreeHead().Return(
+				types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: th}}).AnyTimes()
+
+			node := Primary{
+				DbClient: client,
+				Stateman: stateman,
+			}
+
+			cth, proof, err := node.GetLeafByHash(context.Background(), &leafHash)
+			if err := checkError(err, table.wantCode); err != nil {
+				t.Errorf("in test %q: %v", table.description, err)
+				return
+			}
+			if table.wantCode != 0 {
+				return
+			}
+			if cth.TreeHead != th {
+				t.Errorf("unexpected tree head in test %q, got %v, wanted %v", table.description, cth.TreeHead, th)
+			} else if proof.LeafIndex != table.rsp.LeafIndex || !pathIsEqual(proof.Path, table.rsp.Path) {
+				t.Errorf("unexpected proof, got %x, wanted %x", proof, table.rsp)
+			}
+		}()
+	}
+}
+
 func TestGetLeaves(t *testing.T) {
 	const testMaxRange = 3
 
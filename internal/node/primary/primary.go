@@ -1,9 +1,20 @@
 package primary
 
 import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"sigsum.org/log-go/internal/admission"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/longpoll"
+	"sigsum.org/log-go/internal/lookup"
+	"sigsum.org/log-go/internal/notify"
 	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/receipt"
 	"sigsum.org/log-go/internal/state"
+	"sigsum.org/log-go/internal/webhook"
+	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/submit-token"
 )
 
@@ -14,4 +25,31 @@ type Primary struct {
 	Stateman      state.StateManager // coordinates access to (co)signed tree heads
 	TokenVerifier *token.DnsVerifier // checks if domain name knows a public key
 	RateLimiter   rateLimit.Limiter
+	Notifier      *notify.Notifier      // Optional, tells the secondary about new leaves
+	Webhooks      *webhook.Queue        // Optional, notifies submitters of published leaves
+	Receipts      *receipt.Tracker      // Optional, issues receipts for accepted leaves
+	KeyIndex      *lookup.KeyIndex      // Optional, maps submitter key hashes to leaf indexes
+	ReadOnly      *atomic.Bool          // Optional, if set and true, new leaves are rejected
+	Finalizer     state.Finalizer       // Optional, supports retirement, see Retire
+	Retiring      *atomic.Bool          // Required for retirement, set while retiring
+	Admission     *admission.Controller // Optional, tracks the backend's sequencing backlog
+	LongPoll      *longpoll.Waiter      // Optional, lets add-leaf requests wait for publication
+}
+
+// IsReadOnly returns true if new leaves are rejected.
+func (p Primary) IsReadOnly() bool {
+	return p.ReadOnly != nil && p.ReadOnly.Load()
+}
+
+// SetReadOnly switches read-only mode on or off. The state manager
+// keeps cosigning the tree in either mode.
+func (p Primary) SetReadOnly(readOnly bool) error {
+	if p.ReadOnly == nil {
+		return fmt.Errorf("read-only mode not supported")
+	}
+	if !readOnly && p.RetirementState() != RetirementActive {
+		return api.NewError(http.StatusConflict, fmt.Errorf("log is retired or being retired"))
+	}
+	p.ReadOnly.Store(readOnly)
+	return nil
 }
@@ -0,0 +1,96 @@
+package primary
+
+// This file implements retirement of the log.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+const (
+	RetirementActive   = "active"
+	RetirementRetiring = "retiring"
+	RetirementRetired  = "retired"
+)
+
+// Number of consecutive polls with unchanged local tree size before
+// the backend's queue is considered empty. Backends, e.g., Trillian,
+// may sequence accepted leaves asynchronously, and there's no
+// interface for inspecting the queue.
+const retireQuietPolls = 3
+
+// RetirementState returns one of RetirementActive,
+// RetirementRetiring, and RetirementRetired.
+func (p Primary) RetirementState() string {
+	switch {
+	case p.Finalizer != nil && p.Finalizer.IsFinal():
+		return RetirementRetired
+	case p.Retiring != nil && p.Retiring.Load():
+		return RetirementRetiring
+	default:
+		return RetirementActive
+	}
+}
+
+// BeginRetirement switches to read-only mode, permanently, and marks
+// the log as being retired. Returns false if the log is already
+// retired or being retired. The caller must then call
+// FinishRetirement.
+func (p Primary) BeginRetirement() (bool, error) {
+	if p.Finalizer == nil || p.ReadOnly == nil || p.Retiring == nil {
+		return false, api.NewError(http.StatusNotImplemented, fmt.Errorf("retirement not supported"))
+	}
+	if p.Finalizer.IsFinal() || !p.Retiring.CompareAndSwap(false, true) {
+		return false, nil
+	}
+	p.ReadOnly.Store(true)
+	log.Info("retiring log, new leaves are rejected")
+	return true, nil
+}
+
+// FinishRetirement waits until all leaves accepted by the backend are
+// sequenced, and the published tree head covers them, which implies
+// that they are replicated, if there's a secondary. The published
+// tree head is then made final. The local tree is polled every
+// interval, which should be the interval at which tree heads are
+// published. On failure, e.g., if ctx is cancelled, the log stays in
+// read-only mode, and retirement can be started over.
+func (p Primary) FinishRetirement(ctx context.Context, interval time.Duration) error {
+	defer p.Retiring.Store(false)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var size uint64
+	quiet := 0
+	for {
+		th, err := p.DbClient.GetTreeHead(ctx)
+		if err != nil {
+			log.Warning("retirement unable to get tree head: %v", err)
+			quiet = 0
+		} else if th.Size != size {
+			size = th.Size
+			quiet = 0
+		} else {
+			quiet++
+		}
+		if quiet >= retireQuietPolls && p.Stateman.CosignedTreeHead().Size == size {
+			cth, err := p.Finalizer.Finalize(ctx)
+			if err == nil {
+				log.Info("log retired, final tree head size %d", cth.Size)
+				return nil
+			}
+			log.Warning("finalizing tree head failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("retirement interrupted: %v", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
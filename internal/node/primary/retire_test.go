@@ -0,0 +1,108 @@
+package primary
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	mocksDB "sigsum.org/log-go/internal/mocks/db"
+	mocksState "sigsum.org/log-go/internal/mocks/state"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type testFinalizer struct {
+	final bool
+	calls int
+}
+
+func (f *testFinalizer) IsFinal() bool {
+	return f.final
+}
+
+func (f *testFinalizer) Finalize(context.Context) (types.CosignedTreeHead, error) {
+	f.calls++
+	f.final = true
+	return types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 2}}}, nil
+}
+
+func TestRetire(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocksDB.NewMockClient(ctrl)
+	// A queued leaf is sequenced after retirement starts.
+	client.EXPECT().GetTreeHead(gomock.Any()).Return(types.TreeHead{Size: 1}, nil).Times(2)
+	client.EXPECT().GetTreeHead(gomock.Any()).Return(types.TreeHead{Size: 2}, nil).AnyTimes()
+	stateman := mocksState.NewMockStateManager(ctrl)
+	stateman.EXPECT().CosignedTreeHead().Return(
+		types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 2}}}).AnyTimes()
+
+	finalizer := testFinalizer{}
+	node := Primary{
+		DbClient:  client,
+		Stateman:  stateman,
+		ReadOnly:  &atomic.Bool{},
+		Finalizer: &finalizer,
+		Retiring:  &atomic.Bool{},
+	}
+	if got := node.RetirementState(); got != RetirementActive {
+		t.Errorf("unexpected state %q", got)
+	}
+	if started, err := node.BeginRetirement(); err != nil || !started {
+		t.Fatalf("retirement not started: %v", err)
+	}
+	if !node.IsReadOnly() || node.RetirementState() != RetirementRetiring {
+		t.Errorf("unexpected state %q, read-only %v", node.RetirementState(), node.IsReadOnly())
+	}
+	if started, err := node.BeginRetirement(); err != nil || started {
+		t.Errorf("retirement started twice, err %v", err)
+	}
+	if err := node.SetReadOnly(false); api.ErrorStatusCode(err) != http.StatusConflict {
+		t.Errorf("unexpected error when leaving read-only mode: %v", err)
+	}
+	if err := node.FinishRetirement(context.Background(), time.Millisecond); err != nil {
+		t.Fatalf("retirement failed: %v", err)
+	}
+	if finalizer.calls != 1 || node.RetirementState() != RetirementRetired || !node.IsReadOnly() {
+		t.Errorf("unexpected state %q after retirement, read-only %v, finalize calls %d",
+			node.RetirementState(), node.IsReadOnly(), finalizer.calls)
+	}
+	if started, err := node.BeginRetirement(); err != nil || started {
+		t.Errorf("retired log retired again, err %v", err)
+	}
+}
+
+func TestRetireInterrupted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocksDB.NewMockClient(ctrl)
+	client.EXPECT().GetTreeHead(gomock.Any()).Return(types.TreeHead{Size: 2}, nil).AnyTimes()
+	stateman := mocksState.NewMockStateManager(ctrl)
+	// Published tree head never catches up.
+	stateman.EXPECT().CosignedTreeHead().Return(
+		types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 1}}}).AnyTimes()
+
+	finalizer := testFinalizer{}
+	node := Primary{
+		DbClient:  client,
+		Stateman:  stateman,
+		ReadOnly:  &atomic.Bool{},
+		Finalizer: &finalizer,
+		Retiring:  &atomic.Bool{},
+	}
+	if started, err := node.BeginRetirement(); err != nil || !started {
+		t.Fatalf("retirement not started: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := node.FinishRetirement(ctx, time.Millisecond); err == nil {
+		t.Fatalf("retirement succeeded without published tree head")
+	}
+	if finalizer.calls != 0 || node.RetirementState() != RetirementActive || !node.IsReadOnly() {
+		t.Errorf("unexpected state %q after interrupted retirement, read-only %v, finalize calls %d",
+			node.RetirementState(), node.IsReadOnly(), finalizer.calls)
+	}
+}
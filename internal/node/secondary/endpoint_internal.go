@@ -5,17 +5,43 @@ package secondary
 import (
 	"context"
 	"fmt"
+	"net/http"
 
+	"sigsum.org/log-go/internal/freshness"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
 func (s Secondary) GetSecondaryTreeHead(ctx context.Context) (types.SignedTreeHead, error) {
 	log.Debug("handling get-secondary-tree-head request")
+	th, err := s.treeHeadToSign(ctx)
+	if err != nil {
+		return types.SignedTreeHead{}, err
+	}
+	return th.Sign(s.Signer)
+}
+
+// GetFreshSecondaryTreeHead is like GetSecondaryTreeHead, but the
+// response is bound to the primary's nonce, see the freshness package.
+func (s Secondary) GetFreshSecondaryTreeHead(ctx context.Context, nonce *crypto.Hash) (freshness.TreeHead, error) {
+	log.Debug("handling get-fresh-secondary-tree-head request")
+	th, err := s.treeHeadToSign(ctx)
+	if err != nil {
+		return freshness.TreeHead{}, err
+	}
+	return freshness.Sign(s.Signer, &th, nonce)
+}
 
+func (s Secondary) treeHeadToSign(ctx context.Context) (types.TreeHead, error) {
+	if err := s.Alarm.Err(); err != nil {
+		return types.TreeHead{}, api.NewError(http.StatusInternalServerError,
+			fmt.Errorf("refusing to sign, primary presents a split view: %v", err))
+	}
 	th, err := s.DbClient.GetTreeHead(ctx)
 	if err != nil {
-		return types.SignedTreeHead{}, fmt.Errorf("getting tree head: %w", err)
+		return types.TreeHead{}, fmt.Errorf("getting tree head: %w", err)
 	}
-	return th.Sign(s.Signer)
+	return th, nil
 }
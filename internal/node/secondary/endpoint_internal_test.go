@@ -80,3 +80,41 @@ func TestGetSecondaryTreeHead(t *testing.T) {
 		}()
 	}
 }
+
+func TestGetFreshSecondaryTreeHead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	publicKey, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	th := types.TreeHead{Size: 3}
+	trillianClient := db.NewMockClient(ctrl)
+	trillianClient.EXPECT().GetTreeHead(gomock.Any()).Return(th, nil)
+
+	node := Secondary{
+		DbClient: trillianClient,
+		Signer:   signer,
+		Alarm:    &Alarm{},
+	}
+	nonce := crypto.Hash{1}
+	fresh, err := node.GetFreshSecondaryTreeHead(context.Background(), &nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.TreeHead != th {
+		t.Errorf("unexpected tree head %v", fresh.TreeHead)
+	}
+	if err := fresh.Verify(&publicKey, &nonce); err != nil {
+		t.Errorf("verification failed: %v", err)
+	}
+	if err := fresh.Verify(&publicKey, &crypto.Hash{2}); err == nil {
+		t.Errorf("verification with wrong nonce succeeded")
+	}
+
+	node.Alarm.raise(fmt.Errorf("split view"))
+	if _, err := node.GetFreshSecondaryTreeHead(context.Background(), &nonce); err == nil {
+		t.Errorf("tree head signed despite raised alarm")
+	}
+}
@@ -0,0 +1,124 @@
+package secondary
+
+// This file implements a mirror, following a log through its public api.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Mirror follows any sigsum log through its public api, and stores
+// its leaves in a local backend. Each cosigned tree head is verified
+// against the policy before any leaves covered by it are stored, and
+// leaves are verified to be consistent with it. The mirrored log's
+// public read endpoints are served by Replica.
+type Mirror struct {
+	Interval  time.Duration    // Polling frequency
+	DbClient  db.Client        // Local backend, usually Trillian with a PREORDERED_LOG tree
+	Log       api.Log          // The mirrored log's public endpoint
+	LogPub    crypto.PublicKey // The mirrored log's public key
+	Policy    *policy.Policy   // For verifying cosignatures, must include the log
+	BatchSize int              // Number of leaves to request per get-leaves request
+	Replica   *Replica
+}
+
+func (m Mirror) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.update(ctx); err != nil {
+			log.Warning("mirroring failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Verification of the leaves is shared with the secondary.
+func (m Mirror) verifier() Secondary {
+	return Secondary{DbClient: m.DbClient, PrimaryPublic: m.Log, PrimaryPub: m.LogPub}
+}
+
+// Fetches the log's latest tree head, and all leaves it covers that
+// aren't yet stored locally. The served tree head is updated once the
+// local tree has caught up, which may be in a later round, since
+// backends may integrate leaves asynchronously.
+func (m Mirror) update(ctx context.Context) error {
+	curTH, err := m.DbClient.GetTreeHead(ctx)
+	if err != nil {
+		return fmt.Errorf("getting local tree head failed: %v", err)
+	}
+	cth, err := m.Log.GetTreeHead(ctx)
+	if err != nil {
+		return fmt.Errorf("getting log's tree head failed: %v", err)
+	}
+	if !cth.Verify(&m.LogPub) {
+		return fmt.Errorf("invalid log signature on tree head")
+	}
+	logKeyHash := crypto.HashBytes(m.LogPub[:])
+	if err := m.Policy.VerifyCosignedTreeHead(&logKeyHash, &cth); err != nil {
+		return fmt.Errorf("tree head of size %d not accepted by policy: %v", cth.Size, err)
+	}
+	verifier := m.verifier()
+	r, err := verifier.verifyLocalTree(ctx, &curTH, &cth.SignedTreeHead)
+	if err != nil {
+		return fmt.Errorf("REFUSING to mirror, local tree not consistent with log: %v", err)
+	}
+	if curTH.Size >= cth.Size {
+		m.updateReplica(&cth)
+		return nil
+	}
+
+	batchSize := uint64(leavesBatchSize)
+	if m.BatchSize > 0 {
+		batchSize = uint64(m.BatchSize)
+	}
+	// The public get-leaves endpoint refuses requests beyond the
+	// tree head, so ranges are clamped to its size.
+	for next := curTH.Size; next < cth.Size; {
+		req := requests.Leaves{StartIndex: next, EndIndex: next + batchSize}
+		if req.EndIndex > cth.Size {
+			req.EndIndex = cth.Size
+		}
+		leaves, err := m.Log.GetLeaves(ctx, req)
+		if err != nil {
+			return fmt.Errorf("fetching leaves [%d:%d] failed: %v", req.StartIndex, req.EndIndex, err)
+		}
+		if len(leaves) == 0 || uint64(len(leaves)) > req.EndIndex-req.StartIndex {
+			return fmt.Errorf("unexpected leaf count %d when asking for [%d:%d]", len(leaves), req.StartIndex, req.EndIndex)
+		}
+		r, err = verifier.verifyLeaves(ctx, r, &cth.SignedTreeHead, next, leaves)
+		if err != nil {
+			return fmt.Errorf("REFUSING leaves, verification failed: %v", err)
+		}
+		if err := m.DbClient.AddSequencedLeaves(ctx, leaves, int64(next)); err != nil {
+			return fmt.Errorf("storing leaves failed: %v", err)
+		}
+		next += uint64(len(leaves))
+	}
+	log.Debug("mirrored leaves up to size %d", cth.Size)
+
+	if th, err := m.DbClient.GetTreeHead(ctx); err == nil && th.Size >= cth.Size {
+		m.updateReplica(&cth)
+	}
+	return nil
+}
+
+func (m Mirror) updateReplica(cth *types.CosignedTreeHead) {
+	if m.Replica != nil {
+		m.Replica.update(cth)
+	}
+}
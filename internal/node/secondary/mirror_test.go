@@ -0,0 +1,163 @@
+package secondary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Public endpoint of a log, refusing get-leaves requests beyond the
+// published tree head.
+type testMirroredLog struct {
+	testPrimary
+	maxRange uint64
+}
+
+func (l *testMirroredLog) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	if req.StartIndex >= req.EndIndex || req.EndIndex > l.sth.Size {
+		return nil, api.NewError(http.StatusBadRequest, fmt.Errorf("invalid range [%d:%d]", req.StartIndex, req.EndIndex))
+	}
+	if req.EndIndex-req.StartIndex > l.maxRange {
+		req.EndIndex = req.StartIndex + l.maxRange
+	}
+	return l.tree.GetLeaves(ctx, &req)
+}
+
+// Policy accepting the log's tree heads without cosignatures.
+func mustPolicy(t *testing.T, logPub crypto.PublicKey) *policy.Policy {
+	t.Helper()
+	p, err := policy.NewKofNPolicy([]crypto.PublicKey{logPub}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, submitterSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, submitterSigner, 50)
+	logTree := db.NewMemoryDb()
+	publish := func(l *testMirroredLog, n int) {
+		th, err := logTree.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := logTree.AddSequencedLeaves(ctx, leaves[th.Size:n], int64(th.Size)); err != nil {
+			t.Fatal(err)
+		}
+		if th, err = logTree.GetTreeHead(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if l.sth, err = th.Sign(logSigner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := &testMirroredLog{testPrimary: testPrimary{tree: logTree}, maxRange: 7}
+	publish(l, 20)
+
+	localTree := db.NewMemoryDb()
+	m := Mirror{
+		DbClient:  localTree,
+		Log:       l,
+		LogPub:    logPub,
+		Policy:    mustPolicy(t, logPub),
+		BatchSize: 10,
+		Replica:   NewReplica(localTree, 10),
+	}
+	for _, size := range []int{20, 20, 45, 50} {
+		publish(l, size)
+		if err := m.update(ctx); err != nil {
+			t.Fatalf("update to size %d failed: %v", size, err)
+		}
+		th, err := localTree.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if th != l.sth.TreeHead {
+			t.Errorf("unexpected local tree head at size %d: %v", size, th)
+		}
+		if cth, err := m.Replica.GetTreeHead(ctx); err != nil {
+			t.Errorf("replica has no tree head at size %d: %v", size, err)
+		} else if cth.SignedTreeHead != l.sth {
+			t.Errorf("unexpected replica tree head at size %d", size)
+		}
+	}
+
+	// Tree head signed by some other key.
+	_, otherSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.sth, err = l.sth.TreeHead.Sign(otherSigner); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.update(ctx); err == nil {
+		t.Errorf("update accepted tree head with invalid signature")
+	}
+}
+
+func TestMirrorInconsistent(t *testing.T) {
+	ctx := context.Background()
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, submitterSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, submitterSigner, 30)
+	logTree := db.NewMemoryDb()
+	if err := logTree.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := logTree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := th.Sign(logSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Local tree with one leaf differing from the log's.
+	forked := append([]types.Leaf(nil), leaves[:10]...)
+	forked[3] = leaves[20]
+	localTree := db.NewMemoryDb()
+	if err := localTree.AddSequencedLeaves(ctx, forked, 0); err != nil {
+		t.Fatal(err)
+	}
+	m := Mirror{
+		DbClient: localTree,
+		Log:      &testMirroredLog{testPrimary: testPrimary{tree: logTree, sth: sth}, maxRange: 100},
+		LogPub:   logPub,
+		Policy:   mustPolicy(t, logPub),
+		Replica:  NewReplica(localTree, 10),
+	}
+	if err := m.update(ctx); err == nil {
+		t.Errorf("update succeeded with inconsistent local tree")
+	}
+	if th, err := localTree.GetTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	} else if th.Size != 10 {
+		t.Errorf("leaves stored despite inconsistency, size %d", th.Size)
+	}
+	if _, err := m.Replica.GetTreeHead(ctx); err == nil {
+		t.Errorf("replica serving tree head despite inconsistency")
+	}
+}
@@ -0,0 +1,94 @@
+package secondary
+
+// This file implements the public read endpoints of a secondary node.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/node/primary"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Replica serves the log's public read endpoints from the
+// secondary's tree. The served tree head is the primary's latest
+// cosigned tree head, once it has been verified and the secondary's
+// tree has caught up with it. Read requests are handled exactly as by
+// the primary; add-leaf requests are refused.
+type Replica struct {
+	primary.Primary
+	state *replicaState
+}
+
+// Implements state.StateManager, with the tree head updated by the
+// secondary's replication rounds.
+type replicaState struct {
+	sync.RWMutex
+	cth types.CosignedTreeHead
+	ok  bool // Set once a tree head is available
+}
+
+func (s *replicaState) SignedTreeHead() types.SignedTreeHead {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cth.SignedTreeHead
+}
+
+func (s *replicaState) CosignedTreeHead() types.CosignedTreeHead {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cth
+}
+
+func (s *replicaState) Run(context.Context, []policy.Entity, time.Duration) {}
+
+func NewReplica(dbClient db.Client, maxRange int) *Replica {
+	state := &replicaState{}
+	return &Replica{
+		Primary: primary.Primary{
+			MaxRange: maxRange,
+			DbClient: dbClient,
+			Stateman: state,
+		},
+		state: state,
+	}
+}
+
+// Sets the served tree head, which must be verified, and included in
+// the local tree. Never moves backwards.
+func (r *Replica) update(cth *types.CosignedTreeHead) {
+	r.state.Lock()
+	defer r.state.Unlock()
+	if r.state.ok && cth.Size < r.state.cth.Size {
+		return
+	}
+	if !r.state.ok || cth.Size > r.state.cth.Size {
+		log.Debug("replica serving tree head of size %d", cth.Size)
+	}
+	r.state.cth = *cth
+	r.state.ok = true
+}
+
+func (r *Replica) AddLeaf(_ context.Context, _ requests.Leaf, _ *token.SubmitHeader) (bool, error) {
+	return false, api.NewError(http.StatusForbidden, fmt.Errorf("read-only replica, submit leaves to the primary"))
+}
+
+func (r *Replica) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
+	log.Debug("handling get-tree-head request")
+	r.state.RLock()
+	defer r.state.RUnlock()
+	if !r.state.ok {
+		return types.CosignedTreeHead{}, api.NewError(http.StatusServiceUnavailable,
+			fmt.Errorf("replica has no verified tree head yet"))
+	}
+	return r.state.cth, nil
+}
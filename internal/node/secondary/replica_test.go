@@ -0,0 +1,87 @@
+package secondary
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestReplica(t *testing.T) {
+	ctx := context.Background()
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, signer, 8)
+	tree := db.NewMemoryDb()
+	if err := tree.AddSequencedLeaves(ctx, leaves[:5], 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := tree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddSequencedLeaves(ctx, leaves[5:], 5); err != nil {
+		t.Fatal(err)
+	}
+
+	replica := NewReplica(tree, 3)
+	if _, err := replica.GetTreeHead(ctx); api.ErrorStatusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("unexpected error before update: %v", err)
+	}
+	if _, err := replica.AddLeaf(ctx, requests.Leaf{}, nil); api.ErrorStatusCode(err) != http.StatusForbidden {
+		t.Errorf("unexpected add-leaf error: %v", err)
+	}
+
+	replica.update(&types.CosignedTreeHead{SignedTreeHead: sth})
+	if cth, err := replica.GetTreeHead(ctx); err != nil {
+		t.Error(err)
+	} else if cth.SignedTreeHead != sth {
+		t.Errorf("unexpected tree head, size %d", cth.Size)
+	}
+	// Never moves backwards.
+	replica.update(&types.CosignedTreeHead{})
+	if cth, err := replica.GetTreeHead(ctx); err != nil || cth.Size != 5 {
+		t.Errorf("unexpected tree head after update with smaller tree, size %d, err %v", cth.Size, err)
+	}
+
+	if got, err := replica.GetLeaves(ctx, requests.Leaves{StartIndex: 1, EndIndex: 5}); err != nil {
+		t.Error(err)
+	} else if len(got) != 3 || got[0] != leaves[1] {
+		t.Errorf("unexpected leaves: %v", got)
+	}
+	if _, err := replica.GetLeaves(ctx, requests.Leaves{StartIndex: 4, EndIndex: 6}); err == nil {
+		t.Errorf("get-leaves beyond served tree head succeeded")
+	}
+	if proof, err := replica.GetInclusionProof(ctx, requests.InclusionProof{
+		Size:     5,
+		LeafHash: merkle.HashLeafNode(leaves[2].ToBinary()),
+	}); err != nil {
+		t.Error(err)
+	} else if proof.LeafIndex != 2 {
+		t.Errorf("unexpected inclusion proof index %d", proof.LeafIndex)
+	}
+	if _, err := replica.GetInclusionProof(ctx, requests.InclusionProof{
+		Size:     5,
+		LeafHash: merkle.HashLeafNode(leaves[6].ToBinary()),
+	}); api.ErrorStatusCode(err) != http.StatusNotFound {
+		t.Errorf("unexpected error for leaf beyond served tree head: %v", err)
+	}
+	if _, err := replica.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: 2, NewSize: 5}); err != nil {
+		t.Error(err)
+	}
+	if _, err := replica.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: 2, NewSize: 6}); err == nil {
+		t.Errorf("consistency proof beyond served tree head succeeded")
+	}
+}
@@ -6,63 +6,208 @@ import (
 	"time"
 
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
 )
 
 const (
-	leavesBatchSize = 100
+	// Defaults, used if not configured.
+	leavesBatchSize  = 100
+	fetchConcurrency = 1
 )
 
 // Secondary is an instance of a secondary node
 type Secondary struct {
-	Interval time.Duration // Signing frequency
-	DbClient db.Client     // provides access to the backend, usually Trillian
-	Signer   crypto.Signer // provides access to Ed25519 private key
-	Primary  api.Log
+	Interval         time.Duration // Signing frequency
+	DbClient         db.Client     // provides access to the backend, usually Trillian
+	Signer           crypto.Signer // provides access to Ed25519 private key
+	Primary          api.Log       // primary's internal endpoint, for get-leaves
+	BatchSize        int           // Number of leaves to request per get-leaves request
+	FetchConcurrency int           // Maximum number of concurrent get-leaves requests
+	Trigger          chan struct{} // Optional, for triggering a fetch before the next Interval tick
+
+	// Optional verification of replicated leaves, see verifyLeaves.
+	PrimaryPublic api.Log          // primary's public endpoint, nil to not verify tree consistency
+	PrimaryPub    crypto.PublicKey // primary's public key, for tree head signatures
+	SubmitterKeys map[crypto.Hash]crypto.PublicKey
+
+	// Optional, serving public read endpoints. Requires PrimaryPublic.
+	Replica *Replica
+
+	// Optional, raised if the primary's published tree head is
+	// inconsistent with the replicated tree. Requires PrimaryPublic.
+	Alarm *Alarm
+
+	Metrics metrics.ReplicationMetrics // Optional, nil if not configured
+}
+
+func (s Secondary) getMetrics() metrics.ReplicationMetrics {
+	if s.Metrics == nil {
+		return metrics.NoReplicationMetrics{}
+	}
+	return s.Metrics
 }
 
 func (s Secondary) Run(ctx context.Context) {
 	ticker := time.NewTicker(s.Interval)
 	defer ticker.Stop()
 
+	// Start catching up immediately, rather than after the first tick.
+	s.fetchLeavesFromPrimary(ctx)
 	for {
 		select {
 		case <-ticker.C:
 			s.fetchLeavesFromPrimary(ctx)
+		case <-s.Trigger:
+			s.fetchLeavesFromPrimary(ctx)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// NotifyLeaves is called when the primary reports new leaves, and
+// triggers a fetch unless one is already pending. Never blocks.
+func (s Secondary) NotifyLeaves(size uint64) {
+	s.getMetrics().SetPrimarySize(size)
+	select {
+	case s.Trigger <- struct{}{}:
+	default:
+	}
+}
+
+type fetchResult struct {
+	req    requests.Leaves
+	leaves []types.Leaf
+	err    error
+}
+
+// Starts a get-leaves request. The result channel is buffered, so
+// that the request completes also if the result is never read.
+func (s Secondary) fetchLeaves(ctx context.Context, req requests.Leaves) <-chan fetchResult {
+	c := make(chan fetchResult, 1)
+	go func() {
+		leaves, err := s.Primary.GetLeaves(ctx, req)
+		c <- fetchResult{req: req, leaves: leaves, err: err}
+	}()
+	return c
+}
+
+// Fetches leaves until the end of the primary's tree. Requests for
+// disjoint ranges are issued concurrently, while the responses are
+// verified and written to the backend in order. When the primary
+// returns fewer leaves than requested, e.g., due to its max-range
+// setting, the rest of the range is requested next, and the batch
+// size is reduced to the returned count.
 func (s Secondary) fetchLeavesFromPrimary(ctx context.Context) {
+	curTH, err := s.DbClient.GetTreeHead(ctx)
+	if err != nil {
+		s.getMetrics().OnError("backend")
+		log.Warning("unable to get tree head from trillian: %v", err)
+		return
+	}
+	s.getMetrics().SetSecondarySize(curTH.Size)
+	cth, err := s.publishedTreeHead(ctx)
+	if err != nil {
+		s.getMetrics().OnError("primary-tree-head")
+		log.Warning("not replicating, unable to verify primary's tree: %v", err)
+		return
+	}
+	var sth *types.SignedTreeHead
+	if cth != nil {
+		sth = &cth.SignedTreeHead
+	}
+	r, err := s.verifyLocalTree(ctx, &curTH, sth)
+	if errors.Is(err, errSplitView) {
+		s.Alarm.raise(err)
+		s.getMetrics().OnSplitView()
+		log.Error("REFUSING to replicate or sign, primary's published tree head not consistent with local tree: %v", err)
+		return
+	}
+	if err != nil {
+		s.getMetrics().OnError("verification")
+		log.Error("REFUSING to replicate, unable to verify local tree against primary: %v", err)
+		return
+	}
+	if s.Replica != nil && cth != nil && curTH.Size >= cth.Size {
+		s.Replica.update(cth)
+	}
+
+	// Abandons outstanding requests on return.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batchSize := uint64(leavesBatchSize)
+	if s.BatchSize > 0 {
+		batchSize = uint64(s.BatchSize)
+	}
+	concurrency := fetchConcurrency
+	if s.FetchConcurrency > 0 {
+		concurrency = s.FetchConcurrency
+	}
+	next := curTH.Size
+	var pending []<-chan fetchResult
 	for {
-		curTH, err := s.DbClient.GetTreeHead(ctx)
-		if err != nil {
-			log.Warning("unable to get tree head from trillian: %v", err)
-			return
-		}
-		req := requests.Leaves{
-			StartIndex: curTH.Size,
-			EndIndex:   curTH.Size + leavesBatchSize,
+		for len(pending) < concurrency {
+			pending = append(pending, s.fetchLeaves(ctx, requests.Leaves{
+				StartIndex: next,
+				EndIndex:   next + batchSize,
+			}))
+			next += batchSize
 		}
-		leaves, err := s.Primary.GetLeaves(ctx, req)
-		if err != nil {
+		res := <-pending[0]
+		pending = pending[1:]
+
+		req, leaves := res.req, res.leaves
+		if err := res.err; err != nil {
 			if errors.Is(api.ErrNotFound, err) {
 				// Normal way to exit, so don't log at warning level.
 				log.Debug("error fetching leaves [%d:%d] from primary: %v", req.StartIndex, req.EndIndex, err)
+				// Caught up with the primary's tree.
+				s.getMetrics().SetPrimarySize(req.StartIndex)
+				s.getMetrics().OnReplicated(req.StartIndex)
 			} else {
+				s.getMetrics().OnError("fetch")
 				log.Warning("error fetching leaves [%d:%d] from primary: %v", req.StartIndex, req.EndIndex, err)
 			}
 			return
 		}
 		log.Debug("got %d leaves from primary when asking for [%d:%d]", len(leaves), req.StartIndex, req.EndIndex)
+		count := uint64(len(leaves))
+		if count == 0 || count > req.EndIndex-req.StartIndex {
+			s.getMetrics().OnError("fetch")
+			log.Warning("unexpected leaf count %d from primary when asking for [%d:%d]", count, req.StartIndex, req.EndIndex)
+			return
+		}
+		r, err = s.verifyLeaves(ctx, r, sth, req.StartIndex, leaves)
+		if errors.Is(err, errSplitView) {
+			s.Alarm.raise(err)
+			s.getMetrics().OnSplitView()
+			log.Error("REFUSING leaves from primary and signing, leaves not consistent with primary's published tree head: %v", err)
+			return
+		}
+		if err != nil {
+			s.getMetrics().OnError("verification")
+			log.Error("REFUSING leaves from primary, verification failed: %v", err)
+			return
+		}
 		if err := s.DbClient.AddSequencedLeaves(ctx, leaves, int64(req.StartIndex)); err != nil {
+			s.getMetrics().OnError("backend")
 			log.Error("AddSequencedLeaves: %v", err)
 			return
 		}
+		if count < req.EndIndex-req.StartIndex {
+			if count < batchSize {
+				batchSize = count
+			}
+			pending = append([]<-chan fetchResult{s.fetchLeaves(ctx, requests.Leaves{
+				StartIndex: req.StartIndex + count,
+				EndIndex:   req.EndIndex,
+			})}, pending...)
+		}
 	}
 }
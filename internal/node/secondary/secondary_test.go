@@ -3,11 +3,16 @@ package secondary
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/db"
 	mocksDB "sigsum.org/log-go/internal/mocks/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/mocks"
+	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
@@ -59,16 +64,11 @@ func TestFetchLeavesFromPrimary(t *testing.T) {
 
 			trillianClient := mocksDB.NewMockClient(ctrl)
 			trillianClient.EXPECT().GetTreeHead(gomock.Any()).Return(tbl.trillianTHRet, tbl.trillianTHErr)
-			if tbl.trillianTHErr == nil && tbl.primaryGetLeavesErr == nil && tbl.trillianAddLeavesErr == nil {
-				updatedSize := tbl.trillianTHRet.Size + uint64(len(tbl.primaryGetLeavesRet))
-				trillianClient.EXPECT().GetTreeHead(gomock.Any()).Return(
-					types.TreeHead{Size: updatedSize}, tbl.trillianTHErr)
-			}
 
 			if tbl.primaryGetLeavesErr != nil || tbl.primaryGetLeavesRet != nil {
 				primaryClient.EXPECT().GetLeaves(gomock.Any(), gomock.Any()).Return(tbl.primaryGetLeavesRet, tbl.primaryGetLeavesErr)
 				if tbl.trillianAddLeavesExp {
-					// XXX End-of-data condition
+					// Request for the rest of the range, XXX End-of-data condition
 					primaryClient.EXPECT().GetLeaves(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("mocked error"))
 				}
 			}
@@ -90,3 +90,204 @@ func TestFetchLeavesFromPrimary(t *testing.T) {
 		}()
 	}
 }
+
+// Internal endpoint of a primary, backed by a local tree, returning
+// at most maxRange leaves per request.
+type testLeavesPrimary struct {
+	api.Log
+	tree     db.Client
+	maxRange uint64
+}
+
+func (p *testLeavesPrimary) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	th, err := p.tree.GetTreeHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.StartIndex == th.Size {
+		return nil, api.ErrNotFound
+	}
+	if req.StartIndex > th.Size {
+		return nil, api.NewError(http.StatusBadRequest, fmt.Errorf("start_index outside of current tree"))
+	}
+	if req.EndIndex > th.Size {
+		req.EndIndex = th.Size
+	}
+	if req.EndIndex-req.StartIndex > p.maxRange {
+		req.EndIndex = req.StartIndex + p.maxRange
+	}
+	return p.tree.GetLeaves(ctx, &req)
+}
+
+func TestFetchLeavesPipelined(t *testing.T) {
+	ctx := context.Background()
+	primaryPub, primarySigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, submitterSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, submitterSigner, 250)
+	primaryTree := db.NewMemoryDb()
+	if err := primaryTree.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	primaryTH, err := primaryTree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Published tree head, behind the primary's tree.
+	publishedTree := db.NewMemoryDb()
+	if err := publishedTree.AddSequencedLeaves(ctx, leaves[:100], 0); err != nil {
+		t.Fatal(err)
+	}
+	publishedTH, err := publishedTree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := publishedTH.Sign(primarySigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		desc        string
+		maxRange    uint64
+		batchSize   int
+		concurrency int
+		verify      bool
+	}{
+		{"defaults", 250, 0, 0, false},
+		{"max range", 7, 20, 4, false},
+		{"small batches", 100, 3, 8, true},
+		{"verified", 9, 50, 3, true},
+	} {
+		localTree := db.NewMemoryDb()
+		node := Secondary{
+			DbClient:         localTree,
+			Primary:          &testLeavesPrimary{tree: primaryTree, maxRange: table.maxRange},
+			BatchSize:        table.batchSize,
+			FetchConcurrency: table.concurrency,
+		}
+		if table.verify {
+			node.PrimaryPublic = &testPrimary{tree: primaryTree, sth: sth}
+			node.PrimaryPub = primaryPub
+			node.Replica = NewReplica(localTree, 10)
+		}
+		node.fetchLeavesFromPrimary(ctx)
+		if th, err := localTree.GetTreeHead(ctx); err != nil {
+			t.Errorf("%s: %v", table.desc, err)
+		} else if th != primaryTH {
+			t.Errorf("%s: unexpected tree head after fetch, got size %d, want %d", table.desc, th.Size, primaryTH.Size)
+		}
+		if node.Replica != nil {
+			// Served tree head is updated at the start of the next round.
+			node.fetchLeavesFromPrimary(ctx)
+			if cth, err := node.Replica.GetTreeHead(ctx); err != nil {
+				t.Errorf("%s: replica: %v", table.desc, err)
+			} else if cth.SignedTreeHead != sth {
+				t.Errorf("%s: unexpected replica tree head, size %d", table.desc, cth.Size)
+			}
+		}
+	}
+}
+
+func TestSplitView(t *testing.T) {
+	ctx := context.Background()
+	primaryPub, primarySigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, submitterSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, submitterSigner, 200)
+	primaryTree := db.NewMemoryDb()
+	if err := primaryTree.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The tree shown to clients, with one leaf replaced.
+	forkedLeaves := append([]types.Leaf(nil), leaves[:100]...)
+	forkedLeaves[10] = leaves[150]
+	forkedTree := db.NewMemoryDb()
+	if err := forkedTree.AddSequencedLeaves(ctx, forkedLeaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	mustSign := func(tree db.Client) types.SignedTreeHead {
+		th, err := tree.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sth, err := th.Sign(primarySigner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sth
+	}
+	publishedTree := db.NewMemoryDb()
+	if err := publishedTree.AddSequencedLeaves(ctx, leaves[:100], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		desc      string
+		localSize int
+		published *testPrimary
+		wantAlarm bool
+	}{
+		{"consistent, behind", 50, &testPrimary{tree: publishedTree, sth: mustSign(publishedTree)}, false},
+		{"consistent, ahead", 150, &testPrimary{tree: publishedTree, sth: mustSign(publishedTree)}, false},
+		// Detected when verifying the fetched leaves.
+		{"split, before forked leaf", 5, &testPrimary{tree: forkedTree, sth: mustSign(forkedTree)}, true},
+		{"split, behind", 50, &testPrimary{tree: forkedTree, sth: mustSign(forkedTree)}, true},
+		{"split, same size", 100, &testPrimary{tree: forkedTree, sth: mustSign(forkedTree)}, true},
+		{"split, ahead", 150, &testPrimary{tree: forkedTree, sth: mustSign(forkedTree)}, true},
+	} {
+		localTree := db.NewMemoryDb()
+		if err := localTree.AddSequencedLeaves(ctx, leaves[:table.localSize], 0); err != nil {
+			t.Fatal(err)
+		}
+		_, signer, err := crypto.NewKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		node := Secondary{
+			DbClient:      localTree,
+			Signer:        signer,
+			Primary:       &testLeavesPrimary{tree: primaryTree, maxRange: 100},
+			PrimaryPublic: table.published,
+			PrimaryPub:    primaryPub,
+			Alarm:         &Alarm{},
+		}
+		node.fetchLeavesFromPrimary(ctx)
+		th, err := localTree.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, signErr := node.GetSecondaryTreeHead(ctx)
+		if !table.wantAlarm {
+			if err := node.Alarm.Err(); err != nil {
+				t.Errorf("%s: unexpected alarm: %v", table.desc, err)
+			}
+			if signErr != nil {
+				t.Errorf("%s: signing failed: %v", table.desc, signErr)
+			}
+			if th.Size != 200 {
+				t.Errorf("%s: unexpected size after fetch: %d", table.desc, th.Size)
+			}
+			continue
+		}
+		if node.Alarm.Err() == nil {
+			t.Errorf("%s: split view not detected", table.desc)
+		}
+		if signErr == nil {
+			t.Errorf("%s: tree head signed despite split view", table.desc)
+		}
+		if th.Size != uint64(table.localSize) {
+			t.Errorf("%s: leaves replicated despite split view, size %d", table.desc, th.Size)
+		}
+	}
+}
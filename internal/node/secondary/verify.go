@@ -0,0 +1,231 @@
+package secondary
+
+// This file implements verification of leaves replicated from the primary.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Wrapped by errors showing that the primary's published tree head
+// is inconsistent with the leaves it has replicated to the secondary,
+// i.e., that the primary presents different views of the log.
+var errSplitView = errors.New("SPLIT VIEW")
+
+// Alarm records detection of a split view. Once raised, the alarm
+// stays raised until the secondary is restarted, and the secondary
+// refuses to sign any tree head. A nil *Alarm is never raised.
+type Alarm struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (a *Alarm) raise(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// Err returns the error that raised the alarm, or nil.
+func (a *Alarm) Err() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Root hashes of the perfect subtrees that make up a tree, largest
+// first. Sufficient to compute the root hash of the tree extended
+// with additional leaves.
+type compactRange struct {
+	size   uint64
+	hashes []crypto.Hash
+}
+
+// Initializes a compact range from the local tree. The inclusion
+// path of the last leaf consists of the siblings within the smallest
+// perfect subtree, followed by the roots of the larger subtrees.
+func newCompactRange(ctx context.Context, client db.Client, th *types.TreeHead) (compactRange, error) {
+	if th.Size == 0 {
+		return compactRange{}, nil
+	}
+	leaves, err := client.GetLeaves(ctx, &requests.Leaves{StartIndex: th.Size - 1, EndIndex: th.Size})
+	if err != nil {
+		return compactRange{}, err
+	}
+	if len(leaves) != 1 {
+		return compactRange{}, fmt.Errorf("unexpected leaf count %d", len(leaves))
+	}
+	hash := merkle.HashLeafNode(leaves[0].ToBinary())
+	proof, err := client.GetInclusionProof(ctx, &requests.InclusionProof{Size: th.Size, LeafHash: hash})
+	if err != nil {
+		return compactRange{}, err
+	}
+	inner := bits.TrailingZeros64(th.Size)
+	if proof.LeafIndex != th.Size-1 || len(proof.Path) != inner+bits.OnesCount64(th.Size)-1 {
+		return compactRange{}, fmt.Errorf("unexpected inclusion proof for last leaf")
+	}
+	for _, sibling := range proof.Path[:inner] {
+		hash = merkle.HashInteriorNode(&sibling, &hash)
+	}
+	r := compactRange{size: th.Size}
+	for i := len(proof.Path) - 1; i >= inner; i-- {
+		r.hashes = append(r.hashes, proof.Path[i])
+	}
+	r.hashes = append(r.hashes, hash)
+	if r.rootHash() != th.RootHash {
+		return compactRange{}, fmt.Errorf("root hash of local tree doesn't match its inclusion proof")
+	}
+	return r, nil
+}
+
+func (r *compactRange) append(hash crypto.Hash) {
+	for size := r.size; size&1 == 1; size >>= 1 {
+		left := r.hashes[len(r.hashes)-1]
+		r.hashes = r.hashes[:len(r.hashes)-1]
+		hash = merkle.HashInteriorNode(&left, &hash)
+	}
+	r.hashes = append(r.hashes, hash)
+	r.size++
+}
+
+func (r *compactRange) rootHash() crypto.Hash {
+	if len(r.hashes) == 0 {
+		return merkle.HashEmptyTree()
+	}
+	root := r.hashes[len(r.hashes)-1]
+	for i := len(r.hashes) - 2; i >= 0; i-- {
+		root = merkle.HashInteriorNode(&r.hashes[i], &root)
+	}
+	return root
+}
+
+// Returns the primary's published tree head, after verifying the
+// log's signature, or nil if tree verification isn't configured.
+// Cosignatures are not verified.
+func (s Secondary) publishedTreeHead(ctx context.Context) (*types.CosignedTreeHead, error) {
+	if s.PrimaryPublic == nil {
+		return nil, nil
+	}
+	cth, err := s.PrimaryPublic.GetTreeHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting primary's tree head failed: %v", err)
+	}
+	if !cth.Verify(&s.PrimaryPub) {
+		return nil, fmt.Errorf("invalid signature on primary's tree head")
+	}
+	return &cth, nil
+}
+
+// Checks that the local tree curTH is consistent with the primary's
+// published tree head, if any, and returns the compact range of the
+// local tree, for verifying leaves appended to it. Errors that show
+// the trees to be inconsistent wrap errSplitView.
+func (s Secondary) verifyLocalTree(ctx context.Context, curTH *types.TreeHead, sth *types.SignedTreeHead) (compactRange, error) {
+	if sth == nil {
+		return compactRange{}, nil
+	}
+	if curTH.Size == sth.Size && curTH.RootHash != sth.RootHash {
+		return compactRange{}, fmt.Errorf("%w: local tree of size %d doesn't match primary's tree head", errSplitView, curTH.Size)
+	}
+	if curTH.Size < sth.Size && curTH.Size > 0 {
+		proof, err := s.PrimaryPublic.GetConsistencyProof(ctx, requests.ConsistencyProof{
+			OldSize: curTH.Size,
+			NewSize: sth.Size,
+		})
+		if err != nil {
+			return compactRange{}, fmt.Errorf("getting consistency proof from primary failed: %v", err)
+		}
+		if err := proof.Verify(curTH, &sth.TreeHead); err != nil {
+			return compactRange{}, fmt.Errorf("%w: local tree of size %d not consistent with primary's tree head of size %d: %v",
+				errSplitView, curTH.Size, sth.Size, err)
+		}
+	}
+	if curTH.Size > sth.Size && sth.Size > 0 {
+		proof, err := s.DbClient.GetConsistencyProof(ctx, &requests.ConsistencyProof{
+			OldSize: sth.Size,
+			NewSize: curTH.Size,
+		})
+		if err != nil {
+			return compactRange{}, fmt.Errorf("getting local consistency proof failed: %v", err)
+		}
+		if err := proof.Verify(&sth.TreeHead, curTH); err != nil {
+			return compactRange{}, fmt.Errorf("%w: local tree of size %d not consistent with primary's tree head of size %d: %v",
+				errSplitView, curTH.Size, sth.Size, err)
+		}
+	}
+	r, err := newCompactRange(ctx, s.DbClient, curTH)
+	if err != nil {
+		return compactRange{}, fmt.Errorf("reading local tree failed: %v", err)
+	}
+	return r, nil
+}
+
+// Verifies a batch of leaves, to be appended to the tree r, before
+// they are stored. Leaf signatures are verified if the submitter's
+// key is known, since a leaf includes only the key hash. If sth, the
+// primary's published tree head, is non-nil, the extended tree must
+// be consistent with it. Leaves beyond sth can't be checked against
+// any tree head, but then sth is a prefix of the extended tree. Such
+// leaves are stored with only their signatures checked, since the
+// primary can't publish them before they are replicated; the next
+// round's verifyLocalTree checks them against the next published
+// tree head, and raises the alarm if they don't match. Errors showing
+// that the leaves served by the primary don't match its published
+// tree head wrap errSplitView. The first leaf has index start, which
+// equals the size of r if sth is non-nil. Returns the compact range
+// of the extended tree.
+func (s Secondary) verifyLeaves(ctx context.Context, r compactRange, sth *types.SignedTreeHead,
+	start uint64, leaves []types.Leaf) (compactRange, error) {
+	for i, leaf := range leaves {
+		if pub, ok := s.SubmitterKeys[leaf.KeyHash]; ok && !leaf.Verify(&pub) {
+			return compactRange{}, fmt.Errorf("invalid signature on leaf %d", start+uint64(i))
+		}
+	}
+	if sth == nil {
+		return r, nil
+	}
+	if r.size != start {
+		return compactRange{}, fmt.Errorf("leaves at index %d don't extend local tree of size %d", start, r.size)
+	}
+	// Don't modify the caller's hashes.
+	r.hashes = append([]crypto.Hash(nil), r.hashes...)
+	for _, leaf := range leaves {
+		r.append(merkle.HashLeafNode(leaf.ToBinary()))
+		if r.size == sth.Size && r.rootHash() != sth.RootHash {
+			return compactRange{}, fmt.Errorf("%w: leaves [%d:%d] don't match primary's tree head", errSplitView, start, r.size)
+		}
+	}
+	if r.size >= sth.Size {
+		return r, nil
+	}
+	th := types.TreeHead{Size: r.size, RootHash: r.rootHash()}
+	proof, err := s.PrimaryPublic.GetConsistencyProof(ctx, requests.ConsistencyProof{
+		OldSize: th.Size,
+		NewSize: sth.Size,
+	})
+	if err != nil {
+		return compactRange{}, fmt.Errorf("getting consistency proof from primary failed: %v", err)
+	}
+	if err := proof.Verify(&th, &sth.TreeHead); err != nil {
+		return compactRange{}, fmt.Errorf("%w: leaves [%d:%d] not consistent with primary's tree head of size %d: %v",
+			errSplitView, start, th.Size, sth.Size, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,239 @@
+package secondary
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Public endpoint of a primary, backed by a local tree.
+type testPrimary struct {
+	api.Log
+	tree db.Client
+	sth  types.SignedTreeHead
+}
+
+func (p *testPrimary) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
+	return types.CosignedTreeHead{SignedTreeHead: p.sth}, nil
+}
+
+func (p *testPrimary) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	return p.tree.GetConsistencyProof(ctx, &req)
+}
+
+func mustSignedLeaves(t *testing.T, signer crypto.Signer, n int) []types.Leaf {
+	t.Helper()
+	leaves := make([]types.Leaf, n)
+	for i := range leaves {
+		msg := crypto.Hash{byte(i), byte(i >> 8)}
+		sig, err := types.SignLeafMessage(signer, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves[i], err = (&requests.Leaf{Message: msg, Signature: sig, PublicKey: signer.Public()}).Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return leaves
+}
+
+func TestCompactRange(t *testing.T) {
+	ctx := context.Background()
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, signer, 20)
+	tree := db.NewMemoryDb()
+	var r compactRange
+	for i, leaf := range leaves {
+		if err := tree.AddSequencedLeaves(ctx, []types.Leaf{leaf}, int64(i)); err != nil {
+			t.Fatal(err)
+		}
+		th, err := tree.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.append(merkle.HashLeafNode(leaf.ToBinary()))
+		if r.size != th.Size || r.rootHash() != th.RootHash {
+			t.Errorf("unexpected root hash for size %d", th.Size)
+		}
+		fromTree, err := newCompactRange(ctx, tree, &th)
+		if err != nil {
+			t.Errorf("size %d: %v", th.Size, err)
+		} else if fromTree.rootHash() != th.RootHash {
+			t.Errorf("unexpected root hash of compact range from tree of size %d", th.Size)
+		}
+	}
+}
+
+func TestCompactRangeSingleLeaf(t *testing.T) {
+	ctx := context.Background()
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, signer, 3)
+	tree := db.NewMemoryDb()
+	if err := tree.AddSequencedLeaves(ctx, leaves[:1], 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := tree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newCompactRange(ctx, tree, &th)
+	if err != nil {
+		t.Fatalf("tree of size 1: %v", err)
+	}
+	if r.size != 1 || r.rootHash() != th.RootHash {
+		t.Fatalf("unexpected compact range for tree of size 1")
+	}
+	// The tree can be extended from the compact range.
+	for _, leaf := range leaves[1:] {
+		r.append(merkle.HashLeafNode(leaf.ToBinary()))
+	}
+	if err := tree.AddSequencedLeaves(ctx, leaves[1:], 1); err != nil {
+		t.Fatal(err)
+	}
+	if th, err = tree.GetTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r.rootHash() != th.RootHash {
+		t.Errorf("unexpected root hash after extending tree of size 1")
+	}
+	// A mismatching tree head is detected.
+	th = types.TreeHead{Size: 1, RootHash: crypto.Hash{1}}
+	if _, err := newCompactRange(ctx, tree, &th); err == nil {
+		t.Errorf("mismatching tree head of size 1 not detected")
+	}
+}
+
+func TestVerifyLeaves(t *testing.T) {
+	ctx := context.Background()
+	primaryPub, primarySigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitterPub, submitterSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := mustSignedLeaves(t, submitterSigner, 20)
+	primaryTree := db.NewMemoryDb()
+	if err := primaryTree.AddSequencedLeaves(ctx, leaves[:15], 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := primaryTree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := th.Sign(primarySigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leaves beyond the published tree head.
+	if err := primaryTree.AddSequencedLeaves(ctx, leaves[15:], 15); err != nil {
+		t.Fatal(err)
+	}
+	badLeaf := leaves[7]
+	badLeaf.Signature[3] ^= 1
+
+	for _, table := range []struct {
+		desc      string
+		localSize int
+		batch     []types.Leaf
+		keys      map[crypto.Hash]crypto.PublicKey
+		wantCount int // Number of leaves accepted, -1 for error
+	}{
+		{"from empty", 0, leaves[:5], nil, 5},
+		{"within published", 3, leaves[3:10], nil, 7},
+		{"up to published", 10, leaves[10:15], nil, 5},
+		{"beyond published", 10, leaves[10:20], nil, 10},
+		{"after published", 15, leaves[15:20], nil, 5},
+		{"known keys", 5, leaves[5:10], map[crypto.Hash]crypto.PublicKey{crypto.HashBytes(submitterPub[:]): submitterPub}, 5},
+		{"bad signature, unknown key", 5, append(append(leaves[5:7:7], badLeaf), leaves[8:10]...), nil, -1},
+		{"bad signature, known key", 16, []types.Leaf{badLeaf},
+			map[crypto.Hash]crypto.PublicKey{crypto.HashBytes(submitterPub[:]): submitterPub}, -1},
+		{"wrong order", 3, []types.Leaf{leaves[4], leaves[3]}, nil, -1},
+		{"replaced leaf", 12, []types.Leaf{leaves[12], leaves[0], leaves[14]}, nil, -1},
+	} {
+		localTree := db.NewMemoryDb()
+		if err := localTree.AddSequencedLeaves(ctx, leaves[:table.localSize], 0); err != nil {
+			t.Fatal(err)
+		}
+		curTH, err := localTree.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := Secondary{
+			DbClient:      localTree,
+			PrimaryPublic: &testPrimary{tree: primaryTree, sth: sth},
+			PrimaryPub:    primaryPub,
+			SubmitterKeys: table.keys,
+		}
+		published, err := s.publishedTreeHead(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", table.desc, err)
+		}
+		r, err := s.verifyLocalTree(ctx, &curTH, &published.SignedTreeHead)
+		if err != nil {
+			t.Fatalf("%s: %v", table.desc, err)
+		}
+		extended, err := s.verifyLeaves(ctx, r, &published.SignedTreeHead, uint64(table.localSize), table.batch)
+		if table.wantCount < 0 {
+			if err == nil {
+				t.Errorf("%s: bad leaves accepted", table.desc)
+			}
+		} else if err != nil {
+			t.Errorf("%s: verification failed: %v", table.desc, err)
+		} else if got, want := extended.size, uint64(table.localSize+table.wantCount); got != want {
+			t.Errorf("%s: unexpected size, got %d, want %d", table.desc, got, want)
+		} else if r.size != uint64(table.localSize) || r.rootHash() != curTH.RootHash {
+			t.Errorf("%s: compact range of local tree was modified", table.desc)
+		}
+	}
+
+	// Without tree verification, errors still name the right leaf.
+	s := Secondary{
+		DbClient:      db.NewMemoryDb(),
+		SubmitterKeys: map[crypto.Hash]crypto.PublicKey{crypto.HashBytes(submitterPub[:]): submitterPub},
+	}
+	if _, err := s.verifyLeaves(ctx, compactRange{}, nil, 7, []types.Leaf{badLeaf}); err == nil {
+		t.Errorf("bad leaf accepted without tree verification")
+	} else if !strings.Contains(err.Error(), "leaf 7") {
+		t.Errorf("unexpected error for bad leaf: %v", err)
+	}
+
+	// A local tree that diverges from the primary's.
+	localTree := db.NewMemoryDb()
+	if err := localTree.AddSequencedLeaves(ctx, append(append(leaves[:4:4], leaves[5]), leaves[4]), 0); err != nil {
+		t.Fatal(err)
+	}
+	curTH, err := localTree.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = Secondary{DbClient: localTree, PrimaryPublic: &testPrimary{tree: primaryTree, sth: sth}, PrimaryPub: primaryPub}
+	smallSth, err := (&types.TreeHead{Size: 5, RootHash: crypto.Hash{1}}).Sign(primarySigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.verifyLocalTree(ctx, &curTH, &smallSth); err == nil {
+		t.Errorf("diverging local tree accepted")
+	}
+
+	// Tree head with invalid signature.
+	s.PrimaryPub = submitterPub
+	if _, err := s.publishedTreeHead(ctx); err == nil {
+		t.Errorf("tree head with invalid signature accepted")
+	}
+}
@@ -0,0 +1,184 @@
+// Package nodeauth implements authentication of requests between
+// primary and secondary node, on their internal endpoints. Requests
+// are signed with the sending node's Ed25519 key, and verified by the
+// receiving node with the public key it already has configured.
+//
+// The signed message is
+//
+//	sigsum.org/v1/internal-request
+//	<method>
+//	<request uri, i.e., path and query>
+//	<unix time>
+//	<hex-encoded sha256 hash of the request body>
+//
+// with each line terminated by a newline character. The time and the
+// hex-encoded signature are carried in the Sigsum-Node-Timestamp and
+// Sigsum-Node-Signature headers. Requests whose time differs from the
+// receiver's clock by more than MaxClockSkew are rejected, which
+// bounds the window for replay. Within the window, a captured request
+// can be replayed to a handler created by NewHandler, so it must only
+// be used for requests where that is harmless, such as the
+// idempotent requests between primary and secondary. Handlers for
+// other requests, e.g., admin requests changing the log's state, must
+// be created with NewReplayProtectedHandler, which also rejects
+// requests it has already accepted.
+package nodeauth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+const (
+	TimestampHeader = "Sigsum-Node-Timestamp"
+	SignatureHeader = "Sigsum-Node-Signature"
+
+	MaxClockSkew = 5 * time.Minute
+
+	namespace = "sigsum.org/v1/internal-request"
+	// Internal requests have tiny bodies.
+	maxBodySize = 1 << 16
+)
+
+func signedData(method, uri string, timestamp int64, body []byte) []byte {
+	bodyHash := crypto.HashBytes(body)
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%d\n%x\n", namespace, method, uri, timestamp, bodyHash[:]))
+}
+
+// Transport signs each request before passing it on to Base.
+type Transport struct {
+	Signer crypto.Signer
+	Base   http.RoundTripper // If nil, http.DefaultTransport is used
+	now    func() time.Time
+}
+
+// NewClient returns an http client that signs all requests.
+func NewClient(signer crypto.Signer) *http.Client {
+	return &http.Client{Transport: &Transport{Signer: signer}}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	timestamp := now().Unix()
+	sig, err := t.Signer.Sign(signedData(req.Method, req.URL.RequestURI(), timestamp, body))
+	if err != nil {
+		return nil, fmt.Errorf("signing request failed: %w", err)
+	}
+
+	// A RoundTripper must not modify the original request.
+	req = req.Clone(req.Context())
+	if req.Body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, fmt.Sprintf("%x", sig[:]))
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+type handler struct {
+	pub     crypto.PublicKey
+	handler http.Handler
+	now     func() time.Time
+	seen    *seenRequests // Nil if replay is allowed
+}
+
+// NewHandler returns a handler that passes on only requests signed by
+// the given key, and rejects other requests with status 401.
+func NewHandler(pub *crypto.PublicKey, h http.Handler) http.Handler {
+	return &handler{pub: *pub, handler: h, now: time.Now}
+}
+
+// NewReplayProtectedHandler is like NewHandler, but also rejects any
+// request, other than GET, with the same signature as a request it
+// has accepted before. Accepted signatures are remembered only in memory, until
+// their timestamp is too old to be accepted anyway, so a request can
+// still be replayed within MaxClockSkew after a restart.
+func NewReplayProtectedHandler(pub *crypto.PublicKey, h http.Handler) http.Handler {
+	return &handler{pub: *pub, handler: h, now: time.Now,
+		seen: &seenRequests{expiry: make(map[crypto.Signature]time.Time)}}
+}
+
+// Signatures of accepted requests, and when they expire.
+type seenRequests struct {
+	mu     sync.Mutex
+	expiry map[crypto.Signature]time.Time
+}
+
+// Records the signature, and returns false if it was already seen.
+func (s *seenRequests) add(sig *crypto.Signature, expiry, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for seen, e := range s.expiry {
+		if now.After(e) {
+			delete(s.expiry, seen)
+		}
+	}
+	if _, ok := s.expiry[*sig]; ok {
+		return false
+	}
+	s.expiry[*sig] = expiry
+	return true
+}
+
+func (h *handler) verify(r *http.Request) error {
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid or missing %s header", TimestampHeader)
+	}
+	if skew := h.now().Sub(time.Unix(timestamp, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("request time is off by %v", skew)
+	}
+	sig, err := crypto.SignatureFromHex(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return fmt.Errorf("invalid or missing %s header", SignatureHeader)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("reading request body failed: %v", err)
+	}
+	if len(body) > maxBodySize {
+		return fmt.Errorf("request body too large")
+	}
+	if !crypto.Verify(&h.pub, signedData(r.Method, r.URL.RequestURI(), timestamp, body), &sig) {
+		return fmt.Errorf("invalid request signature")
+	}
+	if h.seen != nil && r.Method != http.MethodGet && !h.seen.add(&sig, time.Unix(timestamp, 0).Add(MaxClockSkew), h.now()) {
+		return fmt.Errorf("replayed request")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.verify(r); err != nil {
+		log.Debug("rejecting unauthenticated request for %s: %v", r.URL.Path, err)
+		http.Error(w, fmt.Sprintf("authentication failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
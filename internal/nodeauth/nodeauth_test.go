@@ -0,0 +1,157 @@
+package nodeauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func TestAuthentication(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(&pub, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("reading body failed: %v", err)
+			}
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), body)
+		})))
+	defer server.Close()
+
+	now := time.Now()
+	for _, table := range []struct {
+		desc      string
+		transport http.RoundTripper
+		method    string
+		body      string
+		wantAuth  bool
+	}{
+		{"get", &Transport{Signer: signer}, http.MethodGet, "", true},
+		{"post", &Transport{Signer: signer}, http.MethodPost, "size=5\n", true},
+		{"unsigned", http.DefaultTransport, http.MethodGet, "", false},
+		{"wrong key", &Transport{Signer: otherSigner}, http.MethodGet, "", false},
+		{"old", &Transport{Signer: signer, now: func() time.Time { return now.Add(-2 * MaxClockSkew) }},
+			http.MethodGet, "", false},
+		{"future", &Transport{Signer: signer, now: func() time.Time { return now.Add(2 * MaxClockSkew) }},
+			http.MethodGet, "", false},
+		{"modified body", &Transport{Signer: signer, Base: modifyingTransport{}}, http.MethodPost, "size=5\n", false},
+	} {
+		var body io.Reader
+		if len(table.body) > 0 {
+			body = strings.NewReader(table.body)
+		}
+		req, err := http.NewRequest(table.method, server.URL+"/foo/get-leaves/1/2?x=y", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := (&http.Client{Transport: table.transport}).Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", table.desc, err)
+		}
+		data, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !table.wantAuth {
+			if rsp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("%s: unexpected status %d, expected %d", table.desc, rsp.StatusCode, http.StatusUnauthorized)
+			}
+			continue
+		}
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("%s: unexpected status %d: %q", table.desc, rsp.StatusCode, data)
+		} else if got, want := string(data), table.method+" /foo/get-leaves/1/2?x=y "+table.body; got != want {
+			t.Errorf("%s: unexpected response, got %q, want %q", table.desc, got, want)
+		}
+	}
+}
+
+// Replaces the request body after it has been signed.
+type modifyingTransport struct{}
+
+func (modifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Body = io.NopCloser(strings.NewReader("size=6\n"))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestReplayProtection(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	h := NewReplayProtectedHandler(&pub, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.(*handler).now = func() time.Time { return now }
+
+	// Captures the signed request, instead of sending it.
+	var captured *http.Request
+	transport := &Transport{Signer: signer, Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		captured = req
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	send := func(desc string, wantStatus int) {
+		body, err := io.ReadAll(captured.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(captured.Method, captured.URL.RequestURI(), strings.NewReader(string(body)))
+		req.Header = captured.Header.Clone()
+		captured.Body = io.NopCloser(strings.NewReader(string(body)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != wantStatus {
+			t.Errorf("%s: unexpected status %d, expected %d", desc, w.Code, wantStatus)
+		}
+	}
+	sign := func() {
+		req, err := http.NewRequest(http.MethodPost, "http://example.org/foo/read-only", strings.NewReader("read_only=0\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&http.Client{Transport: transport}).Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transport.now = func() time.Time { return now }
+	sign()
+	send("first", http.StatusOK)
+	send("replayed", http.StatusUnauthorized)
+
+	// A new request, with a different timestamp, is accepted.
+	transport.now = func() time.Time { return now.Add(time.Second) }
+	sign()
+	send("new", http.StatusOK)
+
+	// Old requests are rejected based on their timestamp, so their
+	// signatures are forgotten.
+	later := now.Add(MaxClockSkew + 2*time.Second)
+	h.(*handler).now = func() time.Time { return later }
+	send("expired", http.StatusUnauthorized)
+	transport.now = func() time.Time { return later }
+	sign()
+	send("later", http.StatusOK)
+	if n := len(h.(*handler).seen.expiry); n != 1 {
+		t.Errorf("expired signatures not forgotten, %d remembered", n)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,154 @@
+// Package notify implements the internal notify-leaves endpoint, used
+// by the primary to tell its secondary that new leaves are available,
+// so that the secondary can fetch them without waiting for its next
+// polling interval.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Endpoint name, relative to the secondary's internal url prefix.
+// The request body is "size=<tree size>\n", in sigsum's ascii format.
+const Endpoint = "notify-leaves"
+
+// Client posts notifications to a secondary's internal endpoint.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the secondary with the given base
+// url, i.e., the same url as used for get-secondary-tree-head. If
+// httpClient is nil, a default client is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{
+		url:        strings.TrimSuffix(url, "/") + "/" + Endpoint,
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) Notify(ctx context.Context, size uint64) error {
+	var body bytes.Buffer
+	if err := ascii.WriteInt(&body, "size", size); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Sigsum log-go server")
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notify-leaves failed: %s", rsp.Status)
+	}
+	return nil
+}
+
+// NewHandler returns a handler for notifications, calling notify
+// with the size of the primary's tree.
+func NewHandler(notify func(size uint64)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p := ascii.NewParser(http.MaxBytesReader(w, r.Body, 100))
+		size, err := p.GetInt("size")
+		if err == nil {
+			err = p.GetEOF()
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		log.Debug("primary notified new tree size %d", size)
+		notify(size)
+	})
+}
+
+// Notifier watches the primary's tree, and notifies the secondary
+// when it has grown. The tree is checked every interval, and on
+// Kick. Polling is needed since backends may sequence leaves
+// asynchronously, but the local tree head is cheap to read.
+type Notifier struct {
+	dbClient db.Client
+	client   *Client
+	interval time.Duration
+	kick     chan struct{}
+}
+
+func NewNotifier(dbClient db.Client, client *Client, interval time.Duration) *Notifier {
+	return &Notifier{
+		dbClient: dbClient,
+		client:   client,
+		interval: interval,
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+// Kick requests that the tree is checked immediately, e.g., after a
+// leaf was added. Never blocks.
+func (n *Notifier) Kick() {
+	select {
+	case n.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	var notifiedSize uint64
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.kick:
+		}
+		th, err := n.dbClient.GetTreeHead(ctx)
+		if err != nil {
+			log.Debug("notifier unable to get tree head: %v", err)
+			continue
+		}
+		if th.Size <= notifiedSize {
+			continue
+		}
+		notifyCtx, cancel := context.WithTimeout(ctx, n.interval)
+		err = n.client.Notify(notifyCtx, th.Size)
+		cancel()
+		if err != nil {
+			// Log only the first of consecutive failures, the
+			// secondary may well be down for a while.
+			if !failing {
+				log.Warning("notifying secondary of tree size %d failed: %v", th.Size, err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		notifiedSize = th.Size
+	}
+}
@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestHandler(t *testing.T) {
+	var got []uint64
+	handler := NewHandler(func(size uint64) { got = append(got, size) })
+	for _, table := range []struct {
+		method string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "size=17\n", http.StatusOK},
+		{http.MethodGet, "size=17\n", http.StatusMethodNotAllowed},
+		{http.MethodPost, "size=x\n", http.StatusBadRequest},
+		{http.MethodPost, "size=17\nsize=18\n", http.StatusBadRequest},
+		{http.MethodPost, "", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(table.method, "/"+Endpoint, strings.NewReader(table.body)))
+		if w.Code != table.status {
+			t.Errorf("%s %q: unexpected status, got %d, want %d", table.method, table.body, w.Code, table.status)
+		}
+	}
+	if len(got) != 1 || got[0] != 17 {
+		t.Errorf("unexpected notifications: %v", got)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifications := make(chan uint64, 10)
+	mux := http.NewServeMux()
+	mux.Handle("/test/"+Endpoint, NewHandler(func(size uint64) { notifications <- size }))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tree := db.NewMemoryDb()
+	// Long interval, so that notifications are due to Kick.
+	notifier := NewNotifier(tree, NewClient(server.URL+"/test/", nil), time.Hour)
+	done := make(chan struct{})
+	go func() {
+		notifier.Run(ctx)
+		close(done)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		if _, err := tree.AddLeaf(ctx, &types.Leaf{KeyHash: [32]byte{byte(i)}}, 0); err != nil {
+			t.Fatal(err)
+		}
+		notifier.Kick()
+		select {
+		case size := <-notifications:
+			if size != uint64(i) {
+				t.Errorf("unexpected notified size, got %d, want %d", size, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification for size %d", i)
+		}
+	}
+	// No notification if tree hasn't grown.
+	notifier.Kick()
+	select {
+	case size := <-notifications:
+		t.Errorf("unexpected notification for size %d", size)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	<-done
+}
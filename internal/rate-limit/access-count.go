@@ -17,14 +17,15 @@ func (c *accessCounts) GetAccessCount(key string) int {
 	return c.counts[key]
 }
 
-func (c *accessCounts) AccessAllowed(key string, limit int) func() {
+// Also returns the number of remaining accesses.
+func (c *accessCounts) AccessAllowed(key string, limit int) (func(), int) {
 	c.Lock()
 	defer c.Unlock()
 	if c.counts[key] >= limit {
-		return nil
+		return nil, 0
 	}
 	c.counts[key]++
-	return func() { c.accessRelax(key) }
+	return func() { c.accessRelax(key) }, limit - c.counts[key]
 }
 
 func (c *accessCounts) accessRelax(key string) {
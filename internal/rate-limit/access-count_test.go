@@ -14,19 +14,24 @@ func TestAccessAllowed(t *testing.T) {
 				domain, expected, c)
 		}
 	}
-	checkAccess := func(desc, domain string, limit int, expected bool) {
-		if res := m.AccessAllowed(domain, limit); (res != nil) != expected {
+	checkAccess := func(desc, domain string, limit int, expected bool, expectedRemaining int) {
+		res, remaining := m.AccessAllowed(domain, limit)
+		if (res != nil) != expected {
 			t.Errorf("%v: unexpected access (%q, %d), got %v, expected %v, count = %d",
 				desc, domain, limit, res != nil, expected, m.GetAccessCount(domain))
 		}
+		if remaining != expectedRemaining {
+			t.Errorf("%v: unexpected remaining count (%q, %d), got %d, expected %d",
+				desc, domain, limit, remaining, expectedRemaining)
+		}
 	}
 	checkCount("foo", 0)
-	checkAccess("first", "foo", 2, true)
+	checkAccess("first", "foo", 2, true, 1)
 	checkCount("foo", 1)
-	checkAccess("second", "foo", 2, true)
-	checkAccess("third", "foo", 2, false)
+	checkAccess("second", "foo", 2, true, 0)
+	checkAccess("third", "foo", 2, false, 0)
 	checkCount("foo", 2)
 
 	checkCount("bar", 0)
-	checkAccess("other domain", "bar", 2, true)
+	checkAccess("other domain", "bar", 2, true, 1)
 }
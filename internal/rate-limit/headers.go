@@ -0,0 +1,95 @@
+package rateLimit
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/headers"
+)
+
+// Response headers describing the quota applied to a submission. The
+// reset time is in seconds from now. Rejected submissions also get a
+// Retry-After header, with the same value as the reset header.
+const (
+	RuleHeader      = "Sigsum-RateLimit-Rule"
+	LimitHeader     = "Sigsum-RateLimit-Limit"
+	RemainingHeader = "Sigsum-RateLimit-Remaining"
+	ResetHeader     = "Sigsum-RateLimit-Reset"
+)
+
+type quotaKey struct{}
+
+// Collects the quotas applied while handling a request.
+type quotaRecorder struct {
+	mu    sync.Mutex
+	quota *Quota
+}
+
+// RecordQuota records the quota applied to a submission, for the
+// response headers added by NewHandler. If several quotas are
+// recorded for the same request, e.g., for a batch of leaves, the one
+// with the fewest remaining accesses is used. Does nothing if the
+// context doesn't come from a request handled by NewHandler.
+func RecordQuota(ctx context.Context, quota *Quota) {
+	r, ok := ctx.Value(quotaKey{}).(*quotaRecorder)
+	if !ok || len(quota.Rule) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.quota == nil || quota.Remaining < r.quota.Remaining {
+		q := *quota
+		r.quota = &q
+	}
+}
+
+func (r *quotaRecorder) get() *Quota {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.quota
+}
+
+// Sets the quota headers, and if rejected, the Retry-After header.
+func setHeaders(h http.Header, quota *Quota, now time.Time, rejected bool) {
+	rule := quota.Rule
+	if len(quota.Domain) > 0 {
+		rule += " " + quota.Domain
+	}
+	h.Set(RuleHeader, rule)
+	h.Set(LimitHeader, strconv.Itoa(quota.Limit))
+	h.Set(RemainingHeader, strconv.Itoa(quota.Remaining))
+	if quota.Reset.IsZero() {
+		return
+	}
+	// Round up to whole seconds.
+	reset := strconv.FormatInt(int64((quota.Reset.Sub(now)+time.Second-1)/time.Second), 10)
+	h.Set(ResetHeader, reset)
+	if rejected {
+		h.Set("Retry-After", reset)
+	}
+}
+
+// NewHandler wraps the log's public handler, or the add-leaves
+// handler, adding quota headers to responses for submissions.
+func NewHandler(prefix string, next http.Handler) http.Handler {
+	addLeafPath := "/" + path.Join(prefix, "add-leaf")
+	addLeavesPath := "/" + path.Join(prefix, "add-leaves")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != addLeafPath && r.URL.Path != addLeavesPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var quotas quotaRecorder
+		writer := headers.NewWriter(w, func(h http.Header, status int) {
+			if quota := quotas.get(); quota != nil {
+				setHeaders(h, quota, time.Now(), status == http.StatusTooManyRequests)
+			}
+		})
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), quotaKey{}, &quotas)))
+		writer.Finish()
+	})
+}
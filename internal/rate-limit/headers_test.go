@@ -0,0 +1,58 @@
+package rateLimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	for _, table := range []struct {
+		desc   string
+		path   string
+		quotas []Quota
+		status int
+		want   map[string]string // Expected headers, "" for absent
+	}{
+		{"no quota", "/test/add-leaf", nil, http.StatusTooManyRequests,
+			map[string]string{RuleHeader: "", "Retry-After": ""}},
+		{"accepted", "/test/add-leaf",
+			[]Quota{{Rule: RuleKey, Limit: 10, Remaining: 3, Reset: reset}}, http.StatusAccepted,
+			map[string]string{RuleHeader: "key", LimitHeader: "10", RemainingHeader: "3",
+				ResetHeader: "3600", "Retry-After": ""}},
+		{"rejected", "/test/add-leaf",
+			[]Quota{{Rule: RuleDomain, Domain: "example.org", Limit: 5, Reset: reset}}, http.StatusTooManyRequests,
+			map[string]string{RuleHeader: "domain example.org", LimitHeader: "5", RemainingHeader: "0",
+				ResetHeader: "3600", "Retry-After": "3600"}},
+		{"batch", "/test/add-leaves",
+			[]Quota{
+				{Rule: RulePublic, Domain: "example.org", Limit: 5, Remaining: 4, Reset: reset},
+				{Rule: RuleKey, Limit: 10, Remaining: 2, Reset: reset},
+				{Rule: RulePublic, Domain: "example.com", Limit: 5, Remaining: 3, Reset: reset},
+			}, http.StatusOK,
+			map[string]string{RuleHeader: "key", RemainingHeader: "2"}},
+		{"other endpoint", "/test/get-tree-head",
+			[]Quota{{Rule: RuleKey, Limit: 10, Remaining: 3, Reset: reset}}, http.StatusOK,
+			map[string]string{RuleHeader: ""}},
+	} {
+		h := NewHandler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := range table.quotas {
+				RecordQuota(r.Context(), &table.quotas[i])
+			}
+			w.WriteHeader(table.status)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, table.path, nil))
+		if w.Code != table.status {
+			t.Errorf("%s: got status %d, want %d", table.desc, w.Code, table.status)
+		}
+		for name, want := range table.want {
+			// Allow a second of slack in the computed reset time.
+			if got := w.Header().Get(name); got != want && !(want == "3600" && got == "3599") {
+				t.Errorf("%s: got %s header %q, want %q", table.desc, name, got, want)
+			}
+		}
+	}
+}
@@ -19,17 +19,35 @@ import (
 // for test purposes.
 const testDomain = "test.sigsum.org"
 
+// Kinds of rate limit rules, see Quota.
+const (
+	RuleKey    = "key"    // Limit for a submitter key
+	RuleDomain = "domain" // Limit for an allowed domain, or its subdomains
+	RulePublic = "public" // Limit per registered domain, for other domains
+)
+
+// Quota describes the rule applied to an access, and the state of its
+// access count.
+type Quota struct {
+	Rule      string    // Kind of matched rule, or empty if none matched
+	Domain    string    // Matched domain, for domain and public rules
+	Limit     int       // Accesses allowed per period
+	Remaining int       // Accesses remaining in the current period
+	Reset     time.Time // When the period ends, and counts are reset
+}
+
 type Limiter interface {
 	// Checks if access count is < limit. If so increment count
 	// and returns a function that can be called to undo the increment, in case no
-	// resources were consumed. Otherwise, returns nil.
-	AccessAllowed(domain *string, keyHash *crypto.Hash) func()
+	// resources were consumed. Otherwise, returns nil. In either
+	// case, also returns the quota of the matched rule.
+	AccessAllowed(domain *string, keyHash *crypto.Hash) (func(), Quota)
 }
 
 type NoLimit struct{}
 
-func (l NoLimit) AccessAllowed(_ *string, _ *crypto.Hash) func() {
-	return func() {}
+func (l NoLimit) AccessAllowed(_ *string, _ *crypto.Hash) (func(), Quota) {
+	return func() {}, Quota{}
 }
 
 var schedulePeriod = 24 * time.Hour
@@ -61,6 +79,12 @@ func (s *schedule) IsTime() bool {
 	return true
 }
 
+func (s *schedule) Next() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.next
+}
+
 type limiter struct {
 	allowedKeys    map[string]int
 	allowedDomains map[string]int
@@ -73,23 +97,34 @@ type limiter struct {
 	resetSchedule schedule
 }
 
-// Checks if domain or a suffix of domain is allowed. Second return
+// Checks if domain or a suffix of domain is allowed. Third return
 // value is true if domain was matched by the allow list.
-func (l *limiter) domainAllowed(domain string) (func(), bool) {
+func (l *limiter) domainAllowed(domain string) (func(), Quota, bool) {
 	s := domain
 	for {
 		if limit, ok := l.allowedDomains[s]; ok {
-			return l.domainCounts.AccessAllowed(s, limit), true
+			relax, remaining := l.domainCounts.AccessAllowed(s, limit)
+			return relax, l.quota(RuleDomain, s, limit, remaining), true
 		}
 		dot := strings.Index(s, ".")
 		if dot < 0 {
-			return nil, false
+			return nil, Quota{}, false
 		}
 		s = s[dot+1:]
 	}
 }
 
-func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) func() {
+func (l *limiter) quota(rule, domain string, limit, remaining int) Quota {
+	return Quota{
+		Rule:      rule,
+		Domain:    domain,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     l.resetSchedule.Next(),
+	}
+}
+
+func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) (func(), Quota) {
 	if l.resetSchedule.IsTime() {
 		l.keyCounts.Reset()
 		l.domainCounts.Reset()
@@ -99,29 +134,31 @@ func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) func
 	// TODO: Avoid conversion to string.
 	keyHashString := string(keyHash[:])
 	if limit, ok := l.allowedKeys[keyHashString]; ok {
-		return l.keyCounts.AccessAllowed(keyHashString, limit)
+		relax, remaining := l.keyCounts.AccessAllowed(keyHashString, limit)
+		return relax, l.quota(RuleKey, "", limit, remaining)
 	}
 	if submitDomain == nil {
 		// Skip all domain-based checks.
-		return nil
+		return nil, Quota{}
 	}
 	domain, err := token.NormalizeDomainName(*submitDomain)
 	if err != nil {
-		return nil
+		return nil, Quota{}
 	}
-	if relax, ok := l.domainAllowed(domain); ok {
-		return relax
+	if relax, quota, ok := l.domainAllowed(domain); ok {
+		return relax, quota
 	}
 	if l.allowPublic <= 0 {
-		return nil
+		return nil, Quota{}
 	}
 
 	domain, err = l.domainDb.GetRegisteredDomain(domain)
 	if err != nil {
 		// Reject unknown domains.
-		return nil
+		return nil, Quota{}
 	}
-	return l.publicCounts.AccessAllowed(domain, l.allowPublic)
+	relax, remaining := l.publicCounts.AccessAllowed(domain, l.allowPublic)
+	return relax, l.quota(RulePublic, domain, l.allowPublic, remaining)
 }
 
 func newLimiter(configFile io.Reader, allowTestDomain bool, clock clock) (Limiter, error) {
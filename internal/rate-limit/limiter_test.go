@@ -47,7 +47,7 @@ func repeatedAccess(t *testing.T, config string, count int, requests []request)
 	}
 	for i := 0; i < count; i++ {
 		r := &requests[i%len(requests)]
-		if limiter.AccessAllowed(r.domain, r.keyHash) == nil {
+		if relax, _ := limiter.AccessAllowed(r.domain, r.keyHash); relax == nil {
 			return i
 		}
 		clock.Advance(r.delay)
@@ -168,3 +168,40 @@ func TestPublicLimit(t *testing.T) {
 	}
 
 }
+
+func TestQuota(t *testing.T) {
+	A := func(s string) *string { return &s }
+	key := crypto.Hash{1}
+	config := fmt.Sprintf("key %x 2\ndomain example.org 3\npublic test_suffix_list.dat 4\n", key)
+	clock := &fakeClock{}
+	limiter, err := newTestLimiter(config, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset := clock.Now().Add(schedulePeriod)
+	for _, table := range []struct {
+		desc    string
+		domain  *string
+		keyHash crypto.Hash
+		allowed bool
+		quota   Quota
+	}{
+		{"key", nil, key, true, Quota{Rule: RuleKey, Limit: 2, Remaining: 1, Reset: reset}},
+		{"key, last", nil, key, true, Quota{Rule: RuleKey, Limit: 2, Remaining: 0, Reset: reset}},
+		{"key, exceeded", A("example.org"), key, false, Quota{Rule: RuleKey, Limit: 2, Remaining: 0, Reset: reset}},
+		{"domain", A("www.example.org"), crypto.Hash{}, true,
+			Quota{Rule: RuleDomain, Domain: "example.org", Limit: 3, Remaining: 2, Reset: reset}},
+		{"public", A("www.other.org"), crypto.Hash{}, true,
+			Quota{Rule: RulePublic, Domain: "other.org", Limit: 4, Remaining: 3, Reset: reset}},
+		{"no domain", nil, crypto.Hash{}, false, Quota{}},
+		{"unknown suffix", A("example.com"), crypto.Hash{}, false, Quota{}},
+	} {
+		relax, quota := limiter.AccessAllowed(table.domain, &table.keyHash)
+		if (relax != nil) != table.allowed {
+			t.Errorf("%s: got allowed %v, want %v", table.desc, relax != nil, table.allowed)
+		}
+		if quota != table.quota {
+			t.Errorf("%s: got quota %#v, want %#v", table.desc, quota, table.quota)
+		}
+	}
+}
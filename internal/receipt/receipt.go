@@ -0,0 +1,442 @@
+// Package receipt implements signed submission receipts. When the
+// primary accepts an add-leaf request, the response includes the
+// header "Sigsum-Receipt: <deadline> <signature>", where the deadline
+// is a unix time by which the log promises that the leaf is included
+// in a published tree head, and the signature is the log's hex-encoded
+// signature binding the leaf hash to the deadline, see Verify.
+//
+// The primary tracks its receipts, and records any receipt whose
+// deadline passes without the leaf appearing in a published tree
+// head. Overdue receipts are counted in metrics, and listed on an
+// internal endpoint. Each newly published tree head is checked by
+// reading only the leaves added since the previous one, and the
+// inclusion of a leaf not found that way is checked individually
+// when its deadline has passed, e.g., for leaves that were already
+// in the tree when the receipt was issued. Pending and overdue
+// receipts are persisted in a journal file, see package journal, so
+// they survive restarts.
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/journal"
+	"sigsum.org/log-go/internal/metrics"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	Header = "Sigsum-Receipt"
+	// Endpoint name of the internal endpoint listing overdue
+	// receipts, relative to the url prefix.
+	Endpoint = "get-overdue-receipts"
+
+	// Maximum number of tracked receipts; when full, leaves are
+	// accepted without a receipt.
+	MaxPending = 100000
+	// Maximum number of listed overdue receipts; when full, the
+	// oldest are dropped.
+	MaxOverdue = 10000
+
+	signatureNamespace = "sigsum.org/v1/submission-receipt"
+	// Number of leaves to read per backend request.
+	leafBatchSize = 1000
+)
+
+var ErrTooManyPending = errors.New("too many pending receipts")
+
+// Tree is the subset of the db.Client interface used for checking
+// that leaves are included.
+type Tree interface {
+	GetLeaves(context.Context, *requests.Leaves) ([]types.Leaf, error)
+	GetInclusionProof(context.Context, *requests.InclusionProof) (types.InclusionProof, error)
+}
+
+// Overdue is a receipt whose deadline passed without the leaf
+// appearing in a published tree head.
+type Overdue struct {
+	LeafHash crypto.Hash
+	Deadline time.Time
+}
+
+// Tracker issues receipts, and keeps track of their deadlines. It
+// implements state.Publisher.
+type Tracker struct {
+	signer   crypto.Signer
+	tree     Tree
+	deadline time.Duration
+	metrics  metrics.ReceiptMetrics
+	now      func() time.Time
+
+	mu      sync.Mutex
+	journal *journal.Journal
+	size    uint64 // Size of the latest checked tree head
+	hasSize bool   // False until the first tree head is checked
+	pending map[crypto.Hash]time.Time
+	overdue []Overdue
+}
+
+// NewTracker creates a tracker issuing receipts signed by signer,
+// which should be the log's key, promising inclusion within deadline.
+// The tracking state is persisted in file, and loaded if it exists.
+func NewTracker(file string, signer crypto.Signer, tree Tree, deadline time.Duration,
+	m metrics.ReceiptMetrics) (*Tracker, error) {
+	t := Tracker{
+		signer:   signer,
+		tree:     tree,
+		deadline: deadline,
+		metrics:  m,
+		now:      time.Now,
+		pending:  make(map[crypto.Hash]time.Time),
+	}
+	j, records, err := journal.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.replay(records); err != nil {
+		return nil, fmt.Errorf("reading receipt file %q failed: %v", file, err)
+	}
+	t.journal = j
+	if err := j.MaybeCompact(t.liveRecords(), t.records); err != nil {
+		return nil, err
+	}
+	t.metrics.SetPending(len(t.pending))
+	return &t, nil
+}
+
+// Issue returns a receipt for the leaf, formatted as the value of the
+// receipt header. If a receipt is already pending for the leaf, the
+// new receipt has the same deadline.
+func (t *Tracker) Issue(leafHash *crypto.Hash) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	deadline, ok := t.pending[*leafHash]
+	if !ok {
+		if len(t.pending) >= MaxPending {
+			return "", ErrTooManyPending
+		}
+		deadline = time.Unix(t.now().Add(t.deadline).Unix(), 0)
+	}
+	signature, err := t.signer.Sign(signedData(leafHash, deadline))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		// Persisted before the receipt is handed out.
+		if err := t.journal.Append(issuedRecord(leafHash, deadline)); err != nil {
+			return "", err
+		}
+		t.pending[*leafHash] = deadline
+	}
+	t.metrics.OnIssued()
+	t.metrics.SetPending(len(t.pending))
+	return fmt.Sprintf("%d %s", deadline.Unix(), hex.EncodeToString(signature[:])), nil
+}
+
+// Publish checks pending receipts against a newly published tree
+// head. Receipts for included leaves are done, and receipts past
+// their deadline are recorded as overdue.
+func (t *Tracker) Publish(ctx context.Context, cth *types.CosignedTreeHead) error {
+	t.mu.Lock()
+	start, hasSize, havePending := t.size, t.hasSize, len(t.pending) > 0
+	t.mu.Unlock()
+
+	// Leaves added since the previous tree head. On the first
+	// publication, there's no previous tree head, and receipts
+	// are checked individually at their deadlines.
+	end := cth.Size
+	var added map[crypto.Hash]bool
+	if hasSize && havePending && end > start {
+		var err error
+		if added, end, err = t.addedLeaves(ctx, start, end); err != nil {
+			log.Warning("reading leaves for receipts failed: %v", err)
+		}
+	}
+
+	now := t.now()
+	t.mu.Lock()
+	var records []string
+	var due []Overdue
+	for h, deadline := range t.pending {
+		switch {
+		case added[h]:
+			delete(t.pending, h)
+			records = append(records, doneRecord(&h))
+		case now.After(deadline):
+			due = append(due, Overdue{LeafHash: h, Deadline: deadline})
+		}
+	}
+	t.mu.Unlock()
+
+	// Leaves not found among the added leaves may have been in
+	// the tree already, so check each one before declaring it
+	// overdue. This happens at most once per receipt.
+	included := make(map[crypto.Hash]bool)
+	for _, o := range due {
+		if ctx.Err() != nil {
+			// Remaining receipts are checked at the next
+			// publication.
+			break
+		}
+		ok, err := t.isIncluded(ctx, &o.LeafHash, &cth.TreeHead)
+		if err != nil {
+			log.Warning("checking inclusion for receipt failed: %v", err)
+			continue
+		}
+		included[o.LeafHash] = ok
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range due {
+		ok, checked := included[o.LeafHash]
+		// Keep a receipt re-issued meanwhile.
+		if !checked || t.pending[o.LeafHash] != o.Deadline {
+			continue
+		}
+		delete(t.pending, o.LeafHash)
+		if ok {
+			records = append(records, doneRecord(&o.LeafHash))
+			continue
+		}
+		log.Warning("receipt deadline %v passed without leaf %x in published tree head", o.Deadline, o.LeafHash)
+		t.addOverdue(o)
+		records = append(records, overdueRecord(&o))
+		t.metrics.OnOverdue()
+	}
+	if !t.hasSize || end > t.size {
+		t.size, t.hasSize = end, true
+		records = append(records, publishedRecord(end))
+	}
+	t.metrics.SetPending(len(t.pending))
+	if len(records) == 0 {
+		return nil
+	}
+	// The in-memory state is already updated. If it isn't stored,
+	// the same leaves are checked again after a restart.
+	if err := t.journal.Append(records...); err != nil {
+		return fmt.Errorf("storing receipts failed: %v", err)
+	}
+	if err := t.journal.MaybeCompact(t.liveRecords(), t.records); err != nil {
+		return fmt.Errorf("compacting receipt file failed: %v", err)
+	}
+	return nil
+}
+
+// Returns the hashes of the leaves in the range [start, end), and the
+// end of the range actually read, which is less than end on failure.
+func (t *Tracker) addedLeaves(ctx context.Context, start, end uint64) (map[crypto.Hash]bool, uint64, error) {
+	added := make(map[crypto.Hash]bool)
+	for index := start; index < end; {
+		batchEnd := end
+		if batchEnd-index > leafBatchSize {
+			batchEnd = index + leafBatchSize
+		}
+		leaves, err := t.tree.GetLeaves(ctx, &requests.Leaves{StartIndex: index, EndIndex: batchEnd})
+		if err == nil && len(leaves) == 0 {
+			err = fmt.Errorf("backend get leaves returned an empty list")
+		}
+		if err != nil {
+			return added, index, err
+		}
+		for _, leaf := range leaves {
+			added[merkle.HashLeafNode(leaf.ToBinary())] = true
+		}
+		index += uint64(len(leaves))
+	}
+	return added, end, nil
+}
+
+// Records an overdue receipt, dropping the oldest beyond MaxOverdue.
+// Called from Publish, and from replay while loading the receipt file.
+func (t *Tracker) addOverdue(o Overdue) {
+	t.overdue = append(t.overdue, o)
+	if len(t.overdue) > MaxOverdue {
+		t.overdue = t.overdue[len(t.overdue)-MaxOverdue:]
+	}
+}
+
+func (t *Tracker) isIncluded(ctx context.Context, leafHash *crypto.Hash, th *types.TreeHead) (bool, error) {
+	if th.Size == 0 {
+		return false, nil
+	}
+	_, err := t.tree.GetInclusionProof(ctx, &requests.InclusionProof{Size: th.Size, LeafHash: *leafHash})
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, db.ErrNotIncluded) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Overdue returns the overdue receipts, oldest first.
+func (t *Tracker) Overdue() []Overdue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Overdue(nil), t.overdue...)
+}
+
+func signedData(leafHash *crypto.Hash, deadline time.Time) []byte {
+	return []byte(fmt.Sprintf("%s\n%x\n%d\n", signatureNamespace, *leafHash, deadline.Unix()))
+}
+
+// Verify checks a receipt for a leaf, as returned in the receipt
+// header, and returns its deadline.
+func Verify(pub *crypto.PublicKey, leafHash *crypto.Hash, receipt string) (time.Time, error) {
+	fields := strings.Fields(receipt)
+	if len(fields) != 2 {
+		return time.Time{}, fmt.Errorf("invalid receipt %q", receipt)
+	}
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid receipt deadline: %v", err)
+	}
+	sig, err := crypto.SignatureFromHex(fields[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid receipt signature: %v", err)
+	}
+	deadline := time.Unix(unix, 0)
+	if !crypto.Verify(pub, signedData(leafHash, deadline), &sig) {
+		return time.Time{}, fmt.Errorf("invalid receipt signature")
+	}
+	return deadline, nil
+}
+
+// WriteOverdue writes a list of overdue receipts in sigsum's ascii
+// format, one line "overdue_receipt=<leaf hash> <deadline>" per
+// receipt.
+func WriteOverdue(w io.Writer, overdue []Overdue) error {
+	for _, o := range overdue {
+		if err := ascii.WriteLine(w, "overdue_receipt",
+			hex.EncodeToString(o.LeafHash[:]), o.Deadline.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewOverdueHandler returns a handler for the internal endpoint
+// listing overdue receipts.
+func (t *Tracker) NewOverdueHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteOverdue(&buf, t.Overdue()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// The receipt file is a journal, see package journal, with the
+// records
+//
+//	issued <leaf hash> <deadline, unix time>
+//	done <leaf hash>
+//	overdue <leaf hash> <deadline, unix time>
+//	published <tree size>
+//
+// for an issued receipt, a receipt whose leaf is included, an overdue
+// receipt, and the size of a checked tree head, respectively.
+func issuedRecord(leafHash *crypto.Hash, deadline time.Time) string {
+	return fmt.Sprintf("issued %x %d", *leafHash, deadline.Unix())
+}
+
+func doneRecord(leafHash *crypto.Hash) string {
+	return fmt.Sprintf("done %x", *leafHash)
+}
+
+func overdueRecord(o *Overdue) string {
+	return fmt.Sprintf("overdue %x %d", o.LeafHash, o.Deadline.Unix())
+}
+
+func publishedRecord(size uint64) string {
+	return fmt.Sprintf("published %d", size)
+}
+
+// Must be called before the tracker is shared.
+func (t *Tracker) replay(records []string) error {
+	for _, record := range records {
+		fields := strings.Fields(record)
+		if len(fields) < 2 {
+			return fmt.Errorf("invalid receipt record %q", record)
+		}
+		if fields[0] == "published" && len(fields) == 2 {
+			size, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			t.size, t.hasSize = size, true
+			continue
+		}
+		leafHash, err := crypto.HashFromHex(fields[1])
+		if err != nil {
+			return err
+		}
+		switch {
+		case fields[0] == "done" && len(fields) == 2:
+			delete(t.pending, leafHash)
+		case (fields[0] == "issued" || fields[0] == "overdue") && len(fields) == 3:
+			unix, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return err
+			}
+			deadline := time.Unix(unix, 0)
+			if fields[0] == "issued" {
+				t.pending[leafHash] = deadline
+			} else {
+				delete(t.pending, leafHash)
+				t.addOverdue(Overdue{LeafHash: leafHash, Deadline: deadline})
+			}
+		default:
+			return fmt.Errorf("invalid receipt record %q", record)
+		}
+	}
+	return nil
+}
+
+// Returns the number of records needed to describe the current
+// state, i.e., the pending and overdue receipts, and the published
+// size.
+func (t *Tracker) liveRecords() int {
+	return len(t.pending) + len(t.overdue) + 1
+}
+
+// Returns the records of the current state, written when the receipt
+// file is compacted.
+func (t *Tracker) records() []string {
+	var records []string
+	if t.hasSize {
+		records = append(records, publishedRecord(t.size))
+	}
+	for h, deadline := range t.pending {
+		records = append(records, issuedRecord(&h, deadline))
+	}
+	for _, o := range t.overdue {
+		records = append(records, overdueRecord(&o))
+	}
+	return records
+}
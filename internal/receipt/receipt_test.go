@@ -0,0 +1,204 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type testMetrics struct {
+	issued, pending, overdue int
+}
+
+func (m *testMetrics) OnIssued()            { m.issued++ }
+func (m *testMetrics) SetPending(count int) { m.pending = count }
+func (m *testMetrics) OnOverdue()           { m.overdue++ }
+
+func mustLeafRequests(t *testing.T, n int) ([]requests.Leaf, []crypto.Hash) {
+	t.Helper()
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := make([]requests.Leaf, n)
+	hashes := make([]crypto.Hash, n)
+	for i := range reqs {
+		reqs[i] = requests.Leaf{Message: crypto.Hash{byte(i)}, PublicKey: signer.Public()}
+		if reqs[i].Signature, err = types.SignLeafMessage(signer, reqs[i].Message[:]); err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := reqs[i].Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[i] = merkle.HashLeafNode(leaf.ToBinary())
+	}
+	return reqs, hashes
+}
+
+func mustTreeHead(t *testing.T, tree db.Client) *types.CosignedTreeHead {
+	t.Helper()
+	th, err := tree.GetTreeHead(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: th}}
+}
+
+func TestTracker(t *testing.T) {
+	ctx := context.Background()
+	reqs, hashes := mustLeafRequests(t, 3)
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := db.NewMemoryDb()
+	m := testMetrics{}
+	file := filepath.Join(t.TempDir(), "receipts")
+	tracker, err := NewTracker(file, signer, tree, time.Minute, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	tracker.now = func() time.Time { return now }
+
+	var receipts []string
+	for i := range hashes {
+		receipt, err := tracker.Issue(&hashes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline, err := Verify(&pub, &hashes[i], receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := now.Add(time.Minute); !deadline.Equal(want) {
+			t.Errorf("unexpected deadline %v, wanted %v", deadline, want)
+		}
+		receipts = append(receipts, receipt)
+	}
+	if _, err := Verify(&pub, &hashes[1], receipts[0]); err == nil {
+		t.Errorf("receipt accepted for the wrong leaf")
+	}
+	// Re-issuing keeps the deadline.
+	now = now.Add(time.Second)
+	if receipt, err := tracker.Issue(&hashes[0]); err != nil || receipt != receipts[0] {
+		t.Errorf("unexpected re-issued receipt %q (err %v), wanted %q", receipt, err, receipts[0])
+	}
+	if m.issued != 4 || m.pending != 3 {
+		t.Errorf("unexpected metrics %+v", m)
+	}
+
+	// Leaves 0 and 1 are published before the deadline, leaf 2 is
+	// never added.
+	for _, req := range reqs[:2] {
+		leaf, err := req.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.AddLeaf(ctx, &leaf, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tracker.Publish(ctx, &types.CosignedTreeHead{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.Overdue()) != 0 || m.pending != 3 {
+		t.Errorf("unexpected state for empty tree, overdue %v, metrics %+v", tracker.Overdue(), m)
+	}
+	if err := tracker.Publish(ctx, mustTreeHead(t, tree)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.Overdue()) != 0 || m.pending != 1 {
+		t.Errorf("unexpected state before deadline, overdue %v, metrics %+v", tracker.Overdue(), m)
+	}
+
+	// Pending receipts survive a restart.
+	reloaded, err := NewTracker(file, signer, tree, time.Minute, &testMetrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reloaded.pending, tracker.pending) || reloaded.size != 2 {
+		t.Errorf("unexpected reloaded state, pending %v, size %d", reloaded.pending, reloaded.size)
+	}
+
+	now = now.Add(time.Hour)
+	if err := tracker.Publish(ctx, mustTreeHead(t, tree)); err != nil {
+		t.Fatal(err)
+	}
+	overdue := tracker.Overdue()
+	if len(overdue) != 1 || overdue[0].LeafHash != hashes[2] || m.overdue != 1 || m.pending != 0 {
+		t.Errorf("unexpected state after deadline, overdue %v, metrics %+v", overdue, m)
+	}
+	if reloaded, err = NewTracker(file, signer, tree, time.Minute, &testMetrics{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.pending) != 0 || !reflect.DeepEqual(reloaded.Overdue(), overdue) {
+		t.Errorf("unexpected reloaded state, pending %v, overdue %v", reloaded.pending, reloaded.Overdue())
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOverdue(&buf, overdue); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), fmt.Sprintf("overdue_receipt=%x %d\n", hashes[2], 1060); got != want {
+		t.Errorf("unexpected overdue list %q, wanted %q", got, want)
+	}
+}
+
+func TestTrackerIncludedLeaf(t *testing.T) {
+	ctx := context.Background()
+	reqs, hashes := mustLeafRequests(t, 3)
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := db.NewMemoryDb()
+	for _, req := range reqs {
+		leaf, err := req.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.AddLeaf(ctx, &leaf, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := testMetrics{}
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "receipts"), signer, tree, time.Minute, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	tracker.now = func() time.Time { return now }
+	if err := tracker.Publish(ctx, mustTreeHead(t, tree)); err != nil {
+		t.Fatal(err)
+	}
+	// A resubmitted leaf, already in the published tree, isn't
+	// among the newly added leaves.
+	if _, err := tracker.Issue(&hashes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Publish(ctx, mustTreeHead(t, tree)); err != nil {
+		t.Fatal(err)
+	}
+	if m.pending != 1 {
+		t.Errorf("unexpected metrics before deadline %+v", m)
+	}
+	now = now.Add(time.Hour)
+	if err := tracker.Publish(ctx, mustTreeHead(t, tree)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.Overdue()) != 0 || m.pending != 0 || m.overdue != 0 {
+		t.Errorf("unexpected state after deadline, overdue %v, metrics %+v", tracker.Overdue(), m)
+	}
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Runs a publisher in its own goroutine, so that a slow publisher
+// delays neither rotation nor the other publishers. Only the latest
+// tree head is handed over: tree heads rotated in while the publisher
+// is busy are skipped, and publishing the latest one catches up.
+type publisherRunner struct {
+	publisher Publisher
+	latest    chan *types.CosignedTreeHead
+}
+
+func newPublisherRunner(publisher Publisher) *publisherRunner {
+	return &publisherRunner{
+		publisher: publisher,
+		latest:    make(chan *types.CosignedTreeHead, 1),
+	}
+}
+
+// Hands over a tree head, replacing any tree head not yet published.
+// Must not be called concurrently.
+func (r *publisherRunner) offer(cth *types.CosignedTreeHead) {
+	select {
+	case <-r.latest:
+	default:
+	}
+	r.latest <- cth
+}
+
+// Publishes tree heads as they are handed over, until the context is
+// done. Each publication is limited by the timeout.
+func (r *publisherRunner) run(ctx context.Context, timeout time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cth := <-r.latest:
+			publishCtx, cancel := context.WithTimeout(ctx, timeout)
+			// Failure doesn't affect the rotation, the next
+			// successful publication catches up.
+			if err := r.publisher.Publish(publishCtx, cth); err != nil {
+				log.Warning("failed publishing tree head: %v", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -3,8 +3,11 @@ package state
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
+	"sigsum.org/log-go/internal/freshness"
+	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
@@ -18,39 +21,96 @@ type PrimaryTree interface {
 	GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error)
 }
 
+// Subset of the secondary's internal api, see package freshness.
+type SecondaryTree interface {
+	GetFreshSecondaryTreeHead(context.Context, *crypto.Hash) (freshness.TreeHead, error)
+	GetSecondaryTreeHead(context.Context) (types.SignedTreeHead, error)
+}
+
+// ReplicationState is not safe for concurrent use.
 type ReplicationState struct {
 	// Timeout for interaction with primary and secondary.
 	timeout      time.Duration
 	primary      PrimaryTree
 	secondaryPub crypto.PublicKey
-	secondary    api.Secondary
+	secondary    SecondaryTree
+	metrics      metrics.ReplicationMetrics // Optional, nil if not configured
+	// If set, don't fall back to the legacy endpoint, for
+	// secondaries not supporting nonces.
+	requireFresh bool
+}
+
+func (r *ReplicationState) getMetrics() metrics.ReplicationMetrics {
+	if r.metrics == nil {
+		return metrics.NoReplicationMetrics{}
+	}
+	return r.metrics
 }
 
 // Return the latest primary tree head with size at least minSize.
-func (r ReplicationState) getPrimaryTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, error) {
+func (r *ReplicationState) getPrimaryTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, error) {
 	primaryTreeHead, err := r.primary.GetTreeHead(ctx)
 	if err != nil {
+		r.getMetrics().OnError("primary-tree-head")
 		return types.TreeHead{}, fmt.Errorf("get primary tree head: %w", err)
 	}
+	r.getMetrics().SetPrimarySize(primaryTreeHead.Size)
 	if primaryTreeHead.Size < minSize {
+		r.getMetrics().OnError("primary-behind")
 		return types.TreeHead{}, fmt.Errorf("primary is behind(!), %d < %d", primaryTreeHead.Size, minSize)
 	}
 	return primaryTreeHead, nil
 }
 
-// Return the latest secondary tree head with size at least minSize.
-func (r ReplicationState) getSecondaryTreeHead(ctx context.Context, minSize uint64, maxSize uint64) (types.TreeHead, error) {
-	sth, err := r.secondary.GetSecondaryTreeHead(ctx)
+// Fetches the secondary's tree head, bound to a fresh nonce, so that
+// a replayed response is rejected. Unless requireFresh is set, falls
+// back to the legacy endpoint if the secondary doesn't support nonces
+// (i.e., during a rolling upgrade).
+func (r *ReplicationState) fetchSecondaryTreeHead(ctx context.Context) (types.SignedTreeHead, error) {
+	nonce, err := freshness.NewNonce()
 	if err != nil {
-		return types.TreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
+		return types.SignedTreeHead{}, err
+	}
+	th, err := r.secondary.GetFreshSecondaryTreeHead(ctx, &nonce)
+	if err != nil && !r.requireFresh && api.ErrorStatusCode(err) == http.StatusNotFound {
+		r.getMetrics().OnError("secondary-legacy")
+		log.Warning("secondary doesn't support %s, falling back to %s, without replay protection",
+			freshness.Endpoint, freshness.LegacyEndpoint)
+		sth, err := r.secondary.GetSecondaryTreeHead(ctx)
+		if err != nil {
+			r.getMetrics().OnError("secondary-unavailable")
+			return types.SignedTreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
+		}
+		if !sth.Verify(&r.secondaryPub) {
+			r.getMetrics().OnError("secondary-signature")
+			return types.SignedTreeHead{}, fmt.Errorf("invalid signature on secondary's tree head")
+		}
+		return sth, nil
+	}
+	if err != nil {
+		r.getMetrics().OnError("secondary-unavailable")
+		return types.SignedTreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
 	}
-	if !sth.Verify(&r.secondaryPub) {
-		return types.TreeHead{}, fmt.Errorf("invalid signature on secondary's tree head")
+	if err := th.Verify(&r.secondaryPub, &nonce); err != nil {
+		r.getMetrics().OnError("secondary-signature")
+		return types.SignedTreeHead{}, err
 	}
+	return th.SignedTreeHead, nil
+}
+
+// Return the latest secondary tree head with size at least minSize.
+func (r *ReplicationState) getSecondaryTreeHead(ctx context.Context, minSize uint64, maxSize uint64) (types.TreeHead, error) {
+	sth, err := r.fetchSecondaryTreeHead(ctx)
+	if err != nil {
+		return types.TreeHead{}, err
+	}
+	r.getMetrics().SetSecondarySize(sth.Size)
 	if sth.Size > maxSize {
+		r.getMetrics().OnError("secondary-ahead")
 		return types.TreeHead{}, fmt.Errorf("secondary is ahead: %d > %d", sth.Size, maxSize)
 	}
 	if sth.Size < minSize {
+		r.getMetrics().OnError("secondary-behind")
 		return types.TreeHead{}, fmt.Errorf("secondary is behind: %d < %d", sth.Size, minSize)
 	}
 	// Responsiblity of GetToCosignTreeHead to check signature, now we no longer need it.
@@ -58,7 +118,7 @@ func (r ReplicationState) getSecondaryTreeHead(ctx context.Context, minSize uint
 }
 
 // Check consistency
-func (r ReplicationState) checkConsistency(ctx context.Context, old *types.TreeHead, new *types.TreeHead) error {
+func (r *ReplicationState) checkConsistency(ctx context.Context, old *types.TreeHead, new *types.TreeHead) error {
 	if old.Size > new.Size {
 		panic(fmt.Errorf("internal error old.Size (%d) > new.Size (%d)", old.Size, new.Size))
 	}
@@ -75,7 +135,7 @@ func (r ReplicationState) checkConsistency(ctx context.Context, old *types.TreeH
 // Identifies the latest tree head replicated by the secondary, and
 // with size >= minSize, or fails if priamry or secondary is in a bad
 // or too old state.
-func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, error) {
+func (r *ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, error) {
 	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
@@ -83,7 +143,12 @@ func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64
 	if err != nil {
 		return types.TreeHead{}, err
 	}
-	if primaryTreeHead.Size == minSize || r.secondary == nil {
+	if r.secondary == nil {
+		return primaryTreeHead, nil
+	}
+	if primaryTreeHead.Size == minSize {
+		// The current tree head was replicated before it was signed.
+		r.getMetrics().OnReplicated(minSize)
 		return primaryTreeHead, nil
 	}
 
@@ -93,8 +158,10 @@ func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64
 	}
 
 	if err := r.checkConsistency(ctx, &secTreeHead, &primaryTreeHead); err != nil {
+		r.getMetrics().OnError("inconsistent")
 		return types.TreeHead{}, err
 	}
+	r.getMetrics().OnReplicated(secTreeHead.Size)
 	log.Debug("using latest tree head from secondary: size %d", secTreeHead.Size)
 	return secTreeHead, nil
 }
@@ -5,10 +5,12 @@ import (
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/freshness"
 	"sigsum.org/log-go/internal/mocks/db"
+	mocks "sigsum.org/log-go/internal/mocks/state"
+	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/merkle"
-	"sigsum.org/sigsum-go/pkg/mocks"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
@@ -54,13 +56,12 @@ func TestGetSecondaryTreeHead(t *testing.T) {
 		t.Fatal(err)
 	}
 	th := types.TreeHead{Size: 5}
-	sth, err := th.Sign(signer)
-	if err != nil {
-		t.Fatal(err)
-	}
 
-	secondary := mocks.NewMockSecondary(ctrl)
-	secondary.EXPECT().GetSecondaryTreeHead(gomock.Any()).MinTimes(1).Return(sth, nil)
+	secondary := mocks.NewMockSecondaryTree(ctrl)
+	secondary.EXPECT().GetFreshSecondaryTreeHead(gomock.Any(), gomock.Any()).MinTimes(1).DoAndReturn(
+		func(_ context.Context, nonce *crypto.Hash) (freshness.TreeHead, error) {
+			return freshness.Sign(signer, &th, nonce)
+		})
 
 	state := ReplicationState{secondary: secondary, secondaryPub: pub}
 	ctx := context.Background()
@@ -86,6 +87,68 @@ func TestGetSecondaryTreeHead(t *testing.T) {
 	}
 }
 
+func TestGetSecondaryTreeHeadFreshness(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	th := types.TreeHead{Size: 5}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Answers with a response bound to the requested nonce.
+	fresh := func(_ context.Context, nonce *crypto.Hash) (freshness.TreeHead, error) {
+		return freshness.Sign(signer, &th, nonce)
+	}
+	// Answers with a previously captured response.
+	captured, err := freshness.Sign(signer, &th, &crypto.Hash{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := func(_ context.Context, _ *crypto.Hash) (freshness.TreeHead, error) {
+		return captured, nil
+	}
+	notFound := func(_ context.Context, _ *crypto.Hash) (freshness.TreeHead, error) {
+		return freshness.TreeHead{}, api.ErrNotFound
+	}
+
+	for _, table := range []struct {
+		desc         string
+		response     func(context.Context, *crypto.Hash) (freshness.TreeHead, error)
+		requireFresh bool
+		legacy       bool // Expect fallback to the legacy endpoint
+		wantOK       bool
+	}{
+		{desc: "fresh", response: fresh, wantOK: true},
+		{desc: "fresh, required", response: fresh, requireFresh: true, wantOK: true},
+		{desc: "replayed", response: replayed},
+		{desc: "legacy secondary", response: notFound, legacy: true, wantOK: true},
+		{desc: "legacy secondary, fresh required", response: notFound, requireFresh: true},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			secondary := mocks.NewMockSecondaryTree(ctrl)
+			secondary.EXPECT().GetFreshSecondaryTreeHead(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(table.response)
+			if table.legacy {
+				secondary.EXPECT().GetSecondaryTreeHead(gomock.Any()).Times(2).Return(sth, nil)
+			}
+			state := ReplicationState{secondary: secondary, secondaryPub: pub, requireFresh: table.requireFresh}
+			for i := 0; i < 2; i++ {
+				_, err := state.getSecondaryTreeHead(context.Background(), 0, 10)
+				if table.wantOK && err != nil {
+					t.Errorf("%s: response %d: unexpected error: %v", table.desc, i, err)
+				}
+				if !table.wantOK && err == nil {
+					t.Errorf("%s: response %d: replayed or legacy tree head accepted", table.desc, i)
+				}
+			}
+		}()
+	}
+}
+
 func TestCheckConsistency(t *testing.T) {
 	withConsistencyProof := func(old *types.TreeHead, new *types.TreeHead, consistencyProof []crypto.Hash) error {
 		t.Helper()
@@ -6,8 +6,8 @@ import (
 	"sync"
 	"time"
 
+	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/log-go/internal/witness"
-	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
@@ -19,19 +19,22 @@ type StateManagerSingle struct {
 	signer           crypto.Signer
 	keyHash          crypto.Hash
 	storeSth         func(sth *types.SignedTreeHead) error
+	storeFinal       func(cth *types.CosignedTreeHead) error
 	replicationState ReplicationState
+	publishers       []*publisherRunner
 
 	// Lock-protected access to tree heads. All endpoints are readers.
 	sync.RWMutex
 	signedTreeHead   types.SignedTreeHead
 	cosignedTreeHead types.CosignedTreeHead
+	final            bool // If true, the tree heads never change
 }
 
 // NewStateManagerSingle() sets up a new state manager, in particular its
 // signedTreeHead.  An optional secondary node can be used to ensure that
 // a newer primary tree is not signed unless it has been replicated.
 func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout time.Duration,
-	secondary api.Secondary, secondaryPub *crypto.PublicKey, sthFileName string) (*StateManagerSingle, error) {
+	secondary SecondaryTree, secondaryPub *crypto.PublicKey, sthFileName string) (*StateManagerSingle, error) {
 	pub := signer.Public()
 	sthFile := sthFile{name: sthFileName}
 	startupMode, err := sthFile.Startup()
@@ -70,10 +73,11 @@ func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout ti
 	default:
 		panic(fmt.Sprintf("internal error, unknown startup mode %d", startupMode))
 	}
-	return &StateManagerSingle{
-		signer:   signer,
-		keyHash:  crypto.HashBytes(pub[:]),
-		storeSth: sthFile.Store,
+	sm := StateManagerSingle{
+		signer:     signer,
+		keyHash:    crypto.HashBytes(pub[:]),
+		storeSth:   sthFile.Store,
+		storeFinal: sthFile.StoreFinal,
 		replicationState: ReplicationState{
 			primary:      primary,
 			secondary:    secondary,
@@ -83,7 +87,41 @@ func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout ti
 		// No cosignatures available at startup.
 		signedTreeHead:   sth,
 		cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: sth},
-	}, nil
+	}
+	final, err := sthFile.LoadFinal(&pub)
+	if err != nil {
+		return nil, err
+	}
+	if final != nil {
+		if final.TreeHead != sth.TreeHead {
+			return nil, fmt.Errorf("final tree head (size %d) doesn't match sth file (size %d)", final.Size, sth.Size)
+		}
+		log.Info("log is retired, serving final tree head of size %d", final.Size)
+		sm.cosignedTreeHead = *final
+		sm.final = true
+	}
+	return &sm, nil
+}
+
+// AddPublisher configures a publisher to be notified after each
+// rotation. Each publisher runs in its own goroutine, started by Run,
+// and if it is still busy with an earlier tree head, only the latest
+// one is published. Must be called before Run.
+func (sm *StateManagerSingle) AddPublisher(publisher Publisher) {
+	sm.publishers = append(sm.publishers, newPublisherRunner(publisher))
+}
+
+// RequireFreshSecondary disables the fallback to the secondary's
+// legacy get-secondary-tree-head endpoint, which has no replay
+// protection. Must be called before Run.
+func (sm *StateManagerSingle) RequireFreshSecondary() {
+	sm.replicationState.requireFresh = true
+}
+
+// SetReplicationMetrics configures metrics for replication to the
+// secondary. Must be called before Run.
+func (sm *StateManagerSingle) SetReplicationMetrics(m metrics.ReplicationMetrics) {
+	sm.replicationState.metrics = m
 }
 
 func (sm *StateManagerSingle) SignedTreeHead() types.SignedTreeHead {
@@ -98,11 +136,55 @@ func (sm *StateManagerSingle) CosignedTreeHead() types.CosignedTreeHead {
 	return sm.cosignedTreeHead
 }
 
+func (sm *StateManagerSingle) IsFinal() bool {
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.final
+}
+
+func (sm *StateManagerSingle) Finalize(ctx context.Context) (types.CosignedTreeHead, error) {
+	th, err := sm.replicationState.primary.GetTreeHead(ctx)
+	if err != nil {
+		return types.CosignedTreeHead{}, fmt.Errorf("getting local tree head failed: %v", err)
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	if sm.final {
+		return sm.cosignedTreeHead, nil
+	}
+	cth := sm.cosignedTreeHead
+	if cth.Size != th.Size || cth.Size != sm.signedTreeHead.Size {
+		return types.CosignedTreeHead{}, fmt.Errorf("published tree head (size %d) doesn't cover local tree (size %d)",
+			cth.Size, th.Size)
+	}
+	if err := sm.storeFinal(&cth); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	log.Info("final tree head of size %d stored", cth.Size)
+	sm.final = true
+	return cth, nil
+}
+
 func (sm *StateManagerSingle) Run(ctx context.Context, witnesses []policy.Entity, interval time.Duration) {
 	collector := witness.NewCosignatureCollector(&sm.keyHash, witnesses,
 		sm.replicationState.primary.GetConsistencyProof)
 
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, publisher := range sm.publishers {
+		wg.Add(1)
+		go func(publisher *publisherRunner) {
+			defer wg.Done()
+			publisher.run(ctx, interval)
+		}(publisher)
+	}
+
 	for ctx.Err() == nil {
+		if sm.IsFinal() {
+			log.Info("tree head is final, no more rotation")
+			<-ctx.Done()
+			return
+		}
 		rotateCtx, _ := context.WithTimeout(ctx, interval)
 
 		currentTH := sm.SignedTreeHead().TreeHead
@@ -131,18 +213,29 @@ func (sm *StateManagerSingle) rotate(ctx context.Context, nextTH *types.TreeHead
 	// Blocks (with no locks held), potentially until context times out.
 	cosignatures := getCosignatures(ctx, &nextSTH)
 
-	sm.Lock()
-	defer sm.Unlock()
-
-	log.Debug("rotating cosigned tree head: previous size %d, new size %d", sm.cosignedTreeHead.Size, nextSTH.Size)
-	sm.cosignedTreeHead = types.CosignedTreeHead{
+	cth := types.CosignedTreeHead{
 		SignedTreeHead: nextSTH,
 		Cosignatures:   cosignatures,
 	}
+	sm.Lock()
+	if sm.final {
+		sm.Unlock()
+		return fmt.Errorf("tree head is final")
+	}
+	log.Debug("rotating cosigned tree head: previous size %d, new size %d", sm.cosignedTreeHead.Size, nextSTH.Size)
+	sm.cosignedTreeHead = cth
+	sm.Unlock()
+
+	for _, publisher := range sm.publishers {
+		publisher.offer(&cth)
+	}
 	return nil
 }
 
 func (sm *StateManagerSingle) signTreeHead(nextTH *types.TreeHead) (types.SignedTreeHead, error) {
+	if sm.IsFinal() {
+		return types.SignedTreeHead{}, fmt.Errorf("tree head is final")
+	}
 	nextSTH, err := nextTH.Sign(sm.signer)
 	if err != nil {
 		return types.SignedTreeHead{}, fmt.Errorf("sign tree head: %v", err)
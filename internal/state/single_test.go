@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -238,3 +239,113 @@ func mustSignTreehead(t *testing.T,
 	}
 	return sth
 }
+
+// Blocks each publication until unblocked.
+type testPublisher struct {
+	unblock   chan struct{}
+	published chan types.CosignedTreeHead
+}
+
+func (p *testPublisher) Publish(_ context.Context, cth *types.CosignedTreeHead) error {
+	<-p.unblock
+	p.published <- *cth
+	return fmt.Errorf("publish error")
+}
+
+func TestRotatePublish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, signer := mustKeyPair(t)
+	publisher := testPublisher{unblock: make(chan struct{}), published: make(chan types.CosignedTreeHead, 10)}
+	sm := StateManagerSingle{
+		signer:           signer,
+		cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: mustSignTreehead(t, signer, 1)},
+		storeSth:         func(*types.SignedTreeHead) error { return nil },
+	}
+	sm.AddPublisher(&publisher)
+	go sm.publishers[0].run(ctx, time.Minute)
+
+	rotate := func(size uint64) types.CosignedTreeHead {
+		nth := types.TreeHead{Size: size}
+		// Publish failure is not a rotation failure, and a
+		// busy publisher doesn't block rotation.
+		if err := sm.rotate(ctx, &nth, func(context.Context, *types.SignedTreeHead) []types.Cosignature {
+			return nil
+		}); err != nil {
+			t.Fatalf("rotate failed: %v", err)
+		}
+		return sm.CosignedTreeHead()
+	}
+	first := rotate(3)
+	// Wait until the publisher is busy with the first tree head.
+	for len(sm.publishers[0].latest) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rotate(4)
+	last := rotate(5)
+	close(publisher.unblock)
+
+	// The tree head of size 4 is skipped.
+	for _, want := range []types.CosignedTreeHead{first, last} {
+		if got := <-publisher.published; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected published tree head, got size %d, expected %d", got.Size, want.Size)
+		}
+	}
+	select {
+	case got := <-publisher.published:
+		t.Errorf("unexpected published tree head of size %d", got.Size)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	ctx := context.Background()
+	_, signer := mustKeyPair(t)
+	sth := mustSignTreehead(t, signer, 2)
+	name := filepath.Join(t.TempDir(), "sth")
+	if err := (sthFile{name: name}).Store(&sth); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := db.NewMockClient(ctrl)
+	client.EXPECT().GetTreeHead(gomock.Any()).Return(types.TreeHead{Size: 3}, nil).Times(1)
+	client.EXPECT().GetTreeHead(gomock.Any()).Return(sth.TreeHead, nil).AnyTimes()
+
+	sm, err := NewStateManagerSingle(client, signer, time.Duration(0), nil, &crypto.PublicKey{}, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.Finalize(ctx); err == nil {
+		t.Errorf("tree head not covering local tree accepted as final")
+	}
+	if sm.IsFinal() {
+		t.Fatalf("tree head final after failure")
+	}
+	final, err := sm.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("finalize failed: %v", err)
+	}
+	if !sm.IsFinal() || final.TreeHead != sth.TreeHead {
+		t.Errorf("unexpected final tree head, size %d", final.Size)
+	}
+	nth := types.TreeHead{Size: 3}
+	if err := sm.rotate(ctx, &nth, func(context.Context, *types.SignedTreeHead) []types.Cosignature {
+		return nil
+	}); err == nil {
+		t.Errorf("rotation of final tree head succeeded")
+	}
+	if got := sm.CosignedTreeHead(); !reflect.DeepEqual(got, final) {
+		t.Errorf("final tree head changed by rotation, got size %d", got.Size)
+	}
+
+	// The final tree head survives restarts.
+	sm, err = NewStateManagerSingle(client, signer, time.Duration(0), nil, &crypto.PublicKey{}, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.CosignedTreeHead(); !sm.IsFinal() || !reflect.DeepEqual(got, final) {
+		t.Errorf("unexpected tree head after restart, size %d, final %v", got.Size, sm.IsFinal())
+	}
+}
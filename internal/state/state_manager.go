@@ -19,3 +19,20 @@ type StateManager interface {
 	// Run periodically rotates the node's tree heads and queries witnesses.
 	Run(context.Context, []policy.Entity, time.Duration)
 }
+
+// Finalizer is implemented by state managers that support retiring
+// the log.
+type Finalizer interface {
+	// IsFinal returns true if the published tree head is final,
+	// i.e., the log is retired.
+	IsFinal() bool
+	// Finalize makes the published tree head final, and persists
+	// it. Fails unless it covers the complete local tree.
+	Finalize(context.Context) (types.CosignedTreeHead, error)
+}
+
+// Publisher is notified of each new cosigned tree head, e.g., to
+// export it for static hosting.
+type Publisher interface {
+	Publish(context.Context, *types.CosignedTreeHead) error
+}
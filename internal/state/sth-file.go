@@ -32,12 +32,19 @@ const (
 	StartupLocalTree
 
 	StartupFileSuffix = ".startup"
+	// The final cosigned tree head of a retired log is stored
+	// next to the sth file.
+	FinalFileSuffix = ".final"
 )
 
 func (s sthFile) startupFileName() string {
 	return s.name + StartupFileSuffix
 }
 
+func (s sthFile) finalFileName() string {
+	return s.name + FinalFileSuffix
+}
+
 func parseStartupFile(f io.Reader) (StartupMode, error) {
 	// TODO: Add a GetString method to sigsum-go's ascii.Parser?
 	scanner := bufio.NewScanner(f)
@@ -100,6 +107,20 @@ func (s sthFile) Load(pub *crypto.PublicKey) (types.SignedTreeHead, error) {
 	return sth, nil
 }
 
+// LoadSthFile reads and verifies the signed tree head stored in the
+// named sth file, for use by tools that inspect a log's state
+// offline.
+func LoadSthFile(name string, pub *crypto.PublicKey) (types.SignedTreeHead, error) {
+	return sthFile{name: name}.Load(pub)
+}
+
+// LoadFinalFile reads and verifies the final cosigned tree head
+// stored next to the named sth file, if the log is retired. Returns
+// nil if there's no final file.
+func LoadFinalFile(sthFileName string, pub *crypto.PublicKey) (*types.CosignedTreeHead, error) {
+	return sthFile{name: sthFileName}.LoadFinal(pub)
+}
+
 // Creates a new sth file. Fails if sth file already exists. On
 // success, any startup file is deleted.
 func (s sthFile) Create(sth *types.SignedTreeHead) error {
@@ -136,3 +157,40 @@ func (s sthFile) Store(sth *types.SignedTreeHead) error {
 	// Atomically replace old file with new.
 	return f.Commit()
 }
+
+// LoadFinal reads and verifies the final cosigned tree head, if the
+// log is retired. Returns nil if there's no final file.
+func (s sthFile) LoadFinal(pub *crypto.PublicKey) (*types.CosignedTreeHead, error) {
+	name := s.finalFileName()
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cth types.CosignedTreeHead
+	if err := cth.FromASCII(f); err != nil {
+		return nil, err
+	}
+	if !cth.Verify(pub) {
+		return nil, fmt.Errorf("invalid signature in file %q", name)
+	}
+	return &cth, nil
+}
+
+// Creates the final file. Fails if the file already exists.
+func (s sthFile) StoreFinal(cth *types.CosignedTreeHead) error {
+	f, err := safefile.Create(s.finalFileName(), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := cth.ToASCII(f); err != nil {
+		return err
+	}
+	// Atomically create file, or fail if file already exists.
+	return f.CommitIfNotExists()
+}
@@ -0,0 +1,128 @@
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Name of the exported cosigned tree head, in the same format as a
+// get-tree-head response.
+const TreeHeadFile = "get-tree-head"
+
+// Exporter writes published tree heads, tiles and leaf bundles to a
+// directory, in the tlog-tiles layout, to be served by a static web
+// server. Each file is written atomically, and the checkpoint and
+// tree head are written last, so that all tiles they refer to are in
+// place.
+type Exporter struct {
+	dir   string
+	tiles *Tiles
+	pub   crypto.PublicKey
+
+	// Protects size.
+	mu sync.Mutex
+	// Size of the latest exported tree. Full tiles up to this
+	// size are not revisited.
+	size uint64
+}
+
+func NewExporter(dir string, tiles *Tiles, pub *crypto.PublicKey) *Exporter {
+	return &Exporter{dir: dir, tiles: tiles, pub: *pub}
+}
+
+// Publish exports the given tree head, and all tiles needed to
+// verify it that are not yet exported.
+func (e *Exporter) Publish(ctx context.Context, cth *types.CosignedTreeHead) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if cth.Size < e.size {
+		return fmt.Errorf("internal error, attempting to export tree of size %d, after size %d", cth.Size, e.size)
+	}
+	for level := EntriesLevel; level <= maxLevel; level++ {
+		count, prevCount := cth.Size, e.size
+		if level > 0 {
+			count >>= TileHeight * level
+			prevCount >>= TileHeight * level
+		}
+		if count == 0 {
+			break
+		}
+		for index := prevCount / TileWidth; index < count/TileWidth; index++ {
+			if err := e.exportTile(ctx, &Tile{Level: level, Index: index, Width: TileWidth}, cth.Size); err != nil {
+				return err
+			}
+		}
+		if width := count % TileWidth; width > 0 {
+			if err := e.exportTile(ctx, &Tile{Level: level, Index: count / TileWidth, Width: int(width)}, cth.Size); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeFile(filepath.Join(e.dir, "checkpoint"), Checkpoint(&cth.SignedTreeHead, &e.pub)); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := cth.ToASCII(&buf); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(e.dir, TreeHeadFile), buf.Bytes()); err != nil {
+		return err
+	}
+	log.Debug("exported tree head of size %d to %q", cth.Size, e.dir)
+	e.size = cth.Size
+	return nil
+}
+
+// Writes a tile, unless it already exists. When a full tile is
+// written, any partial versions of it are deleted.
+func (e *Exporter) exportTile(ctx context.Context, tile *Tile, size uint64) error {
+	name := filepath.Join(e.dir, filepath.FromSlash(tile.Path()))
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	data, err := e.tiles.ReadTile(ctx, tile, size)
+	if err != nil {
+		return fmt.Errorf("reading tile %q failed: %v", tile.Path(), err)
+	}
+	if err := writeFile(name, data); err != nil {
+		return err
+	}
+	if tile.Width == TileWidth {
+		return os.RemoveAll(name + ".p")
+	}
+	return nil
+}
+
+// Atomically creates or replaces a file, creating directories as
+// needed.
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := safefile.Create(name, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	// Atomically replace old file with new.
+	return f.Commit()
+}
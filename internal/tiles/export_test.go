@@ -0,0 +1,95 @@
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := newTestTree(t, 2*TileWidth+10)
+	dir := t.TempDir()
+	tiles := New(tree)
+	exporter := NewExporter(dir, tiles, &pub)
+
+	publish := func(size uint64) *types.CosignedTreeHead {
+		t.Helper()
+		// Root hash isn't checked by the exporter.
+		th := types.TreeHead{Size: size}
+		sth, err := th.Sign(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cth := types.CosignedTreeHead{SignedTreeHead: sth}
+		if err := exporter.Publish(ctx, &cth); err != nil {
+			t.Fatalf("publishing size %d failed: %v", size, err)
+		}
+		return &cth
+	}
+	exists := func(tile Tile) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(tile.Path())))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	publish(TileWidth - 1)
+	for _, tile := range []Tile{{0, 0, TileWidth - 1}, {EntriesLevel, 0, TileWidth - 1}} {
+		if !exists(tile) {
+			t.Errorf("missing tile %q", tile.Path())
+		}
+	}
+	cth := publish(2*TileWidth + 10)
+	for _, tile := range []Tile{{0, 0, TileWidth}, {0, 1, TileWidth}, {0, 2, 10},
+		{EntriesLevel, 0, TileWidth}, {EntriesLevel, 2, 10}, {1, 0, 2}} {
+		if !exists(tile) {
+			t.Errorf("missing tile %q", tile.Path())
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(tile.Path())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, err := tiles.ReadTile(ctx, &tile, cth.Size); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, want) {
+			t.Errorf("unexpected contents of exported tile %q", tile.Path())
+		}
+	}
+	if exists(Tile{0, 0, TileWidth - 1}) {
+		t.Errorf("partial tile not deleted after full tile was exported")
+	}
+	if checkpoint, err := os.ReadFile(filepath.Join(dir, "checkpoint")); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(checkpoint, Checkpoint(&cth.SignedTreeHead, &pub)) {
+		t.Errorf("unexpected checkpoint: %q", checkpoint)
+	}
+	if f, err := os.Open(filepath.Join(dir, TreeHeadFile)); err != nil {
+		t.Error(err)
+	} else {
+		defer f.Close()
+		var got types.CosignedTreeHead
+		if err := got.FromASCII(f); err != nil {
+			t.Error(err)
+		} else if got.SignedTreeHead != cth.SignedTreeHead {
+			t.Errorf("unexpected exported tree head, size %d", got.Size)
+		}
+	}
+
+	if err := exporter.Publish(ctx, &types.CosignedTreeHead{}); err == nil {
+		t.Errorf("exporting smaller tree succeeded")
+	}
+}
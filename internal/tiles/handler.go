@@ -0,0 +1,91 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Full tiles never change, and can be cached indefinitely.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Partial tiles are superseded, and the checkpoint is
+	// replaced, when the log publishes a larger tree.
+	mutableCacheControl = "no-cache"
+)
+
+// Handler serves the checkpoint, tiles and leaf bundles for the
+// currently published tree head.
+type Handler struct {
+	prefix  string
+	timeout time.Duration
+	tiles   *Tiles
+	pub     crypto.PublicKey
+	// Returns the currently published tree head.
+	getTreeHead func() types.CosignedTreeHead
+}
+
+func NewHandler(prefix string, timeout time.Duration, tiles *Tiles, pub *crypto.PublicKey,
+	getTreeHead func() types.CosignedTreeHead) *Handler {
+	return &Handler{
+		prefix:      "/" + path.Join(prefix),
+		timeout:     timeout,
+		tiles:       tiles,
+		pub:         *pub,
+		getTreeHead: getTreeHead,
+	}
+}
+
+// Register adds the handler's endpoints to a mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.Handle(path.Join(h.prefix, "checkpoint"), h)
+	mux.Handle(path.Join(h.prefix, "tile")+"/", h)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, h.prefix), "/")
+	cth := h.getTreeHead()
+	if p == "checkpoint" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", mutableCacheControl)
+		w.Write(Checkpoint(&cth.SignedTreeHead, &h.pub))
+		return
+	}
+	tile, err := ParsePath(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	data, err := h.tiles.ReadTile(ctx, &tile, cth.Size)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("reading tile %q failed: %v", p, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if tile.Width == TileWidth {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", mutableCacheControl)
+	}
+	w.Write(data)
+}
@@ -0,0 +1,107 @@
+package tiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tile height, as specified by C2SP tlog-tiles; each full tile holds
+// TileWidth = 2^TileHeight hashes or leaves.
+const (
+	TileHeight = 8
+	TileWidth  = 1 << TileHeight
+
+	// Largest useful tile level, for a tree size < 2^63.
+	maxLevel = 63 / TileHeight
+)
+
+// Tile identifies a Merkle tile (Level >= 0) or a leaf data bundle
+// (Level == EntriesLevel). Width is in the range 1 to TileWidth,
+// where TileWidth means a full tile.
+type Tile struct {
+	Level int
+	Index uint64
+	Width int
+}
+
+const EntriesLevel = -1
+
+// Encodes an index as groups of three digits, with all but the last
+// group prefixed by "x".
+func formatIndex(index uint64) string {
+	s := fmt.Sprintf("%03d", index%1000)
+	for index >= 1000 {
+		index /= 1000
+		s = fmt.Sprintf("x%03d/%s", index%1000, s)
+	}
+	return s
+}
+
+// Path returns the tile's path, relative to the log's base url.
+func (t *Tile) Path() string {
+	var p string
+	if t.Level == EntriesLevel {
+		p = "tile/entries/" + formatIndex(t.Index)
+	} else {
+		p = fmt.Sprintf("tile/%d/%s", t.Level, formatIndex(t.Index))
+	}
+	if t.Width < TileWidth {
+		p += fmt.Sprintf(".p/%d", t.Width)
+	}
+	return p
+}
+
+func parseDigits(s string) (uint64, error) {
+	if len(s) != 3 || strings.Trim(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid tile index component %q", s)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// ParsePath parses a tile path, relative to the log's base url, i.e.,
+// starting with "tile/".
+func ParsePath(p string) (Tile, error) {
+	parts := strings.Split(p, "/")
+	if len(parts) < 3 || parts[0] != "tile" {
+		return Tile{}, fmt.Errorf("invalid tile path %q", p)
+	}
+	var t Tile
+	if parts[1] == "entries" {
+		t.Level = EntriesLevel
+	} else {
+		level, err := strconv.ParseUint(parts[1], 10, 8)
+		if err != nil || level > maxLevel || (len(parts[1]) > 1 && parts[1][0] == '0') {
+			return Tile{}, fmt.Errorf("invalid tile level %q", parts[1])
+		}
+		t.Level = int(level)
+	}
+	parts = parts[2:]
+	t.Width = TileWidth
+	if n := len(parts); n >= 2 && strings.HasSuffix(parts[n-2], ".p") {
+		width, err := strconv.ParseUint(parts[n-1], 10, 16)
+		if err != nil || width == 0 || width >= TileWidth || parts[n-1][0] == '0' {
+			return Tile{}, fmt.Errorf("invalid partial tile width %q", parts[n-1])
+		}
+		t.Width = int(width)
+		parts[n-2] = strings.TrimSuffix(parts[n-2], ".p")
+		parts = parts[:n-1]
+	}
+	for i, part := range parts {
+		if i < len(parts)-1 {
+			if !strings.HasPrefix(part, "x") {
+				return Tile{}, fmt.Errorf("invalid tile index component %q", part)
+			}
+			part = part[1:]
+		}
+		digits, err := parseDigits(part)
+		if err != nil {
+			return Tile{}, err
+		}
+		if t.Index > (1<<63)/1000 {
+			return Tile{}, fmt.Errorf("tile index too large")
+		}
+		t.Index = 1000*t.Index + digits
+	}
+	return t, nil
+}
@@ -0,0 +1,46 @@
+package tiles
+
+import (
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	for _, table := range []struct {
+		tile Tile
+		path string
+	}{
+		{Tile{0, 0, TileWidth}, "tile/0/000"},
+		{Tile{0, 1234067, TileWidth}, "tile/0/x001/x234/067"},
+		{Tile{1, 1000, 8}, "tile/1/x001/000.p/8"},
+		{Tile{EntriesLevel, 5, TileWidth}, "tile/entries/005"},
+		{Tile{EntriesLevel, 5, 255}, "tile/entries/005.p/255"},
+	} {
+		if got := table.tile.Path(); got != table.path {
+			t.Errorf("unexpected path for %#v, got %q, want %q", table.tile, got, table.path)
+		}
+		tile, err := ParsePath(table.path)
+		if err != nil {
+			t.Errorf("parsing %q failed: %v", table.path, err)
+		} else if tile != table.tile {
+			t.Errorf("unexpected result of parsing %q: %#v", table.path, tile)
+		}
+	}
+	for _, path := range []string{
+		"tile/0",
+		"tile/0/1",
+		"tile/0/0001",
+		"tile/00/000",
+		"tile/8/000",
+		"tile/0/001/002",
+		"tile/0/x001",
+		"tile/0/000.p/0",
+		"tile/0/000.p/256",
+		"tile/0/000.p/08",
+		"tile/0/000.p",
+		"tiles/0/000",
+	} {
+		if tile, err := ParsePath(path); err == nil {
+			t.Errorf("invalid path %q accepted as %#v", path, tile)
+		}
+	}
+}
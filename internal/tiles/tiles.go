@@ -0,0 +1,191 @@
+// Package tiles implements the C2SP tlog-tiles layout, see
+// https://c2sp.org/tlog-tiles, with tiles computed from the log's
+// leaves.
+package tiles
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// ErrNotFound is returned for tiles that are not available at the
+// requested tree size.
+var ErrNotFound = errors.New("tile not available")
+
+// Subset of the db/client interface.
+type LeafSource interface {
+	GetLeaves(context.Context, *requests.Leaves) ([]types.Leaf, error)
+}
+
+// Tiles computes tile contents from a source of leaves. Level 0
+// tiles and leaf bundles are read from the source on each request,
+// while the root hashes of all full tiles that have been needed are
+// kept in memory, from which tiles at higher levels are formed.
+type Tiles struct {
+	source LeafSource
+
+	// Protects nodes. Held while reading leaves from the source,
+	// to not compute the same nodes concurrently.
+	mu sync.Mutex
+	// nodes[i] lists hashes of the tree's nodes at height
+	// (i+1)*TileHeight, i.e., the contents of tiles at level i+1.
+	nodes [][]crypto.Hash
+}
+
+func New(source LeafSource) *Tiles {
+	return &Tiles{source: source}
+}
+
+// ReadTile returns the contents of a tile, which must be included in
+// the tree of the given size.
+func (t *Tiles) ReadTile(ctx context.Context, tile *Tile, size uint64) ([]byte, error) {
+	if tile.Width < 1 || tile.Width > TileWidth || tile.Level < EntriesLevel || tile.Level > maxLevel {
+		return nil, fmt.Errorf("invalid tile %#v", *tile)
+	}
+	start := tile.Index * TileWidth
+	end := start + uint64(tile.Width)
+	available := size
+	if tile.Level > 0 {
+		available = size >> (TileHeight * tile.Level)
+	}
+	if end > available || tile.Index > available/TileWidth {
+		return nil, ErrNotFound
+	}
+	switch tile.Level {
+	case EntriesLevel:
+		leaves, err := t.readLeaves(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+		return entryBundle(leaves), nil
+	case 0:
+		leaves, err := t.readLeaves(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 0, len(leaves)*crypto.HashSize)
+		for _, leaf := range leaves {
+			hash := merkle.HashLeafNode(leaf.ToBinary())
+			buf = append(buf, hash[:]...)
+		}
+		return buf, nil
+	default:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if err := t.extend(ctx, tile.Level-1, end); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 0, tile.Width*crypto.HashSize)
+		for _, hash := range t.nodes[tile.Level-1][start:end] {
+			buf = append(buf, hash[:]...)
+		}
+		return buf, nil
+	}
+}
+
+// Computes node hashes as needed, until there are at least n nodes
+// at height (level+1)*TileHeight. Must be called with the lock held.
+func (t *Tiles) extend(ctx context.Context, level int, n uint64) error {
+	for len(t.nodes) <= level {
+		t.nodes = append(t.nodes, nil)
+	}
+	count := uint64(len(t.nodes[level]))
+	if count >= n {
+		return nil
+	}
+	if level > 0 {
+		if err := t.extend(ctx, level-1, n*TileWidth); err != nil {
+			return err
+		}
+		below := t.nodes[level-1]
+		for i := count; i < n; i++ {
+			t.nodes[level] = append(t.nodes[level], perfectRoot(below[i*TileWidth:(i+1)*TileWidth]))
+		}
+		return nil
+	}
+	for i := count; i < n; i++ {
+		leaves, err := t.readLeaves(ctx, i*TileWidth, (i+1)*TileWidth)
+		if err != nil {
+			return err
+		}
+		hashes := make([]crypto.Hash, len(leaves))
+		for j, leaf := range leaves {
+			hashes[j] = merkle.HashLeafNode(leaf.ToBinary())
+		}
+		// Keep what is computed so far, also if a later read fails.
+		t.nodes[0] = append(t.nodes[0], perfectRoot(hashes))
+	}
+	return nil
+}
+
+// Reads all leaves in the range [start, end), in as many requests as
+// needed.
+func (t *Tiles) readLeaves(ctx context.Context, start, end uint64) ([]types.Leaf, error) {
+	leaves := make([]types.Leaf, 0, end-start)
+	for index := start; index < end; {
+		batch, err := t.source.GetLeaves(ctx, &requests.Leaves{StartIndex: index, EndIndex: end})
+		if err != nil {
+			return nil, fmt.Errorf("reading leaves [%d:%d] failed: %v", index, end, err)
+		}
+		if len(batch) == 0 || uint64(len(batch)) > end-index {
+			return nil, fmt.Errorf("reading leaves [%d:%d] returned unexpected leaf count %d",
+				index, end, len(batch))
+		}
+		leaves = append(leaves, batch...)
+		index += uint64(len(batch))
+	}
+	return leaves, nil
+}
+
+// Each entry is the binary leaf, prefixed by its length as a
+// big-endian uint16.
+func entryBundle(leaves []types.Leaf) []byte {
+	var buf []byte
+	for _, leaf := range leaves {
+		data := leaf.ToBinary()
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+// Root hash of a perfect tree, the number of leaves must be a power
+// of two.
+func perfectRoot(hashes []crypto.Hash) crypto.Hash {
+	for len(hashes) > 1 {
+		next := make([]crypto.Hash, len(hashes)/2)
+		for i := range next {
+			next[i] = merkle.HashInteriorNode(&hashes[2*i], &hashes[2*i+1])
+		}
+		hashes = next
+	}
+	return hashes[0]
+}
+
+// Checkpoint formats a signed tree head as a signed note, as
+// specified by C2SP tlog-checkpoint and signed-note. The signed
+// message of a sigsum tree head is the note body, so the log's
+// signature is included as is. Cosignatures are not included, since
+// note verifiers identify witness keys by name, which the log
+// doesn't know; they are available via get-tree-head.
+func Checkpoint(sth *types.SignedTreeHead, pub *crypto.PublicKey) []byte {
+	keyHash := crypto.HashBytes(pub[:])
+	origin := fmt.Sprintf("sigsum.org/v1/tree/%x", keyHash[:])
+	// Key id, for an Ed25519 note signature, is the first four
+	// bytes of SHA256(name || "\n" || 0x01 || public key).
+	keyId := crypto.HashBytes(append(append([]byte(origin), '\n', 1), pub[:]...))
+	sig := append(keyId[:4:4], sth.Signature[:]...)
+	return []byte(fmt.Sprintf("%s\n%d\n%s\n\n— %s %s\n", origin, sth.Size,
+		base64.StdEncoding.EncodeToString(sth.RootHash[:]),
+		origin, base64.StdEncoding.EncodeToString(sig)))
+}
@@ -0,0 +1,188 @@
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Size of a binary leaf.
+const leafSize = 2*crypto.HashSize + crypto.SignatureSize
+
+// Returns at most 10 leaves per request, like a primary's get-leaves.
+type limitedSource struct {
+	db.Client
+}
+
+func (s limitedSource) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	if req.EndIndex > req.StartIndex+10 {
+		r := *req
+		r.EndIndex = r.StartIndex + 10
+		req = &r
+	}
+	return s.Client.GetLeaves(ctx, req)
+}
+
+func newTestTree(t *testing.T, n int) (db.Client, []crypto.Hash) {
+	t.Helper()
+	tree := db.NewMemoryDb()
+	leaves := make([]types.Leaf, n)
+	hashes := make([]crypto.Hash, n)
+	for i := range leaves {
+		var blob [8]byte
+		binary.BigEndian.PutUint64(blob[:], uint64(i))
+		leaves[i].Checksum = crypto.HashBytes(blob[:])
+		hashes[i] = merkle.HashLeafNode(leaves[i].ToBinary())
+	}
+	if err := tree.AddSequencedLeaves(context.Background(), leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	return tree, hashes
+}
+
+func concatHashes(hashes []crypto.Hash) []byte {
+	var buf []byte
+	for _, h := range hashes {
+		buf = append(buf, h[:]...)
+	}
+	return buf
+}
+
+func TestReadTile(t *testing.T) {
+	ctx := context.Background()
+	size := uint64(TileWidth*TileWidth + 600)
+	tree, hashes := newTestTree(t, int(size))
+	tiles := New(limitedSource{tree})
+
+	level1 := make([]crypto.Hash, size/TileWidth)
+	for i := range level1 {
+		level1[i] = perfectRoot(hashes[i*TileWidth : (i+1)*TileWidth])
+	}
+	level2 := perfectRoot(level1[:TileWidth])
+
+	for _, table := range []struct {
+		tile Tile
+		want []byte
+	}{
+		{Tile{0, 0, TileWidth}, concatHashes(hashes[:TileWidth])},
+		{Tile{0, 256, 5}, concatHashes(hashes[256*TileWidth : 256*TileWidth+5])},
+		{Tile{0, 258, 88}, concatHashes(hashes[258*TileWidth : 258*TileWidth+88])},
+		{Tile{1, 0, TileWidth}, concatHashes(level1[:TileWidth])},
+		{Tile{1, 1, 2}, concatHashes(level1[TileWidth:])},
+		{Tile{2, 0, 1}, level2[:]},
+	} {
+		data, err := tiles.ReadTile(ctx, &table.tile, size)
+		if err != nil {
+			t.Errorf("reading tile %q failed: %v", table.tile.Path(), err)
+		} else if !bytes.Equal(data, table.want) {
+			t.Errorf("unexpected contents of tile %q", table.tile.Path())
+		}
+	}
+
+	entries, err := tiles.ReadTile(ctx, &Tile{EntriesLevel, 1, 3}, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 3*(2+leafSize); got != want {
+		t.Errorf("unexpected entry bundle size, got %d, want %d", got, want)
+	} else if hash := merkle.HashLeafNode(entries[2 : 2+leafSize]); hash != hashes[TileWidth] {
+		t.Errorf("unexpected first entry in bundle")
+	}
+
+	for _, tile := range []Tile{
+		{0, 258, 89},
+		{0, 259, 1},
+		{1, 1, 3},
+		{1, 2, 1},
+		{2, 0, 2},
+		{3, 0, 1},
+		{EntriesLevel, 258, TileWidth},
+	} {
+		if _, err := tiles.ReadTile(ctx, &tile, size); !errors.Is(err, ErrNotFound) {
+			t.Errorf("tile %q: expected ErrNotFound, got %v", tile.Path(), err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, hashes := newTestTree(t, 300)
+	th := types.TreeHead{Size: 300, RootHash: perfectRoot(hashes[:TileWidth])}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewHandler("test", time.Minute, New(tree), &pub, func() types.CosignedTreeHead {
+		return types.CosignedTreeHead{SignedTreeHead: sth}
+	}).Register(mux)
+
+	for _, table := range []struct {
+		path         string
+		status       int
+		cacheControl string
+	}{
+		{"/test/checkpoint", http.StatusOK, mutableCacheControl},
+		{"/test/tile/0/000", http.StatusOK, immutableCacheControl},
+		{"/test/tile/0/001.p/44", http.StatusOK, mutableCacheControl},
+		{"/test/tile/entries/000", http.StatusOK, immutableCacheControl},
+		{"/test/tile/0/001.p/45", http.StatusNotFound, ""},
+		{"/test/tile/0/001", http.StatusNotFound, ""},
+		{"/test/tile/0/1", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, table.path, nil))
+		if w.Code != table.status {
+			t.Errorf("%s: unexpected status, got %d, want %d", table.path, w.Code, table.status)
+			continue
+		}
+		if table.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Cache-Control"); got != table.cacheControl {
+			t.Errorf("%s: unexpected Cache-Control, got %q, want %q", table.path, got, table.cacheControl)
+		}
+		if table.path == "/test/checkpoint" && !strings.HasPrefix(w.Body.String(), "sigsum.org/v1/tree/") {
+			t.Errorf("unexpected checkpoint: %q", w.Body.String())
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	th := types.TreeHead{Size: 3, RootHash: crypto.Hash{1}}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := string(Checkpoint(&sth, &pub))
+	body, sigs, found := strings.Cut(note, "\n\n")
+	if !found {
+		t.Fatalf("invalid note: %q", note)
+	}
+	lines := strings.Split(body, "\n")
+	if len(lines) != 3 || lines[1] != "3" {
+		t.Errorf("unexpected note body: %q", body)
+	}
+	if !strings.HasPrefix(sigs, "— "+lines[0]+" ") {
+		t.Errorf("unexpected note signature: %q", sigs)
+	}
+}
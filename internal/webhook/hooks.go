@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// Hook delivers a notification.
+type Hook interface {
+	Deliver(ctx context.Context, body []byte, signature string) error
+}
+
+// Posts the notification to a url.
+type httpHook struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpHook) Deliver(ctx context.Context, body []byte, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Sigsum log-go server")
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set(SignatureHeader, signature)
+	rsp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(rsp.Body, 1000))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("notification rejected: %s", rsp.Status)
+	}
+	return nil
+}
+
+// Posts the notification over http to a unix socket.
+func newUnixHook(socket string) Hook {
+	return &httpHook{
+		url: "http://localhost/",
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+}
+
+// Runs a program, with the notification on stdin, and the signature
+// in the environment variable SIGSUM_NOTIFICATION_SIGNATURE.
+type execHook struct {
+	program string
+}
+
+func (h *execHook) Deliver(ctx context.Context, body []byte, signature string) error {
+	cmd := exec.CommandContext(ctx, h.program)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = []string{"SIGSUM_NOTIFICATION_SIGNATURE=" + signature}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("hook %q failed: %v, output: %q", h.program, err, truncate(string(out), 200))
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// ReadHooks reads the operator's named local hooks. Syntax is
+//
+//	unix <name> <socket file>
+//	exec <name> <program>
+//
+// with # used for comments. Clients refer to a hook as "hook:<name>".
+func ReadHooks(r io.Reader) (map[string]Hook, error) {
+	hooks := make(map[string]Hook)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: invalid hook line %q", lineno, line)
+		}
+		name := fields[1]
+		if _, ok := hooks[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate hook %q", lineno, name)
+		}
+		switch fields[0] {
+		case "unix":
+			hooks[name] = newUnixHook(fields[2])
+		case "exec":
+			hooks[name] = &execHook{program: fields[2]}
+		default:
+			return nil, fmt.Errorf("line %d: unknown hook type %q", lineno, fields[0])
+		}
+	}
+	return hooks, scanner.Err()
+}
+
+// Checks that a url is acceptable as a callback target.
+func checkURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return fmt.Errorf("missing host in url")
+	}
+	return nil
+}
@@ -0,0 +1,474 @@
+// Package webhook implements notifications to submitters when their
+// leaves are published. An add-leaf request with the header
+// "Sigsum-Callback: <target>" registers a callback for the leaf, and
+// once the primary publishes a cosigned tree head including the leaf,
+// a signed notification is delivered to the target. The target is
+// either an http(s) url, if allowed by the operator, or
+// "hook:<name>", referring to a local hook configured by the
+// operator, which posts to a unix socket or runs a program.
+//
+// The notification is, in sigsum's ascii format, the leaf hash
+// (leaf_hash=...), followed by the cosigned tree head, as returned by
+// get-tree-head, and the inclusion proof, as returned by
+// get-inclusion-proof. The notification is signed by the log's key,
+// see VerifyNotification.
+//
+// Pending callbacks are stored in a queue file, which is a journal of
+// changes, so they survive restarts. Inclusion of a pending leaf is
+// checked once for each newly published tree head, and failed
+// deliveries are retried with exponential backoff. Callbacks for
+// leaves that are not published within MaxAge are dropped.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/journal"
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	CallbackHeader  = "Sigsum-Callback"
+	SignatureHeader = "Sigsum-Notification-Signature"
+	// Response header set when the leaf was accepted, but
+	// registering the callback failed. The value is the reason;
+	// the client can resubmit the leaf to retry.
+	ErrorHeader = "Sigsum-Callback-Error"
+
+	// Maximum number of pending callbacks.
+	MaxQueueSize = 10000
+	// Maximum number of pending callbacks for a single leaf.
+	MaxPerLeaf = 4
+	// Maximum time from registration to delivery of a callback.
+	// Leaves are normally published within a few intervals, so
+	// older callbacks are for leaves that were lost, e.g., by the
+	// backend.
+	MaxAge = 24 * time.Hour
+
+	signatureNamespace = "sigsum.org/v1/leaf-notification"
+	hookPrefix         = "hook:"
+	maxTargetLength    = 1000
+	maxAttempts        = 10
+	initialBackoff     = 10 * time.Second
+	maxBackoff         = time.Hour
+	// Interval for retrying failed deliveries.
+	retryInterval = 10 * time.Second
+)
+
+var (
+	ErrQueueFull      = errors.New("too many pending callbacks")
+	ErrTooManyForLeaf = errors.New("too many pending callbacks for leaf")
+)
+
+// Tree is the subset of the db.Client interface used for creating
+// inclusion proofs.
+type Tree interface {
+	GetInclusionProof(context.Context, *requests.InclusionProof) (types.InclusionProof, error)
+}
+
+type entry struct {
+	leafHash   crypto.Hash
+	target     string
+	registered time.Time // Time of registration, for expiry
+	attempts   int       // Number of failed deliveries
+	next       time.Time // Earliest time for the next delivery attempt
+	// Published tree size at which the leaf was last found not
+	// included, or zero. Not persisted.
+	checked uint64
+}
+
+type entryKey struct {
+	leafHash crypto.Hash
+	target   string
+}
+
+func (e *entry) key() entryKey {
+	return entryKey{e.leafHash, e.target}
+}
+
+// Queue keeps track of pending callbacks, and delivers notifications.
+// It implements state.Publisher.
+type Queue struct {
+	signer     crypto.Signer
+	tree       Tree
+	hooks      map[string]Hook
+	allowURLs  bool
+	httpClient *http.Client
+	timeout    time.Duration
+	now        func() time.Time
+	kick       chan struct{}
+
+	mu      sync.Mutex
+	journal *journal.Journal
+	entries map[entryKey]entry
+	perLeaf map[crypto.Hash]int     // Number of entries for each leaf
+	cth     *types.CosignedTreeHead // Latest published tree head, if any
+}
+
+// NewQueue creates a queue persisted in file, loading any pending
+// callbacks. Notifications are signed by signer, which should be the
+// log's key. The timeout applies to each delivery attempt.
+func NewQueue(file string, signer crypto.Signer, tree Tree, hooks map[string]Hook, allowURLs bool,
+	timeout time.Duration) (*Queue, error) {
+	q := Queue{
+		signer:     signer,
+		tree:       tree,
+		hooks:      hooks,
+		allowURLs:  allowURLs,
+		httpClient: &http.Client{},
+		timeout:    timeout,
+		now:        time.Now,
+		kick:       make(chan struct{}, 1),
+	}
+	j, records, err := journal.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if q.entries, err = replay(records); err != nil {
+		return nil, fmt.Errorf("reading callback queue %q failed: %v", file, err)
+	}
+	q.perLeaf = make(map[crypto.Hash]int)
+	for key := range q.entries {
+		q.perLeaf[key.leafHash]++
+	}
+	q.journal = j
+	if err := j.MaybeCompact(len(q.entries), q.records); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// Returns the hook for a target, or an error if the target isn't
+// allowed.
+func (q *Queue) hook(target string) (Hook, error) {
+	if len(target) > maxTargetLength || strings.ContainsAny(target, " \t\r\n") {
+		return nil, fmt.Errorf("invalid callback target")
+	}
+	if strings.HasPrefix(target, hookPrefix) {
+		if hook, ok := q.hooks[strings.TrimPrefix(target, hookPrefix)]; ok {
+			return hook, nil
+		}
+		return nil, fmt.Errorf("unknown callback hook %q", target)
+	}
+	if !q.allowURLs {
+		return nil, fmt.Errorf("callback urls not allowed by this log")
+	}
+	if err := checkURL(target); err != nil {
+		return nil, fmt.Errorf("invalid callback url: %v", err)
+	}
+	return &httpHook{url: target, client: q.httpClient}, nil
+}
+
+// CheckTarget returns an error if the target isn't allowed.
+func (q *Queue) CheckTarget(target string) error {
+	_, err := q.hook(target)
+	return err
+}
+
+// Register adds a callback for a leaf. Registering the same callback
+// again is a no-op. Fails with ErrTooManyForLeaf if MaxPerLeaf
+// other callbacks are pending for the leaf.
+func (q *Queue) Register(leafHash *crypto.Hash, target string) error {
+	if err := q.CheckTarget(target); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := entry{leafHash: *leafHash, target: target, registered: time.Unix(q.now().Unix(), 0)}
+	if _, ok := q.entries[e.key()]; ok {
+		return nil
+	}
+	if len(q.entries) >= MaxQueueSize {
+		return ErrQueueFull
+	}
+	if q.perLeaf[e.leafHash] >= MaxPerLeaf {
+		return ErrTooManyForLeaf
+	}
+	if err := q.journal.Append(addRecord(&e)); err != nil {
+		return err
+	}
+	q.entries[e.key()] = e
+	q.perLeaf[e.leafHash]++
+	q.wake()
+	return nil
+}
+
+// Publish wakes up the delivery routine, for callbacks for leaves
+// included in the newly published tree head.
+func (q *Queue) Publish(_ context.Context, cth *types.CosignedTreeHead) error {
+	q.mu.Lock()
+	q.cth = cth
+	q.mu.Unlock()
+	q.wake()
+	return nil
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.kick:
+		}
+		q.deliverDue(ctx)
+	}
+}
+
+type outcome struct {
+	e    entry
+	done bool // Delivered, given up or expired
+}
+
+// Attempts delivery of all due callbacks for leaves in the published
+// tree, and updates the queue accordingly. Inclusion of each leaf is
+// checked only once per published tree size.
+func (q *Queue) deliverDue(ctx context.Context) {
+	q.mu.Lock()
+	cth := q.cth
+	var due []entry
+	var outcomes []outcome
+	now := q.now()
+	for _, e := range q.entries {
+		switch {
+		case now.Sub(e.registered) > MaxAge:
+			log.Warning("dropping callback for leaf %x to %q, not delivered within %v",
+				e.leafHash, e.target, MaxAge)
+			outcomes = append(outcomes, outcome{e: e, done: true})
+		case cth == nil || e.next.After(now) || e.checked >= cth.Size:
+		default:
+			due = append(due, e)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, e := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		proof, err := q.inclusionProof(ctx, &e.leafHash, &cth.TreeHead)
+		if errors.Is(err, db.ErrNotIncluded) {
+			e.checked = cth.Size
+			outcomes = append(outcomes, outcome{e: e})
+			continue
+		}
+		if err == nil {
+			err = q.deliver(ctx, &e, cth, &proof)
+		}
+		if err == nil {
+			log.Debug("delivered notification for leaf %x to %q", e.leafHash, e.target)
+			outcomes = append(outcomes, outcome{e: e, done: true})
+			continue
+		}
+		e.attempts++
+		backoff := initialBackoff << (e.attempts - 1)
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		e.next = time.Unix(q.now().Add(backoff).Unix(), 0)
+		if e.attempts >= maxAttempts {
+			log.Warning("giving up notification for leaf %x to %q after %d attempts: %v",
+				e.leafHash, e.target, e.attempts, err)
+			outcomes = append(outcomes, outcome{e: e, done: true})
+			continue
+		}
+		log.Debug("notification for leaf %x to %q failed, attempt %d: %v",
+			e.leafHash, e.target, e.attempts, err)
+		outcomes = append(outcomes, outcome{e: e})
+	}
+	if len(outcomes) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var records []string
+	for _, o := range outcomes {
+		old, ok := q.entries[o.e.key()]
+		if !ok {
+			continue
+		}
+		switch {
+		case o.done:
+			delete(q.entries, o.e.key())
+			if q.perLeaf[o.e.leafHash]--; q.perLeaf[o.e.leafHash] <= 0 {
+				delete(q.perLeaf, o.e.leafHash)
+			}
+			records = append(records, doneRecord(&o.e))
+		case o.e.attempts > old.attempts:
+			q.entries[o.e.key()] = o.e
+			records = append(records, retryRecord(&o.e))
+		default:
+			old.checked = o.e.checked
+			q.entries[o.e.key()] = old
+		}
+	}
+	if len(records) == 0 {
+		return
+	}
+	// A failed write loses only the delivery state, so after a
+	// restart, these callbacks may be delivered once more.
+	if err := q.journal.Append(records...); err != nil {
+		log.Error("storing callback queue failed: %v", err)
+	} else if err := q.journal.MaybeCompact(len(q.entries), q.records); err != nil {
+		log.Error("compacting callback queue failed: %v", err)
+	}
+}
+
+func (q *Queue) inclusionProof(ctx context.Context, leafHash *crypto.Hash, th *types.TreeHead) (types.InclusionProof, error) {
+	if th.Size == 0 {
+		return types.InclusionProof{}, db.ErrNotIncluded
+	}
+	return q.tree.GetInclusionProof(ctx, &requests.InclusionProof{Size: th.Size, LeafHash: *leafHash})
+}
+
+func (q *Queue) deliver(ctx context.Context, e *entry, cth *types.CosignedTreeHead, proof *types.InclusionProof) error {
+	hook, err := q.hook(e.target)
+	if err != nil {
+		return err
+	}
+	body, err := notificationBody(&e.leafHash, cth, proof)
+	if err != nil {
+		return err
+	}
+	signature, err := q.signer.Sign(signedData(body))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return hook.Deliver(ctx, body, hex.EncodeToString(signature[:]))
+}
+
+func notificationBody(leafHash *crypto.Hash, cth *types.CosignedTreeHead, proof *types.InclusionProof) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := ascii.WriteHash(&buf, "leaf_hash", leafHash); err != nil {
+		return nil, err
+	}
+	if err := cth.ToASCII(&buf); err != nil {
+		return nil, err
+	}
+	if err := proof.ToASCII(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func signedData(body []byte) []byte {
+	return []byte(fmt.Sprintf("%s\n%x\n", signatureNamespace, sha256.Sum256(body)))
+}
+
+// VerifyNotification checks the hex-encoded signature on a
+// notification body.
+func VerifyNotification(pub *crypto.PublicKey, body []byte, signature string) error {
+	sig, err := crypto.SignatureFromHex(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if !crypto.Verify(pub, signedData(body), &sig) {
+		return fmt.Errorf("invalid notification signature")
+	}
+	return nil
+}
+
+// The queue file is a journal, see package journal, with the records
+//
+//	add <leaf hash> <registration, unix time> <target>
+//	retry <leaf hash> <failed attempts> <next attempt, unix time> <target>
+//	done <leaf hash> <target>
+//
+// for a registered callback, a failed delivery, and a callback that is
+// delivered, given up or expired, respectively.
+func addRecord(e *entry) string {
+	return fmt.Sprintf("add %x %d %s", e.leafHash, e.registered.Unix(), e.target)
+}
+
+func retryRecord(e *entry) string {
+	return fmt.Sprintf("retry %x %d %d %s", e.leafHash, e.attempts, e.next.Unix(), e.target)
+}
+
+func doneRecord(e *entry) string {
+	return fmt.Sprintf("done %x %s", e.leafHash, e.target)
+}
+
+func replay(records []string) (map[entryKey]entry, error) {
+	entries := make(map[entryKey]entry)
+	for _, record := range records {
+		fields := strings.Fields(record)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid queue record %q", record)
+		}
+		var e entry
+		var err error
+		if e.leafHash, err = crypto.HashFromHex(fields[1]); err != nil {
+			return nil, err
+		}
+		e.target = fields[len(fields)-1]
+		switch {
+		case fields[0] == "add" && len(fields) == 4:
+			registered, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			e.registered = time.Unix(registered, 0)
+			entries[e.key()] = e
+		case fields[0] == "retry" && len(fields) == 5:
+			old, ok := entries[e.key()]
+			if !ok {
+				return nil, fmt.Errorf("retry of unknown callback in queue record %q", record)
+			}
+			if old.attempts, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, err
+			}
+			next, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			old.next = time.Unix(next, 0)
+			entries[e.key()] = old
+		case fields[0] == "done" && len(fields) == 3:
+			delete(entries, e.key())
+		default:
+			return nil, fmt.Errorf("invalid queue record %q", record)
+		}
+	}
+	return entries, nil
+}
+
+// Returns the records describing the pending callbacks, for
+// compacting the queue file. Called with q.mu held, except while
+// loading the queue in NewQueue.
+func (q *Queue) records() []string {
+	var records []string
+	for _, e := range q.entries {
+		records = append(records, addRecord(&e))
+		if e.attempts > 0 {
+			records = append(records, retryRecord(&e))
+		}
+	}
+	return records
+}
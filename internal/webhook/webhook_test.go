@@ -0,0 +1,344 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/journal"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type testHook struct {
+	fail       bool
+	bodies     [][]byte
+	signatures []string
+}
+
+func (h *testHook) Deliver(_ context.Context, body []byte, signature string) error {
+	if h.fail {
+		return fmt.Errorf("delivery failed")
+	}
+	h.bodies = append(h.bodies, body)
+	h.signatures = append(h.signatures, signature)
+	return nil
+}
+
+func mustLeafRequests(t *testing.T, n int) ([]requests.Leaf, []types.Leaf) {
+	t.Helper()
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := make([]requests.Leaf, n)
+	leaves := make([]types.Leaf, n)
+	for i := range reqs {
+		reqs[i] = requests.Leaf{Message: crypto.Hash{byte(i)}, PublicKey: signer.Public()}
+		if reqs[i].Signature, err = types.SignLeafMessage(signer, reqs[i].Message[:]); err != nil {
+			t.Fatal(err)
+		}
+		if leaves[i], err = reqs[i].Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reqs, leaves
+}
+
+func mustTreeHead(t *testing.T, tree db.Client, signer crypto.Signer) *types.CosignedTreeHead {
+	t.Helper()
+	th, err := tree.GetTreeHead(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.CosignedTreeHead{SignedTreeHead: sth}
+}
+
+// Counts inclusion proof requests.
+type countingTree struct {
+	Tree
+	count int
+}
+
+func (t *countingTree) GetInclusionProof(ctx context.Context, req *requests.InclusionProof) (types.InclusionProof, error) {
+	t.count++
+	return t.Tree.GetInclusionProof(ctx, req)
+}
+
+// Returns the only pending callback.
+func onlyEntry(t *testing.T, q *Queue) entry {
+	t.Helper()
+	if len(q.entries) != 1 {
+		t.Fatalf("unexpected queue length %d", len(q.entries))
+	}
+	for _, e := range q.entries {
+		return e
+	}
+	panic("not reached")
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, leaves := mustLeafRequests(t, 3)
+	memDb := db.NewMemoryDb()
+	tree := &countingTree{Tree: memDb}
+	if err := memDb.AddSequencedLeaves(ctx, leaves[:2], 0); err != nil {
+		t.Fatal(err)
+	}
+	small := mustTreeHead(t, memDb, logSigner)
+	if err := memDb.AddSequencedLeaves(ctx, leaves[2:], 2); err != nil {
+		t.Fatal(err)
+	}
+	large := mustTreeHead(t, memDb, logSigner)
+
+	hook := &testHook{fail: true}
+	file := filepath.Join(t.TempDir(), "queue")
+	q, err := NewQueue(file, logSigner, tree, map[string]Hook{"test": hook}, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+
+	leafHash := merkle.HashLeafNode(leaves[2].ToBinary())
+	if err := q.Register(&leafHash, "hook:test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Register(&leafHash, "hook:test"); err != nil {
+		t.Fatal(err)
+	}
+	q.Publish(ctx, small)
+	q.deliverDue(ctx)
+	if e := onlyEntry(t, q); e.attempts != 0 {
+		t.Fatalf("unexpected queue before leaf is published: %v", e)
+	}
+	// Inclusion is checked once per published tree head.
+	q.deliverDue(ctx)
+	if tree.count != 1 {
+		t.Errorf("unexpected number of inclusion checks %d", tree.count)
+	}
+
+	// Failed delivery is retried after backoff, and persisted.
+	q.Publish(ctx, large)
+	q.deliverDue(ctx)
+	e := onlyEntry(t, q)
+	if e.attempts != 1 {
+		t.Fatalf("unexpected queue after failed delivery: %v", e)
+	}
+	reloaded, err := NewQueue(file, logSigner, tree, nil, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.checked = 0 // Not persisted
+	if got := onlyEntry(t, reloaded); got != e {
+		t.Errorf("unexpected reloaded queue: %v, wanted %v", got, e)
+	}
+	hook.fail = false
+	q.deliverDue(ctx)
+	if len(hook.bodies) != 0 {
+		t.Errorf("delivery retried before backoff")
+	}
+	now = now.Add(initialBackoff)
+	q.deliverDue(ctx)
+	if len(hook.bodies) != 1 {
+		t.Fatalf("unexpected number of deliveries: %d", len(hook.bodies))
+	}
+	if len(q.entries) != 0 {
+		t.Errorf("delivered callback still queued: %v", q.entries)
+	}
+	if err := VerifyNotification(&logPub, hook.bodies[0], hook.signatures[0]); err != nil {
+		t.Errorf("invalid notification: %v", err)
+	}
+	if want := fmt.Sprintf("leaf_hash=%x\n", leafHash); !strings.HasPrefix(string(hook.bodies[0]), want) {
+		t.Errorf("unexpected notification %q", hook.bodies[0])
+	}
+	if err := VerifyNotification(&logPub, append(hook.bodies[0], '\n'), hook.signatures[0]); err == nil {
+		t.Errorf("modified notification accepted")
+	}
+	if reloaded, err = NewQueue(file, logSigner, tree, nil, false, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if len(reloaded.entries) != 0 {
+		t.Errorf("delivered callback still in queue file: %v", reloaded.entries)
+	}
+}
+
+func TestQueueExpiry(t *testing.T) {
+	ctx := context.Background()
+	_, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := db.NewMemoryDb()
+	file := filepath.Join(t.TempDir(), "queue")
+	q, err := NewQueue(file, logSigner, tree, map[string]Hook{"test": &testHook{}}, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+	q.Publish(ctx, mustTreeHead(t, tree, logSigner))
+
+	leafHash := crypto.Hash{1}
+	if err := q.Register(&leafHash, "hook:test"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(MaxAge)
+	q.deliverDue(ctx)
+	onlyEntry(t, q)
+
+	now = now.Add(time.Second)
+	q.deliverDue(ctx)
+	if len(q.entries) != 0 {
+		t.Errorf("expired callback still queued: %v", q.entries)
+	}
+	if reloaded, err := NewQueue(file, logSigner, tree, nil, false, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if len(reloaded.entries) != 0 {
+		t.Errorf("expired callback still in queue file: %v", reloaded.entries)
+	}
+}
+
+func TestQueuePerLeaf(t *testing.T) {
+	ctx := context.Background()
+	_, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := db.NewMemoryDb()
+	hooks := make(map[string]Hook)
+	for i := 0; i <= MaxPerLeaf; i++ {
+		hooks[fmt.Sprintf("h%d", i)] = &testHook{}
+	}
+	file := filepath.Join(t.TempDir(), "queue")
+	q, err := NewQueue(file, logSigner, tree, hooks, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+	q.Publish(ctx, mustTreeHead(t, tree, logSigner))
+
+	leafHash := crypto.Hash{1}
+	for i := 0; i < MaxPerLeaf; i++ {
+		if err := q.Register(&leafHash, fmt.Sprintf("hook:h%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Register(&leafHash, "hook:h0"); err != nil {
+		t.Errorf("registering existing callback failed: %v", err)
+	}
+	last := fmt.Sprintf("hook:h%d", MaxPerLeaf)
+	if err := q.Register(&leafHash, last); err != ErrTooManyForLeaf {
+		t.Errorf("unexpected error for too many callbacks: %v", err)
+	}
+	if err := q.Register(&crypto.Hash{2}, last); err != nil {
+		t.Errorf("registering callback for other leaf failed: %v", err)
+	}
+
+	reloaded, err := NewQueue(file, logSigner, tree, hooks, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.now = q.now
+	if err := reloaded.Register(&leafHash, last); err != ErrTooManyForLeaf {
+		t.Errorf("unexpected error for too many callbacks after reload: %v", err)
+	}
+
+	// Expired callbacks no longer count.
+	now = now.Add(MaxAge + time.Second)
+	q.deliverDue(ctx)
+	if err := q.Register(&leafHash, last); err != nil {
+		t.Errorf("registering callback after expiry failed: %v", err)
+	}
+}
+
+func TestQueueCompaction(t *testing.T) {
+	_, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "queue")
+	q, err := NewQueue(file, logSigner, db.NewMemoryDb(), map[string]Hook{"test": &testHook{}}, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Many delivered callbacks, and a pending one with a failed
+	// delivery.
+	var records []string
+	for i := 0; i < journal.CompactSlack; i++ {
+		e := entry{leafHash: crypto.Hash{byte(i), byte(i >> 8)}, target: "hook:test"}
+		records = append(records, addRecord(&e), doneRecord(&e))
+	}
+	e := entry{leafHash: crypto.Hash{1}, target: "hook:test", registered: time.Unix(1700000000, 0),
+		attempts: 2, next: time.Unix(1700000100, 0)}
+	records = append(records, addRecord(&e), retryRecord(&e))
+	if err := q.journal.Append(records...); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewQueue(file, logSigner, db.NewMemoryDb(), nil, false, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := onlyEntry(t, reloaded); got != e {
+		t.Errorf("unexpected reloaded queue: %v, wanted %v", got, e)
+	}
+	if got := reloaded.journal.Records(); got != 2 {
+		t.Errorf("queue file not compacted, %d records", got)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	for _, table := range []struct {
+		target    string
+		allowURLs bool
+		wantErr   bool
+	}{
+		{"hook:test", false, false},
+		{"hook:other", false, true},
+		{"https://example.org/notify", false, true},
+		{"https://example.org/notify", true, false},
+		{"ftp://example.org/notify", true, true},
+		{"https:///notify", true, true},
+		{"https://example.org/a b", true, true},
+	} {
+		q := Queue{hooks: map[string]Hook{"test": &testHook{}}, allowURLs: table.allowURLs}
+		if err := q.CheckTarget(table.target); (err != nil) != table.wantErr {
+			t.Errorf("unexpected result for %q (allowURLs %v): %v", table.target, table.allowURLs, err)
+		}
+	}
+}
+
+func TestReadHooks(t *testing.T) {
+	hooks, err := ReadHooks(bytes.NewBufferString(`
+# Comment
+unix ci /run/ci/notify.sock
+exec release /usr/local/bin/notify-release # Trailing comment
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 || hooks["ci"] == nil || hooks["release"] == nil {
+		t.Errorf("unexpected hooks: %v", hooks)
+	}
+	for _, bad := range []string{"unix ci\n", "tcp ci localhost:80\n", "exec a /bin/a\nexec a /bin/b\n"} {
+		if _, err := ReadHooks(bytes.NewBufferString(bad)); err == nil {
+			t.Errorf("invalid hooks file %q accepted", bad)
+		}
+	}
+}